@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log/syslog"
+	"testing"
+)
+
+func TestParseSyslogPriority(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected syslog.Priority
+	}{
+		{"info", syslog.LOG_USER | syslog.LOG_INFO},
+		{"local0.notice", syslog.LOG_LOCAL0 | syslog.LOG_NOTICE},
+		{"DAEMON.Warning", syslog.LOG_DAEMON | syslog.LOG_WARNING},
+	}
+	for _, tt := range tests {
+		if got, err := parseSyslogPriority(tt.value); err != nil || got != tt.expected {
+			t.Errorf("parseSyslogPriority(%q) = %v, %v; expected %v", tt.value, got, err, tt.expected)
+		}
+	}
+	for _, value := range []string{"loud", "local9.info", "user."} {
+		if _, err := parseSyslogPriority(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
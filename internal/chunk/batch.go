@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+)
+
+// batchSavepoint is the savepoint taken before each chunk of a batch. MySQL
+// moves a savepoint that is set again under the same name.
+const batchSavepoint = "go_chunk_update"
+
+// batchState tracks the chunks executed in the open transaction when
+// BatchCommit groups several chunks per commit.
+type batchState struct {
+	// pending counts the executed chunks not yet committed, end holds the
+	// upper boundary of the last of them and reports their reports, which
+	// are only reported done once the chunks are committed
+	pending int
+	end     []interface{}
+	reports []ChunkReport
+	// failed is set when the last chunk must not be committed
+	failed bool
+}
+
+// batching reports whether chunks run in explicit transactions rather than
+// one autocommitted statement at a time: in batches, or one chunk per
+// transaction so that its locking boundary read and its statement share one.
+func (c *Chunker) batching() bool {
+	return c.Config.BatchCommit > 1 || c.Config.LockBoundaryReads
+}
+
+// beginBatchChunk sets the savepoint a failing chunk rolls back to.
+func (c *Chunker) beginBatchChunk() error {
+	if !c.batching() || !c.Config.BatchSavepoints {
+		return nil
+	}
+	_, err := c.db.Exec(c.ctx, "SAVEPOINT "+batchSavepoint)
+	return err
+}
+
+// addToBatch records an executed chunk ending at end, committing the batch
+// once it holds BatchCommit chunks.
+func (c *Chunker) addToBatch(end []interface{}, report ChunkReport) error {
+	c.batch.pending++
+	c.batch.end = end
+	c.batch.reports = append(c.batch.reports, report)
+	if c.batch.pending < c.Config.BatchCommit {
+		return nil
+	}
+	return c.commitBatch()
+}
+
+// commitBatch commits the pending chunks and only then reports them done,
+// checkpoints them and marks them done in the manifest.
+func (c *Chunker) commitBatch() error {
+	if c.batch.pending == 0 {
+		return nil
+	}
+	if _, err := c.db.Exec(c.ctx, "COMMIT"); err != nil {
+		return err
+	}
+	c.Verbose(fmt.Sprintf("Committed %d chunks", c.batch.pending))
+	for _, report := range c.batch.reports {
+		c.report().ChunkDone(report)
+	}
+	c.batch.pending = 0
+	c.batch.reports = nil
+	c.committed(c.batch.end)
+	if err := c.markManifest(c.batch.end); err != nil {
+		return err
+	}
+	if c.Config.CheckpointFile != "" {
+		return c.saveCheckpointBoundary(c.batch.end)
+	}
+	return nil
+}
+
+// rollBackBatch reports the pending chunks failed once their transaction is
+// lost, and takes them out of the summary.
+func (c *Chunker) rollBackBatch(cause error, summary *RunSummary) {
+	err := fmt.Errorf("rolled back with the uncommitted batch: %v", cause)
+	for _, report := range c.batch.reports {
+		summary.Chunks--
+		summary.RowsAffected -= report.Affected
+		summary.ChunkTime -= report.Elapsed
+		c.report().ChunkFailed(report, err)
+	}
+	c.batch.pending = 0
+	c.batch.reports = nil
+}
+
+// endBatch closes the open transaction when a run ends. After a clean run the
+// pending chunks are committed. After a failure, with savepoints, only the
+// failing chunk is rolled back and the chunks before it in the batch are
+// committed; without savepoints the whole batch is rolled back. Chunks that
+// are rolled back are reported failed and taken out of summary.
+func (c *Chunker) endBatch(cause error, summary *RunSummary) error {
+	if !c.batching() {
+		return cause
+	}
+	defer func() {
+		c.batch = batchState{}
+	}()
+	if cause == nil {
+		if err := c.commitBatch(); err != nil {
+			return err
+		}
+		_, err := c.db.Exec(c.ctx, "SET SESSION autocommit=1")
+		return err
+	}
+	if c.batch.pending == 0 && !c.batch.failed {
+		return cause
+	}
+
+	if !c.Config.BatchSavepoints {
+		// Whether or not the ROLLBACK goes through, the transaction is gone
+		lost := c.batch.pending
+		c.rollBackBatch(cause, summary)
+		if _, err := c.db.Exec(c.ctx, "ROLLBACK"); err != nil {
+			return fmt.Errorf("%v; rollback failed: %v", cause, err)
+		}
+		if c.batch.failed {
+			lost++
+		}
+		return fmt.Errorf("%v; rolled back the batch's %d uncommitted chunks", cause, lost)
+	}
+	if c.batch.failed {
+		// A deadlock rolls back the whole transaction, savepoints included
+		if _, err := c.db.Exec(c.ctx, "ROLLBACK TO SAVEPOINT "+batchSavepoint); err != nil {
+			lost := c.batch.pending
+			c.rollBackBatch(cause, summary)
+			return fmt.Errorf("%v; rollback to the chunk's savepoint failed, the batch's %d earlier chunks are not committed: %v", cause, lost, err)
+		}
+	}
+	kept := c.batch.pending
+	if err := c.commitBatch(); err != nil {
+		c.rollBackBatch(cause, summary)
+		return fmt.Errorf("%v; committing the batch's %d earlier chunks failed: %v", cause, kept, err)
+	}
+	if kept == 0 {
+		return cause
+	}
+	return fmt.Errorf("%v; committed the batch's %d earlier chunks", cause, kept)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// txDB models autocommit=0 transactions and savepoints over simDB's touched
+// counts, so that rolled back chunks leave no trace in committed.
+type txDB struct {
+	*simDB
+	committed map[interface{}]int
+	savepoint map[interface{}]int
+}
+
+func copyTouched(touched map[interface{}]int) map[interface{}]int {
+	copied := make(map[interface{}]int, len(touched))
+	for key, n := range touched {
+		copied[key] = n
+	}
+	return copied
+}
+
+func (d *txDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	switch query {
+	case "COMMIT":
+		d.committed = copyTouched(d.touched)
+	case "ROLLBACK":
+		d.touched = copyTouched(d.committed)
+	case "SAVEPOINT " + batchSavepoint:
+		d.savepoint = copyTouched(d.touched)
+	case "ROLLBACK TO SAVEPOINT " + batchSavepoint:
+		d.touched = copyTouched(d.savepoint)
+	}
+	return d.simDB.Exec(ctx, query, args...)
+}
+
+func newBatchChunker(batchCommit int, savepoints bool) (*txDB, *Chunker) {
+	sim := newSimDB(seqKeys(1, 100))
+	db := &txDB{simDB: sim, committed: map[interface{}]int{}}
+	chunker := NewChunker(db, newSimChunker(sim, 10).Config)
+	chunker.Config.BatchCommit = batchCommit
+	chunker.Config.BatchSavepoints = savepoints
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	return db, chunker
+}
+
+// runawayAtChunk6 makes chunks affect 5 rows each, except chunk 6 (keys 51
+// to 60), which affects all 10.
+func runawayAtChunk6(key interface{}) bool {
+	k := key.(int64)
+	return (k > 50 && k <= 60) || k%2 == 0
+}
+
+func TestBatchCommitCommitsEveryBatch(t *testing.T) {
+	db, chunker := newBatchChunker(4, true)
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	commits := 0
+	for _, stmt := range db.statements {
+		if stmt == "COMMIT" {
+			commits++
+		}
+	}
+	// 10 chunks in batches of 4, 4 and 2
+	if commits != 3 {
+		t.Errorf("Expected 3 commits, got %d", commits)
+	}
+	if db.statements[0] != "SET SESSION autocommit=0" {
+		t.Errorf("Expected autocommit to be disabled first, got %q", db.statements[0])
+	}
+	if len(db.committed) != 100 {
+		t.Errorf("Expected 100 committed rows, got %d", len(db.committed))
+	}
+}
+
+func TestBatchSavepointRollsBackOnlyFailingChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	db, chunker := newBatchChunker(4, true)
+	db.matches = runawayAtChunk6
+	chunker.Config.MaxAffectedPerChunk = 8
+	chunker.Config.CheckpointFile = path
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "committed the batch's 1 earlier chunks") {
+		t.Fatalf("Expected the earlier chunk of the batch to be committed, got %v", err)
+	}
+	// Chunks 1 to 4 formed the first batch; chunk 5 precedes the runaway
+	// chunk 6 in the second
+	for key := range db.committed {
+		if k := key.(int64); k > 50 {
+			t.Errorf("Key %d of the rolled back chunk was committed", k)
+		}
+	}
+	if len(db.committed) != 25 {
+		t.Errorf("Expected 25 committed rows, got %d", len(db.committed))
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil || cp == nil || cp.Boundary[0] != "50" {
+		t.Errorf("Expected checkpoint at 50, got %+v, %v", cp, err)
+	}
+}
+
+func TestBatchWithoutSavepointsRollsBackBatch(t *testing.T) {
+	db, chunker := newBatchChunker(4, false)
+	db.matches = runawayAtChunk6
+	chunker.Config.MaxAffectedPerChunk = 8
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "rolled back the batch's 2 uncommitted chunks") {
+		t.Fatalf("Expected the batch to be rolled back, got %v", err)
+	}
+	for key := range db.committed {
+		if k := key.(int64); k > 40 {
+			t.Errorf("Key %d of the rolled back batch was committed", k)
+		}
+	}
+	for _, stmt := range db.statements {
+		if strings.HasPrefix(stmt, "SAVEPOINT") {
+			t.Errorf("Unexpected savepoint without BatchSavepoints")
+		}
+	}
+}
+
+func TestBatchSavepointOnFailedStatement(t *testing.T) {
+	db, chunker := newBatchChunker(4, true)
+	db.failAt = 3
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "simulated failure") {
+		t.Fatalf("Expected the simulated failure, got %v", err)
+	}
+	n := len(db.statements)
+	if n < 2 || db.statements[n-2] != "ROLLBACK TO SAVEPOINT "+batchSavepoint || db.statements[n-1] != "COMMIT" {
+		t.Errorf("Expected rollback to the savepoint and a commit, got %v", db.statements[n-2:])
+	}
+	if len(db.committed) != 20 {
+		t.Errorf("Expected the 20 rows of the first two chunks committed, got %d", len(db.committed))
+	}
+}
+
+func TestBatchRollbackReportsChunksFailed(t *testing.T) {
+	db, chunker := newBatchChunker(4, false)
+	db.failAt = 7
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "rolled back the batch's 3 uncommitted chunks") {
+		t.Fatalf("Expected the second batch to be rolled back, got %v", err)
+	}
+	// Chunks 1 to 4 were committed; 5 and 6 were rolled back with the
+	// failing chunk 7
+	if got := reporter.ranges(); len(got) != 4 || got[3] != "30-40" {
+		t.Errorf("Expected the 4 committed chunks reported done, got %v", got)
+	}
+	var failed []int
+	for _, r := range reporter.failed {
+		failed = append(failed, r.Index)
+	}
+	if fmt.Sprint(failed) != "[7 5 6]" {
+		t.Errorf("Expected chunks 7, 5 and 6 reported failed, got %v", failed)
+	}
+	if summary.Chunks != 4 || summary.RowsAffected != 40 || int64(len(db.committed)) != summary.RowsAffected {
+		t.Errorf("Expected 4 chunks and 40 rows in the summary, got %d and %d with %d rows committed", summary.Chunks, summary.RowsAffected, len(db.committed))
+	}
+	if n := len(reporter.summaries); n != 1 || reporter.summaries[0].RowsAffected != 40 {
+		t.Errorf("Expected one reported summary of 40 rows, got %+v", reporter.summaries)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (c *Chunker) getUniqueKeyScanVariables() string {
+	vars := make([]string, c.Config.CountColumnsInUniqueKey)
+	for i := 0; i < c.Config.CountColumnsInUniqueKey; i++ {
+		vars[i] = fmt.Sprintf("@unique_key_scan_%d", i)
+	}
+	return strings.Join(vars, ",")
+}
+
+// ComputeBoundaries walks the key range from the current range start to the
+// maximum without executing anything, and returns the upper boundary of every
+// chunk of ChunkSize keys, in order. inclusive includes the range start
+// itself, as on the first chunk of a fresh run. It costs two boundary queries
+// per chunk, so on a large table it can take a noticeable time before the
+// first chunk runs. With BoundaryCursor, a connection that can stream rows
+// reads the keys in a single ordered scan instead (see scanBoundaries). The
+// range variables are left untouched.
+func (c *Chunker) ComputeBoundaries(inclusive bool) ([][]interface{}, error) {
+	if c.Config.BoundaryCursor {
+		if scanner, ok := c.state().(KeyScanner); ok {
+			return c.scanBoundaries(scanner, inclusive)
+		}
+		c.Verbose("Connection cannot stream rows, counting chunk boundaries with boundary queries")
+	}
+	scanVars := c.getUniqueKeyScanVariables()
+	if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", c.getUniqueKeyRangeStartVariables(), scanVars)); err != nil {
+		return nil, err
+	}
+
+	var boundaries [][]interface{}
+	lowOp := ">"
+	if inclusive {
+		lowOp = ">="
+	}
+	for {
+		source := c.boundarySource(scanVars, lowOp, c.Config.ChunkSize)
+		row, err := c.state().QueryRow(c.ctx, fmt.Sprintf("SELECT %s %s", c.Config.UniqueKeyColumnNames, source))
+		if err == sql.ErrNoRows {
+			return boundaries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		boundary := make([]interface{}, len(c.Config.UniqueKeyColumnNamesList))
+		for i, col := range c.Config.UniqueKeyColumnNamesList {
+			boundary[i] = row[col]
+		}
+		boundaries = append(boundaries, boundary)
+
+		// The next scan starts after this boundary; it is copied server-side
+		// so no value is reformatted on the client
+		if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s %s", c.Config.UniqueKeyColumnNames, scanVars, source)); err != nil {
+			return nil, err
+		}
+		lowOp = ">"
+	}
+}
+
+// KeyScanner streams the rows of a query to fn as the server sends them.
+type KeyScanner interface {
+	QueryEach(ctx context.Context, query string, fn func(row map[string]interface{}) error, args ...interface{}) error
+}
+
+// scanBoundaries is ComputeBoundaries in one pass: a single query reads the
+// keys from the range start to the maximum in order, and every ChunkSize-th
+// key, and the last, is a boundary. The boundary queries read each chunk's
+// keys twice, in two round trips per chunk; this reads them once, over one
+// result streamed from the server, at the price of a long-running read.
+func (c *Chunker) scanBoundaries(scanner KeyScanner, inclusive bool) ([][]interface{}, error) {
+	lowOp := ">"
+	if inclusive {
+		lowOp = ">="
+	}
+	cols := c.Config.UniqueKeyColumnNames
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", cols, c.tableRef(), c.remainingPredicate(cols, c.getUniqueKeyRangeStartVariables(), lowOp), cols)
+
+	var boundaries [][]interface{}
+	var last []interface{}
+	n := 0
+	err := scanner.QueryEach(c.ctx, query, func(row map[string]interface{}) error {
+		last = make([]interface{}, len(c.Config.UniqueKeyColumnNamesList))
+		for i, col := range c.Config.UniqueKeyColumnNamesList {
+			last[i] = row[col]
+		}
+		n++
+		if n%c.Config.ChunkSize == 0 {
+			boundaries = append(boundaries, last)
+			last = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		boundaries = append(boundaries, last)
+	}
+	return boundaries, nil
+}
+
+// checkBoundaryTime stops the run when the boundary query of chunk
+// chunkIndex took longer than MaxBoundaryQueryTime. A boundary query reads
+// ChunkSize keys in index order and should take milliseconds; a slow one
+// means it is not using the key's index, and every chunk will crawl.
+func (c *Chunker) checkBoundaryTime(elapsed time.Duration, chunkIndex int) error {
+	if c.Config.MaxBoundaryQueryTime <= 0 || elapsed <= c.Config.MaxBoundaryQueryTime {
+		return nil
+	}
+	return fmt.Errorf("boundary query of chunk %d took %v, more than --max-boundary-query-time %v; it is likely not using an index on (%s), consider choosing a key with --force-chunking-column", chunkIndex, elapsed.Round(time.Millisecond), c.Config.MaxBoundaryQueryTime, c.Config.UniqueKeyColumnNames)
+}
+
+// boundaryPrefetch is the boundary row of the chunk after the current one,
+// read on the state connection while the current chunk runs on the writer.
+type boundaryPrefetch struct {
+	limit   int
+	row     map[string]interface{}
+	err     error
+	elapsed time.Duration
+	done    chan struct{}
+}
+
+// prefetchBoundary starts reading the boundary of the chunk of limit keys
+// that follows the current range end. The state connection must not be used
+// until wait returns.
+func (c *Chunker) prefetchBoundary(limit, chunkIndex int) *boundaryPrefetch {
+	p := &boundaryPrefetch{limit: limit, done: make(chan struct{})}
+	query := c.annotate(fmt.Sprintf("SELECT %s %s", c.Config.UniqueKeyColumnNames, c.boundarySource(c.getUniqueKeyRangeEndVariables(), ">", limit)), chunkIndex)
+	ctx, state := c.ctx, c.state()
+	go func() {
+		defer close(p.done)
+		start := time.Now()
+		p.row, p.err = state.QueryRow(ctx, query)
+		p.elapsed = time.Since(start)
+	}()
+	return p
+}
+
+// wait blocks until the prefetch has finished. A nil prefetch returns at once.
+func (p *boundaryPrefetch) wait() {
+	if p != nil {
+		<-p.done
+	}
+}
+
+// interpolatedProgress estimates progress from where start lies between min
+// and max, assuming keys are evenly distributed.
+func interpolatedProgress(minVal, maxVal, startVal interface{}) int {
+	if maxVal == nil || minVal == nil || startVal == nil {
+		return 0
+	}
+	minF := toFloat(minVal)
+	maxF := toFloat(maxVal)
+	startF := toFloat(startVal)
+	if maxF <= minF {
+		return 0
+	}
+	return int((startF - minF) / (maxF - minF) * 100)
+}
+
+// binaryPosition maps a binary key to a number preserving its order in its
+// first 8 bytes, enough for coarse progress on keys such as UUID_TO_BIN values.
+func binaryPosition(val interface{}) interface{} {
+	var b []byte
+	switch v := val.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return nil
+	}
+	var prefix [8]byte
+	copy(prefix[:], b)
+	return float64(binary.BigEndian.Uint64(prefix[:]))
+}
+
+// chunkProgress is the percentage of precomputed chunks already done.
+func chunkProgress(done, total int) int {
+	if total <= 0 {
+		return 0
+	}
+	if done >= total {
+		return 100
+	}
+	return done * 100 / total
+}
+
+// rowProgress is the percentage of a known total of rows already affected.
+func rowProgress(done, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	if done >= total {
+		return 100
+	}
+	return int(done * 100 / total)
+}
@@ -0,0 +1,433 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// skewedKeys has 90 dense keys followed by 10 keys far above them.
+func skewedKeys() []int64 {
+	return append(seqKeys(1, 90), seqKeys(910, 919)...)
+}
+
+func TestTotalRowsProgress(t *testing.T) {
+	db := newSimDB(skewedKeys())
+	chunker := newSimChunker(db, 10)
+	chunker.Config.TotalRows = 200
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("ChunkUpdate: %v", err)
+	}
+	// Each chunk affects 10 of the supplied 200 rows, wherever its keys lie
+	expected := []int{0, 5, 10, 15, 20, 25, 30, 35, 40, 45}
+	if !reflect.DeepEqual(reporter.progress(), expected) {
+		t.Errorf("Expected progress %v, got %v", expected, reporter.progress())
+	}
+}
+
+func TestNegativeIntegerKeys(t *testing.T) {
+	db := newSimDB(seqKeys(-5000, 5000))
+	chunker := newSimChunker(db, 1000)
+	progress, ranges := &recordingReporter{}, &recordingReporter{}
+	chunker.SetReporter(MultiReporter{progress, ranges})
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("ChunkUpdate: %v", err)
+	}
+	checkTouchedOnce(t, db)
+	// Progress runs over the signed span: key 0 is halfway
+	expected := []int{0, 9, 19, 29, 39, 49, 59, 69, 79, 89, 99}
+	if !reflect.DeepEqual(progress.progress(), expected) {
+		t.Errorf("Expected progress %v, got %v", expected, progress.progress())
+	}
+	if ranges.ranges()[0] != "-5000--4001" || ranges.ranges()[5] != "-1-999" {
+		t.Errorf("Unexpected ranges %v", ranges.ranges())
+	}
+	// Session variables read over the text protocol arrive as strings
+	if p := interpolatedProgress("-5000", "5000", "0"); p != 50 {
+		t.Errorf("Expected key 0 halfway through -5000..5000, got %d%%", p)
+	}
+
+	// --start-with and --end-with take negative bounds
+	db = newSimDB(seqKeys(-5000, 5000))
+	chunker = newSimChunker(db, 1000)
+	chunker.Config.StartWith, chunker.Config.EndWith = "-2500", "-500"
+	low, high, exists, err := chunker.GetUniqueKeyRange()
+	if err != nil || !exists {
+		t.Fatalf("GetUniqueKeyRange: %v, %v", exists, err)
+	}
+	if low[0] != int64(-2500) || high[0] != int64(-500) {
+		t.Errorf("Expected range -2500..-500, got %v..%v", low[0], high[0])
+	}
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("ChunkUpdate: %v", err)
+	}
+	if len(db.touched) != 2001 || db.touched[int64(-2500)] != 1 || db.touched[int64(-500)] != 1 || db.touched[int64(-499)] != 0 {
+		t.Errorf("Expected keys -2500..-500 touched once, got %d keys", len(db.touched))
+	}
+}
+
+func TestRowProgress(t *testing.T) {
+	for _, tc := range []struct {
+		done, total int64
+		expected    int
+	}{
+		{0, 1000, 0},
+		{250, 1000, 25},
+		{999, 1000, 99},
+		{1500, 1000, 100},
+		{10, 0, 0},
+	} {
+		if got := rowProgress(tc.done, tc.total); got != tc.expected {
+			t.Errorf("rowProgress(%d, %d) = %d, want %d", tc.done, tc.total, got, tc.expected)
+		}
+	}
+}
+
+func TestComputeBoundaries(t *testing.T) {
+	db := newSimDB(skewedKeys())
+	chunker := newSimChunker(db, 10)
+	if _, err := db.Exec(context.Background(), "SELECT @unique_key_min_value_0 INTO @unique_key_range_start_0"); err != nil {
+		t.Fatal(err)
+	}
+	boundaries, err := chunker.ComputeBoundaries(true)
+	if err != nil {
+		t.Fatalf("ComputeBoundaries: %v", err)
+	}
+	expected := "[[10] [20] [30] [40] [50] [60] [70] [80] [90] [919]]"
+	if got := fmt.Sprint(boundaries); got != expected {
+		t.Errorf("Expected boundaries %s, got %s", expected, got)
+	}
+	if start := db.vars["unique_key_range_start_0"]; start != int64(1) {
+		t.Errorf("Range start changed to %v", start)
+	}
+}
+
+func TestBoundaryCursorMatchesBoundaryQueries(t *testing.T) {
+	for _, keys := range [][]int64{skewedKeys(), seqKeys(1, 100), seqKeys(1, 95), seqKeys(5, 7)} {
+		for _, inclusive := range []bool{true, false} {
+			compute := func(cursor bool) ([][]interface{}, *simDB) {
+				db := newSimDB(keys)
+				chunker := newSimChunker(db, 10)
+				chunker.Config.BoundaryCursor = cursor
+				if _, err := db.Exec(context.Background(), "SELECT @unique_key_min_value_0 INTO @unique_key_range_start_0"); err != nil {
+					t.Fatal(err)
+				}
+				boundaries, err := chunker.ComputeBoundaries(inclusive)
+				if err != nil {
+					t.Fatalf("ComputeBoundaries: %v", err)
+				}
+				return boundaries, db
+			}
+			queried, _ := compute(false)
+			scanned, db := compute(true)
+			if !reflect.DeepEqual(scanned, queried) {
+				t.Errorf("%d keys from %d, inclusive %v: cursor boundaries %v, want %v", len(keys), keys[0], inclusive, scanned, queried)
+			}
+			if len(db.queries) != 1 {
+				t.Errorf("cursor issued %d queries, want a single scan: %v", len(db.queries), db.queries)
+			}
+		}
+	}
+}
+
+func TestAccurateProgressOnSkewedKeys(t *testing.T) {
+	run := func(accurate bool) []int {
+		db := newSimDB(skewedKeys())
+		chunker := newSimChunker(db, 10)
+		chunker.Config.AccurateProgress = accurate
+		reporter := &recordingReporter{}
+		chunker.SetReporter(reporter)
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("ChunkUpdate: %v", err)
+		}
+		if len(db.touched) != 100 {
+			t.Errorf("Expected 100 rows touched, got %d", len(db.touched))
+		}
+		return reporter.progress()
+	}
+
+	accurate := run(true)
+	if got := fmt.Sprint(accurate); got != "[0 10 20 30 40 50 60 70 80 90]" {
+		t.Errorf("Unexpected accurate progress %s", got)
+	}
+	// Interpolation sees 90 of 100 chunks as under 10% of the key range
+	interpolated := run(false)
+	if len(interpolated) != 10 || interpolated[9] >= 10 {
+		t.Errorf("Unexpected interpolated progress %v", interpolated)
+	}
+}
+
+func TestSkipUpfrontEstimate(t *testing.T) {
+	db := newSimDB(skewedKeys())
+	chunker := newSimChunker(db, 10)
+	chunker.Config.AccurateProgress = true
+	chunker.Config.SingleStatement = true
+	chunker.Config.SkipUpfrontEstimate = true
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("ChunkUpdate: %v", err)
+	}
+	boundaries := 0
+	for _, q := range db.queries {
+		if simCountRe.MatchString(q) {
+			t.Errorf("Expected no upfront COUNT, got %s", q)
+		}
+		if strings.Contains(q, "LIMIT") {
+			boundaries++
+		}
+	}
+	// One boundary query per chunk and the one finding the range exhausted
+	if boundaries != 11 {
+		t.Errorf("Expected 11 boundary queries and no precomputed boundaries, got %d", boundaries)
+	}
+	if len(reporter.progress()) != 10 || reporter.progress()[9] >= 10 {
+		t.Errorf("Expected interpolated progress, got %v", reporter.progress())
+	}
+}
+
+func TestChunkProgress(t *testing.T) {
+	tests := []struct{ done, total, expected int }{
+		{0, 10, 0},
+		{3, 10, 30},
+		{12, 10, 100},
+		{5, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := chunkProgress(tt.done, tt.total); got != tt.expected {
+			t.Errorf("chunkProgress(%d, %d) = %d, want %d", tt.done, tt.total, got, tt.expected)
+		}
+	}
+}
+
+// runSplit runs a chunk update with boundary detection on a separate reader
+// and returns the executed ranges and the writer.
+func runSplit(t testing.TB, keys []int64, prefetch bool, configure func(c *Chunker, writer *simDB)) ([]string, *simDB) {
+	reader, writer := newSimDB(keys), newSimDB(keys)
+	chunker := newSimChunker(reader, 10)
+	chunker.db = writer
+	chunker.SetReader(reader)
+	chunker.Config.BoundaryPrefetch = prefetch
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+	if configure != nil {
+		configure(chunker, writer)
+	}
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("ChunkUpdate: %v", err)
+	}
+	return reporter.ranges(), writer
+}
+
+// TestMaxKeyProcessedOnce places the maximum key at, just past and short of
+// a chunk boundary, and checks every way of finding chunk ends includes it
+// exactly once: the run ends when no key remains past the last chunk's end,
+// not on a comparison of the range start with the maximum.
+func TestMaxKeyProcessedOnce(t *testing.T) {
+	keySets := map[string][]int64{
+		"max ends a chunk":        seqKeys(1, 30),
+		"max alone in last chunk": seqKeys(1, 31),
+		"fewer keys than a chunk": seqKeys(1, 9),
+		"single key":              {7},
+	}
+	modes := map[string]func(c *Chunker, writer *simDB){
+		"boundary queries": nil,
+		"prefetch":         func(c *Chunker, writer *simDB) { c.Config.BoundaryPrefetch = true },
+		"dense keys":       func(c *Chunker, writer *simDB) { c.Config.DenseKeys = DenseKeysOn },
+		"single statement": func(c *Chunker, writer *simDB) { c.Config.SingleStatement = true },
+		"batch commit":     func(c *Chunker, writer *simDB) { c.Config.BatchCommit = 2 },
+		"merged chunks": func(c *Chunker, writer *simDB) {
+			c.Config.MinAffectedPerChunk = 5
+			writer.matches = func(key interface{}) bool { return key.(int64)%7 == 0 }
+		},
+	}
+	for keysName, keys := range keySets {
+		for modeName, configure := range modes {
+			t.Run(keysName+"/"+modeName, func(t *testing.T) {
+				_, writer := runSplit(t, keys, false, configure)
+				max := keys[len(keys)-1]
+				if writer.matches == nil || writer.matches(max) {
+					if n := writer.touched[max]; n != 1 {
+						t.Errorf("max key %d processed %d times, want once", max, n)
+					}
+				}
+				for key, n := range writer.touched {
+					if n != 1 {
+						t.Errorf("key %v processed %d times", key, n)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMaxBoundaryQueryTime(t *testing.T) {
+	for _, prefetch := range []bool{false, true} {
+		reader, writer := newSimDB(seqKeys(1, 50)), newSimDB(seqKeys(1, 50))
+		reader.latency = 30 * time.Millisecond
+		chunker := newSimChunker(reader, 10)
+		chunker.db = writer
+		chunker.SetReader(reader)
+		chunker.Config.BoundaryPrefetch = prefetch
+		chunker.Config.MaxBoundaryQueryTime = 10 * time.Millisecond
+		chunker.SetReporter(&recordingReporter{})
+		_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		if err == nil || !strings.Contains(err.Error(), "boundary query of chunk 1 took") || len(writer.execs) != 0 {
+			t.Errorf("prefetch %v: expected a slow boundary query to stop the run before any chunk, got %v after %d chunks", prefetch, err, len(writer.execs))
+		}
+	}
+
+	// Under the limit, the run goes through
+	db := newSimDB(seqKeys(1, 50))
+	db.latency = time.Millisecond
+	chunker := newSimChunker(db, 10)
+	chunker.Config.MaxBoundaryQueryTime = time.Second
+	chunker.SetReporter(&recordingReporter{})
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Errorf("Unexpected error under the limit: %v", err)
+	}
+}
+
+func TestBoundaryPrefetchMatchesSerial(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Chunker, writer *simDB)
+	}{
+		{"fixed chunks", nil},
+		// Sparse matches make the merge factor change, discarding prefetches
+		{"merged chunks", func(c *Chunker, writer *simDB) {
+			c.Config.MinAffectedPerChunk = 5
+			writer.matches = func(key interface{}) bool { return key.(int64)%7 == 0 }
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial, _ := runSplit(t, skewedKeys(), false, tt.configure)
+			prefetched, writer := runSplit(t, skewedKeys(), true, tt.configure)
+			if fmt.Sprint(prefetched) != fmt.Sprint(serial) {
+				t.Errorf("Prefetched ranges %v differ from serial ranges %v", prefetched, serial)
+			}
+			for _, key := range writer.keys {
+				if writer.touched[key] > 1 {
+					t.Errorf("Key %v processed %d times", key, writer.touched[key])
+				}
+			}
+		})
+	}
+}
+
+func TestBoundaryPrefetchRequiresReader(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.BoundaryPrefetch = true
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Error("Expected an error without a separate reader")
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("Expected no chunks, got %d", len(db.execs))
+	}
+}
+
+func TestLockBoundaryReads(t *testing.T) {
+	db := newSimDB(seqKeys(1, 25))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.LockBoundaryReads = true
+	chunker.Config.AccurateProgress = true
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+	locking := 0
+	for _, q := range db.queries {
+		if !strings.Contains(q, "LIMIT") {
+			continue
+		}
+		if strings.Contains(q, "LIMIT 10 LOCK IN SHARE MODE) t ORDER BY id DESC LIMIT 1") {
+			locking++
+		}
+	}
+	// Three chunks and the query that finds no more rows; the progress
+	// count does not lock
+	if locking != 4 {
+		t.Errorf("Expected 4 locking boundary reads, got %d in %v", locking, db.queries)
+	}
+
+	// Each chunk's boundary read and statement share a transaction, which
+	// commits before the next boundary is read
+	var flow []string
+	for _, stmt := range db.statements {
+		switch {
+		case strings.HasPrefix(stmt, "SET SESSION autocommit"):
+			flow = append(flow, stmt)
+		case strings.HasPrefix(stmt, "UPDATE"):
+			flow = append(flow, "UPDATE")
+		case stmt == "COMMIT":
+			flow = append(flow, stmt)
+		}
+	}
+	want := []string{"SET SESSION autocommit=0", "UPDATE", "COMMIT", "UPDATE", "COMMIT", "UPDATE", "COMMIT", "SET SESSION autocommit=1"}
+	if !reflect.DeepEqual(flow, want) {
+		t.Errorf("statements = %v, want %v", flow, want)
+	}
+	checkTouchedOnce(t, db)
+}
+
+func TestLockBoundaryReadsRequiresWriter(t *testing.T) {
+	reader, db := newSimDB(seqKeys(1, 30)), newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(reader, 10)
+	chunker.db = db
+	chunker.SetReader(reader)
+	chunker.Config.LockBoundaryReads = true
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil || len(db.execs) != 0 {
+		t.Errorf("Expected a reader connection to be rejected before any chunk, got %v after %d chunks", err, len(db.execs))
+	}
+}
+
+func BenchmarkBoundaryPrefetch(b *testing.B) {
+	for _, prefetch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prefetch=%v", prefetch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runSplit(b, seqKeys(1, 100), prefetch, func(c *Chunker, writer *simDB) {
+					writer.latency = time.Millisecond
+					c.reader.(*simDB).latency = time.Millisecond
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkComputeBoundaries(b *testing.B) {
+	for _, cursor := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cursor=%v", cursor), func(b *testing.B) {
+			db := newSimDB(seqKeys(1, 1000))
+			db.latency = 100 * time.Microsecond
+			chunker := newSimChunker(db, 10)
+			chunker.Config.BoundaryCursor = cursor
+			if _, err := db.Exec(context.Background(), "SELECT @unique_key_min_value_0 INTO @unique_key_range_start_0"); err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				if _, err := chunker.ComputeBoundaries(true); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Checkpoint records the upper boundary of the last committed chunk. A resumed
+// run starts strictly after Boundary, so every committed chunk is applied at
+// most once. The chunk in flight when the process died may have committed
+// without being recorded, and is applied again on resume. QueryHash
+// fingerprints the statement the boundary was committed for.
+type Checkpoint struct {
+	Database  string   `json:"database"`
+	Table     string   `json:"table"`
+	Columns   string   `json:"columns"`
+	KeyType   string   `json:"key_type,omitempty"`
+	QueryHash string   `json:"query_hash,omitempty"`
+	Boundary  []string `json:"boundary"`
+}
+
+// hashQuery returns the fingerprint a checkpoint records for query.
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadCheckpoint reads a checkpoint file. It returns nil without error when
+// the file does not exist, meaning there is nothing to resume.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %v", path, err)
+	}
+	return &cp, nil
+}
+
+// Save writes the checkpoint atomically, so a crash mid-write leaves the
+// previous checkpoint intact.
+func (cp *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the file at path with data through a synced
+// temporary file in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Validate refuses to resume a checkpoint written for a different table or key.
+// The key columns and key type together fingerprint the chunking key; a
+// boundary recorded under another key would silently skip or repeat rows.
+func (cp *Checkpoint) Validate(config Config) error {
+	if cp.Database != config.Database || cp.Table != config.Table {
+		return fmt.Errorf("checkpoint is for %s.%s, not %s.%s", cp.Database, cp.Table, config.Database, config.Table)
+	}
+	// Column names are case-insensitive; checkpoints written before column
+	// case was preserved hold them lowercased
+	if !strings.EqualFold(cp.Columns, config.UniqueKeyColumnNames) {
+		return fmt.Errorf("checkpoint is for key (%s), not (%s); the key changed since the checkpoint was written, use --remap-key to translate its boundary", cp.Columns, config.UniqueKeyColumnNames)
+	}
+	if cp.KeyType != "" && cp.KeyType != config.UniqueKeyType {
+		return fmt.Errorf("checkpoint is for a %s key, but (%s) is now %s; use --remap-key to translate its boundary", cp.KeyType, cp.Columns, config.UniqueKeyType)
+	}
+	if len(cp.Boundary) != config.CountColumnsInUniqueKey {
+		return fmt.Errorf("checkpoint boundary has %d values, key has %d columns", len(cp.Boundary), config.CountColumnsInUniqueKey)
+	}
+	return nil
+}
+
+// ValidateQuery refuses to resume a checkpoint committed for another
+// statement: rows before its boundary were never touched by this one.
+// Checkpoints written before the hash was recorded carry none and pass.
+func (cp *Checkpoint) ValidateQuery(query string) error {
+	if cp.QueryHash != "" && cp.QueryHash != hashQuery(query) {
+		return fmt.Errorf("checkpoint was written for another statement; remove it to start over")
+	}
+	return nil
+}
+
+// saveCheckpoint records the current range end as the last committed boundary.
+func (c *Chunker) saveCheckpoint() error {
+	boundary := make([]interface{}, c.Config.CountColumnsInUniqueKey)
+	for i := range boundary {
+		val, err := c.getSessionVariableValue(fmt.Sprintf("unique_key_range_end_%d", i))
+		if err != nil {
+			return err
+		}
+		boundary[i] = val
+	}
+	return c.saveCheckpointBoundary(boundary)
+}
+
+// saveCheckpointBoundary records boundary as the last committed boundary.
+func (c *Chunker) saveCheckpointBoundary(boundary []interface{}) error {
+	literals := make([]string, len(boundary))
+	for i, val := range boundary {
+		literals[i] = c.keyLiteral(val)
+	}
+	cp := &Checkpoint{
+		Database:  c.Config.Database,
+		Table:     c.Config.Table,
+		Columns:   c.Config.UniqueKeyColumnNames,
+		KeyType:   c.Config.UniqueKeyType,
+		QueryHash: c.queryHash,
+		Boundary:  literals,
+	}
+	return cp.Save(c.Config.CheckpointFile)
+}
+
+// Remap translates the checkpoint boundary to the current key according to
+// spec, a comma-separated list of newcol=source assignments covering every
+// current key column. A source is either a column of the checkpoint's key,
+// whose recorded boundary value is carried over, or a SQL literal (a number,
+// NULL or a single-quoted string). For example, after the key (id) became
+// (tenant_id,id), "tenant_id=0,id=id" resumes after (0, <old id boundary>).
+func (cp *Checkpoint) Remap(spec string, config Config) (*Checkpoint, error) {
+	oldColumns := SplitColumnNames(cp.Columns)
+	if len(oldColumns) != len(cp.Boundary) {
+		return nil, fmt.Errorf("checkpoint boundary has %d values, key (%s) has %d columns", len(cp.Boundary), cp.Columns, len(oldColumns))
+	}
+	old := make(map[string]string, len(oldColumns))
+	for i, col := range oldColumns {
+		old[col] = cp.Boundary[i]
+	}
+
+	assignments, err := parseRemapSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	boundary := make([]string, len(config.UniqueKeyColumnNamesList))
+	for i, col := range config.UniqueKeyColumnNamesList {
+		source, ok := assignments[col]
+		if !ok {
+			return nil, fmt.Errorf("--remap-key does not assign key column %s", col)
+		}
+		delete(assignments, col)
+		if val, ok := old[source]; ok {
+			boundary[i] = val
+		} else if strings.EqualFold(source, "NULL") {
+			// No row matches a NULL bound, so the resumed run would do nothing
+			return nil, fmt.Errorf("--remap-key cannot set %s to NULL: key columns are never NULL", col)
+		} else if isRemapLiteral(source) {
+			boundary[i] = source
+		} else {
+			return nil, fmt.Errorf("--remap-key source %s for %s is neither a column of (%s) nor a literal", source, col, cp.Columns)
+		}
+	}
+	for col := range assignments {
+		return nil, fmt.Errorf("--remap-key assigns %s, which is not a column of (%s)", col, config.UniqueKeyColumnNames)
+	}
+
+	return &Checkpoint{
+		Database:  cp.Database,
+		Table:     cp.Table,
+		Columns:   config.UniqueKeyColumnNames,
+		KeyType:   config.UniqueKeyType,
+		QueryHash: cp.QueryHash,
+		Boundary:  boundary,
+	}, nil
+}
+
+// parseRemapSpec splits a --remap-key spec into newcol -> source, keeping
+// commas inside single-quoted literals.
+func parseRemapSpec(spec string) (map[string]string, error) {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for i := 0; i < len(spec); i++ {
+		ch := spec[i]
+		switch {
+		case ch == '\\' && quoted && i+1 < len(spec):
+			current.WriteByte(ch)
+			current.WriteByte(spec[i+1])
+			i++
+		case ch == '\'':
+			quoted = !quoted
+			current.WriteByte(ch)
+		case ch == ',' && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid --remap-key %q: unterminated string literal", spec)
+	}
+	parts = append(parts, current.String())
+
+	assignments := make(map[string]string, len(parts))
+	for _, part := range parts {
+		col, source, ok := strings.Cut(part, "=")
+		col = strings.Trim(strings.TrimSpace(col), "`")
+		source = strings.TrimSpace(source)
+		if !ok || col == "" || source == "" {
+			return nil, fmt.Errorf("invalid --remap-key entry %q, expected newcol=source", strings.TrimSpace(part))
+		}
+		if _, dup := assignments[col]; dup {
+			return nil, fmt.Errorf("--remap-key assigns %s more than once", col)
+		}
+		if !strings.HasPrefix(source, "'") {
+			source = strings.Trim(source, "`")
+		}
+		assignments[col] = source
+	}
+	return assignments, nil
+}
+
+// numberLiteralRe matches a decimal or exponent numeric literal.
+var numberLiteralRe = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+
+// isRemapLiteral reports whether source is a literal --remap-key accepts: a
+// number, a 0x hex string or a single-quoted string whose quotes inside are
+// doubled or backslash-escaped.
+func isRemapLiteral(source string) bool {
+	if len(source) >= 2 && strings.HasPrefix(source, "'") && strings.HasSuffix(source, "'") {
+		inner := source[1 : len(source)-1]
+		for i := 0; i < len(inner); i++ {
+			switch inner[i] {
+			case '\\':
+				if i+1 == len(inner) {
+					return false
+				}
+				i++
+			case '\'':
+				if i+1 == len(inner) || inner[i+1] != '\'' {
+					return false
+				}
+				i++
+			}
+		}
+		return true
+	}
+	if len(source) > 2 && strings.HasPrefix(strings.ToLower(source), "0x") {
+		_, err := hex.DecodeString(source[2:])
+		return err == nil
+	}
+	// ParseFloat also takes NaN, Inf and hex floats, which are no SQL number
+	if !numberLiteralRe.MatchString(source) {
+		return false
+	}
+	f, err := strconv.ParseFloat(source, 64)
+	return err == nil && !math.IsInf(f, 0)
+}
+
+// sqlLiteral renders a session variable value as a SQL literal.
+func sqlLiteral(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		// As a DOUBLE literal, see floatBound; a decimal one could not hold
+		// every double
+		return strconv.FormatFloat(v, 'e', -1, 64)
+	case []byte:
+		return sqlLiteral(string(v))
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999") + "'"
+	case string:
+		// Binary keys such as UUID_TO_BIN values are not text
+		if !utf8.ValidString(v) || strings.ContainsRune(v, 0) {
+			return "0x" + hex.EncodeToString([]byte(v))
+		}
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
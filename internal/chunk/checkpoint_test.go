@@ -0,0 +1,353 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+
+	cp, err := LoadCheckpoint(path)
+	if err != nil || cp != nil {
+		t.Fatalf("Expected no checkpoint for missing file, got %v, %v", cp, err)
+	}
+
+	saved := &Checkpoint{Database: "test", Table: "t", Columns: "id", Boundary: []string{"42"}}
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	cp, err = LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Database != "test" || cp.Table != "t" || cp.Columns != "id" || len(cp.Boundary) != 1 || cp.Boundary[0] != "42" {
+		t.Errorf("Unexpected checkpoint %+v", cp)
+	}
+}
+
+func TestCheckpointValidate(t *testing.T) {
+	config := Config{Database: "test", Table: "t", UniqueKeyColumnNames: "id", CountColumnsInUniqueKey: 1, UniqueKeyType: "integer"}
+	tests := []struct {
+		name string
+		cp   Checkpoint
+		err  string
+	}{
+		{"match", Checkpoint{Database: "test", Table: "t", Columns: "id", Boundary: []string{"1"}}, ""},
+		{"other table", Checkpoint{Database: "test", Table: "u", Columns: "id", Boundary: []string{"1"}}, "checkpoint is for test.u"},
+		{"other key", Checkpoint{Database: "test", Table: "t", Columns: "uuid", Boundary: []string{"1"}}, "checkpoint is for key (uuid)"},
+		{"boundary width", Checkpoint{Database: "test", Table: "t", Columns: "id", Boundary: []string{"1", "2"}}, "boundary has 2 values"},
+		{"other key type", Checkpoint{Database: "test", Table: "t", Columns: "id", KeyType: "text", Boundary: []string{"'a'"}}, "checkpoint is for a text key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cp.Validate(config)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	tests := []struct {
+		val      interface{}
+		expected string
+	}{
+		{int64(-12), "-12"},
+		{"abc", "'abc'"},
+		{"O'Brien", "'O''Brien'"},
+		{nil, "NULL"},
+		{string([]byte{0x11, 0xef, 0x00, 0x9a}), "0x11ef009a"},
+	}
+	for _, tt := range tests {
+		if got := sqlLiteral(tt.val); got != tt.expected {
+			t.Errorf("sqlLiteral(%v) = %s, expected %s", tt.val, got, tt.expected)
+		}
+	}
+}
+
+func TestChunkUpdateResumesAfterCommittedBoundary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	// First run dies on the third chunk, after two chunks committed
+	db := newSimDB(seqKeys(1, 100))
+	db.failAt = 3
+	chunker := newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+
+	cp, err := LoadCheckpoint(path)
+	if err != nil || cp == nil {
+		t.Fatalf("Expected checkpoint after failure, got %v, %v", cp, err)
+	}
+	if cp.Boundary[0] != "20" {
+		t.Errorf("Expected committed boundary 20, got %s", cp.Boundary[0])
+	}
+
+	// Second run resumes strictly after the boundary on the same table
+	db.failAt = 0
+	db.execs = nil
+	chunker = newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(db.execs[0], "id > @unique_key_range_start_0") {
+		t.Errorf("Expected resumed chunk to exclude the boundary, got %s", db.execs[0])
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %d processed %d times", key, db.touched[key])
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected checkpoint to be removed after completion")
+	}
+}
+
+func TestChunkUpdateRefusesCheckpointForChangedQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	db := newSimDB(seqKeys(1, 100))
+	db.failAt = 3
+	chunker := newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil || cp == nil {
+		t.Fatalf("Expected checkpoint after failure, got %v, %v", cp, err)
+	}
+	if cp.QueryHash != hashQuery(query) {
+		t.Errorf("Expected checkpoint to record the statement hash, got %q", cp.QueryHash)
+	}
+
+	db.failAt = 0
+	db.execs = nil
+	chunker = newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	_, err = chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=2 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "another statement") {
+		t.Fatalf("Expected changed statement to be refused, got %v", err)
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("Expected no chunk to run, got %d", len(db.execs))
+	}
+
+	// A checkpoint without a hash predates it and still resumes
+	cp.QueryHash = ""
+	if err := cp.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	chunker = newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(db.execs[0], "id > @unique_key_range_start_0") {
+		t.Errorf("Expected resumed chunk to exclude the boundary, got %s", db.execs[0])
+	}
+}
+
+func TestCheckpointRemap(t *testing.T) {
+	config := Config{
+		Database:                 "test",
+		Table:                    "t",
+		UniqueKeyColumnNames:     "tenant_id,id",
+		UniqueKeyColumnNamesList: []string{"tenant_id", "id"},
+		CountColumnsInUniqueKey:  2,
+		UniqueKeyType:            "integer",
+	}
+	cp := &Checkpoint{Database: "test", Table: "t", Columns: "id", KeyType: "integer", Boundary: []string{"42"}}
+
+	tests := []struct {
+		spec     string
+		expected []string
+		err      string
+	}{
+		{"tenant_id=0,id=id", []string{"0", "42"}, ""},
+		{"`id`=`id`, `tenant_id`=NULL", nil, "cannot set tenant_id to NULL"},
+		{"tenant_id='a,b',id=id", []string{"'a,b'", "42"}, ""},
+		{"tenant_id='it''s',id=id", []string{"'it''s'", "42"}, ""},
+		{`tenant_id='it\'s',id=id`, []string{`'it\'s'`, "42"}, ""},
+		{"tenant_id='a' OR 'b',id=id", nil, "neither a column of (id) nor a literal"},
+		{`tenant_id='a\',id=id`, nil, "unterminated string literal"},
+		{"id=id", nil, "does not assign key column tenant_id"},
+		{"tenant_id=0,id=id,other=1", nil, "assigns other, which is not a column"},
+		{"tenant_id=legacy,id=id", nil, "neither a column of (id) nor a literal"},
+		{"tenant_id=-1.5e3,id=id", []string{"-1.5e3", "42"}, ""},
+		{"tenant_id=NaN,id=id", nil, "neither a column of (id) nor a literal"},
+		{"tenant_id=Inf,id=id", nil, "neither a column of (id) nor a literal"},
+		{"tenant_id=1e999,id=id", nil, "neither a column of (id) nor a literal"},
+		{"tenant_id=0x1p-2,id=id", nil, "neither a column of (id) nor a literal"},
+		{"tenant_id=0,id=id,id=id", nil, "assigns id more than once"},
+		{"tenant_id,id=id", nil, "expected newcol=source"},
+		{"tenant_id='x,id=id", nil, "unterminated string literal"},
+	}
+	for _, tt := range tests {
+		remapped, err := cp.Remap(tt.spec, config)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Remap(%q): expected error containing %q, got %v", tt.spec, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Remap(%q): unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if strings.Join(remapped.Boundary, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("Remap(%q): expected boundary %v, got %v", tt.spec, tt.expected, remapped.Boundary)
+		}
+		if err := remapped.Validate(config); err != nil {
+			t.Errorf("Remap(%q): remapped checkpoint does not validate: %v", tt.spec, err)
+		}
+	}
+}
+
+func TestChunkUpdateRefusesCheckpointForChangedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	stale := &Checkpoint{Database: "test", Table: "t", Columns: "legacy_id", KeyType: "integer", Boundary: []string{"20"}}
+	if err := stale.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "--remap-key") {
+		t.Fatalf("Expected refusal pointing at --remap-key, got %v", err)
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("Expected no chunks to run, got %d", len(db.execs))
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected checkpoint to be kept after refusal: %v", err)
+	}
+}
+
+func TestChunkUpdateRemapsCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	stale := &Checkpoint{Database: "test", Table: "t", Columns: "legacy_id", KeyType: "integer", Boundary: []string{"20"}}
+	if err := stale.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.CheckpointFile = path
+	chunker.Config.RemapKey = "id=legacy_id"
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range db.keys {
+		want := 1
+		if key.(int64) <= 20 {
+			want = 0
+		}
+		if db.touched[key] != want {
+			t.Errorf("Key %d processed %d times, expected %d", key, db.touched[key], want)
+		}
+	}
+}
+
+func TestResumeStrict(t *testing.T) {
+	dir := t.TempDir()
+	run := func(path string) (*simDB, error) {
+		db := newSimDB(seqKeys(1, 50))
+		chunker := newSimChunker(db, 10)
+		chunker.Config.CheckpointFile = path
+		chunker.Config.ResumeStrict = true
+		_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		return db, err
+	}
+
+	db, err := run(filepath.Join(dir, "missing.checkpoint"))
+	if err == nil || !strings.Contains(err.Error(), "no checkpoint") || len(db.execs) != 0 {
+		t.Errorf("Expected a missing checkpoint to be refused before any chunk, got %v after %d chunks", err, len(db.execs))
+	}
+
+	mismatched := filepath.Join(dir, "mismatched.checkpoint")
+	other := &Checkpoint{Database: "test", Table: "other", Columns: "id", KeyType: "integer", Boundary: []string{"20"}}
+	if err := other.Save(mismatched); err != nil {
+		t.Fatal(err)
+	}
+	db, err = run(mismatched)
+	if err == nil || !strings.Contains(err.Error(), "checkpoint is for test.other") || len(db.execs) != 0 {
+		t.Errorf("Expected a mismatched checkpoint to be refused before any chunk, got %v after %d chunks", err, len(db.execs))
+	}
+
+	matching := filepath.Join(dir, "matching.checkpoint")
+	cp := &Checkpoint{Database: "test", Table: "t", Columns: "id", KeyType: "integer", Boundary: []string{"20"}}
+	if err := cp.Save(matching); err != nil {
+		t.Fatal(err)
+	}
+	db, err = run(matching)
+	if err != nil || len(db.touched) != 30 {
+		t.Errorf("Expected to resume after 20, got %v with %d rows touched", err, len(db.touched))
+	}
+}
+
+// extremeFloatKeys are DOUBLE keys beyond what a DECIMAL literal can hold:
+// below its 30 fractional digits and above its 65 digits.
+func extremeFloatKeys() []interface{} {
+	return []interface{}{1e-40, 2e-40, 3e-40, 1e300, 2e300, 3e300}
+}
+
+func TestCheckpointExtremeFloatKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+	db := newSimDBValues(extremeFloatKeys())
+
+	// Each run stops after one chunk of two keys, the last one completes
+	for _, want := range []string{"2e-40", "1e+300", ""} {
+		chunker := newSimChunker(db, 2)
+		chunker.Config.UniqueKeyType = "float"
+		chunker.Config.CheckpointFile = path
+		chunker.Config.MaxChunks = 1
+		chunker.SetReporter(&recordingReporter{})
+		if want == "" {
+			chunker.Config.MaxChunks = 0
+		}
+		if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if want == "" {
+			break
+		}
+		if cp, err := LoadCheckpoint(path); err != nil || cp == nil || cp.Boundary[0] != want {
+			t.Fatalf("Expected checkpoint at %s, got %+v, %v", want, cp, err)
+		}
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %v processed %d times", key, db.touched[key])
+		}
+	}
+}
@@ -13,21 +13,32 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 package chunk
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"database/sql"
 )
 
+// DBInterface is the connection a run works through. Exec and QueryRow give
+// up when ctx is done; the metadata and table lock calls are not bounded, so
+// that the lock taken for a run is always released.
 type DBInterface interface {
-	Exec(query string, args ...interface{}) (int64, error)
-	QueryRow(query string, args ...interface{}) (map[string]interface{}, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (int64, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) (map[string]interface{}, error)
 	TableExists(database, table string) (bool, error)
 	GetPossibleUniqueKeyColumns(database, table string) ([]map[string]interface{}, error)
 	LockTableRead(database, table string) error
 	UnlockTables() error
+	AnalyzeTable(database, table string) error
 }
 
 type Config struct {
@@ -42,21 +53,141 @@ type Config struct {
 	EndWith                  string
 	TerminateOnNotFound      bool
 	ForcedChunkingColumn     string
+	ColumnMap                string
 	SkipRetryChunk           bool
+	RetryLostChunk           bool
+	MaxRetries               int
 	NoLogBin                 bool
+	TxIsolation              string
+	SQLMode                  *string
 	SleepMillis              int
 	SleepRatio               float64
+	MinAffectedPerChunk      int
+	MaxAffectedPerChunk      int64
+	MaxChunkTime             time.Duration
+	CheckpointFile           string
+	ManifestFile             string
+	DryRun                   bool
+	RemapKey                 string
+	ResumeStrict             bool
+	ThrottleFile             string
+	MaxReplicaLag            time.Duration
+	YieldToLockWaits         time.Duration
+	SummaryOnly              bool
+	Partition                string
+	MaxChunks                int
+	MaxRuntime               time.Duration
+	ConfirmEachChunk         bool
+	StatementTimeout         time.Duration
+	KillOnTimeout            bool
+	BatchCommit              int
+	BatchSavepoints          bool
+	AccurateProgress         bool
+	SkipUpfrontEstimate      bool
+	TotalRows                int64
+	BoundaryPrefetch         bool
+	BoundaryCursor           bool
+	LockBoundaryReads        bool
+	DenseKeys                string
+	SingleStatement          bool
+	VerifyNoGaps             bool
+	DumpPlanFile             string
+	LivenessInterval         time.Duration
+	MaxBoundaryQueryTime     time.Duration
+	AnalyzeAfter             bool
+	KeepLockOnError          bool
+	JobID                    string
+	StatementComment         string
 	Verbose                  bool
 	Debug                    bool
 }
 
 type Chunker struct {
 	db     DBInterface
-	Config Config
+	reader DBInterface
+	// ctx bounds every statement; it is the context ChunkUpdate was given
+	// during a run, context.Background() otherwise
+	ctx       context.Context
+	Config    Config
+	throttle  *throttleFile
+	replicas  []Replica
+	sleep     func(time.Duration)
+	now       func() time.Time
+	reporter  Reporter
+	confirmer *confirmer
+	batch     batchState
+	errorLog  *ErrorLog
+	metadata  *MetadataCache
+	stop      <-chan struct{}
+	lastKey   string
+	lastKeyMu sync.Mutex
+	// retries and skipped count the current run's anomalies
+	retries, skipped int
+	// locked is set while WithTableLock holds the table lock
+	locked bool
+	// lockWaitWarned is set once the run warned it cannot yield its table lock
+	lockWaitWarned bool
+	// planDumped is set once the first chunk's plan was captured
+	planDumped bool
+	heartbeat  heartbeat
+	// manifest is set while a run follows ManifestFile
+	manifest  *manifestRun
+	dryRunOut io.Writer
+	// queryHash fingerprints the running statement in checkpoints
+	queryHash string
 }
 
+// DefaultMaxRetries is how often NewChunker retries a chunk that failed on a
+// transient error when Config.MaxRetries is 0. A negative MaxRetries
+// disables the retries.
+const DefaultMaxRetries = 3
+
 func NewChunker(db DBInterface, config Config) *Chunker {
-	return &Chunker{db: db, Config: config}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+	c := &Chunker{db: db, Config: config, ctx: context.Background()}
+	c.sleep = c.sleepUnlessStopped
+	c.now = time.Now
+	return c
+}
+
+// SetReader routes range and boundary detection to a separate read-only
+// connection, such as an Aurora reader endpoint. Mutations stay on the
+// connection given to NewChunker.
+func (c *Chunker) SetReader(reader DBInterface) {
+	c.reader = reader
+}
+
+// state returns the connection holding the @unique_key_* session variables.
+func (c *Chunker) state() DBInterface {
+	if c.reader != nil {
+		return c.reader
+	}
+	return c.db
+}
+
+// syncRangeToWriter copies the current chunk's range variables from the
+// reader to the writer session, where the mutating statement reads them.
+// Session variables are per connection, so they travel as literals.
+func (c *Chunker) syncRangeToWriter() error {
+	if c.reader == nil {
+		return nil
+	}
+	n := c.Config.CountColumnsInUniqueKey
+	vals := make([]string, 0, 2*n)
+	for _, prefix := range []string{"unique_key_range_start_", "unique_key_range_end_"} {
+		for i := 0; i < n; i++ {
+			val, err := c.getSessionVariableValue(fmt.Sprintf("%s%d", prefix, i))
+			if err != nil {
+				return err
+			}
+			vals = append(vals, c.keyLiteral(val))
+		}
+	}
+	vars := c.getUniqueKeyRangeStartVariables() + "," + c.getUniqueKeyRangeEndVariables()
+	_, err := c.db.Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(vals, ","), vars))
+	return err
 }
 
 func (c *Chunker) Verbose(msg string) {
@@ -65,13 +196,26 @@ func (c *Chunker) Verbose(msg string) {
 	}
 }
 
+// Warn reports a condition the operator should know about, regardless of
+// verbosity.
+func (c *Chunker) Warn(msg string) {
+	c.anomaly(AnomalyWarning, 0, msg)
+}
+
+// formatRangeValue renders key values for display as the literals the
+// chunker writes them as: strings are quoted and escaped, so a NULL is not
+// mistaken for the string "NULL" and a value with a quote stays readable.
 func (c *Chunker) formatRangeValue(vals []interface{}) string {
-	if len(vals) == 1 {
-		return fmt.Sprintf("%v", vals[0])
-	}
 	strs := make([]string, len(vals))
 	for i, v := range vals {
-		strs[i] = fmt.Sprintf("%v", v)
+		if c.Config.UniqueKeyType == "binary" && v != nil {
+			strs[i] = fmt.Sprintf("0x%x", v)
+		} else {
+			strs[i] = c.keyLiteral(v)
+		}
+	}
+	if len(vals) == 1 {
+		return strs[0]
 	}
 	return "(" + strings.Join(strs, ",") + ")"
 }
@@ -90,25 +234,112 @@ func toFloat(val interface{}) float64 {
 	return 0
 }
 
+// QuoteIdentifier backtick-quotes a column or table name for use in SQL.
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// SplitColumnNames splits a comma-separated column list into bare column
+// names. Backtick-quoted names may contain commas and doubled backticks.
+func SplitColumnNames(list string) []string {
+	var names []string
+	var current strings.Builder
+	quoted := false
+	for i := 0; i < len(list); i++ {
+		ch := list[i]
+		switch {
+		case ch == '`' && quoted && i+1 < len(list) && list[i+1] == '`':
+			current.WriteByte('`')
+			i++
+		case ch == '`':
+			quoted = !quoted
+		case ch == ',' && !quoted:
+			names = append(names, strings.TrimSpace(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	return append(names, strings.TrimSpace(current.String()))
+}
+
+// DefaultStatementComment is the --statement-comment template used when the
+// flag is given without a value.
+const DefaultStatementComment = "go-chunk-update job={job} chunk={chunk}"
+
+// annotate prefixes query with the configured statement comment, so DBAs can
+// correlate statements in the processlist, performance_schema or slow log.
+// Any */ is broken up after the placeholders are filled in, so neither the
+// template nor a job id or table name can end the comment early.
+func (c *Chunker) annotate(query string, chunk int) string {
+	if c.Config.StatementComment == "" {
+		return query
+	}
+	comment := strings.NewReplacer(
+		"{job}", c.Config.JobID,
+		"{chunk}", strconv.Itoa(chunk),
+		"{table}", c.Config.Database+"."+c.Config.Table,
+	).Replace(c.Config.StatementComment)
+	return "/* " + strings.ReplaceAll(comment, "*/", "* /") + " */ " + query
+}
+
+// maxMergeFactor caps how many chunk-size key ranges --min-affected-per-chunk
+// may merge into a single statement.
+const maxMergeFactor = 64
+
+// nextMergeFactor grows the number of merged key ranges while chunks affect
+// fewer rows than minAffected, and shrinks it back once a merged chunk
+// reaches twice the threshold, so dense regions return to normal chunk sizes.
+func nextMergeFactor(current int, affected int64, minAffected int) int {
+	if affected < int64(minAffected) {
+		if current < maxMergeFactor {
+			return current * 2
+		}
+		return current
+	}
+	if current > 1 && affected >= int64(minAffected)*2 {
+		return current / 2
+	}
+	return current
+}
+
+// nextChunkSize halves the chunk size after a chunk that ran longer than
+// target, down to a single key, and doubles it back, up to configured, after
+// one that took less than half of it. In between it stays, so the size
+// settles instead of swinging around the target.
+func nextChunkSize(current, configured int, elapsed, target time.Duration) int {
+	switch {
+	case elapsed > target && current > 1:
+		return current / 2
+	case elapsed < target/2 && current < configured:
+		return min(current*2, configured)
+	}
+	return current
+}
+
 func (c *Chunker) GetSelectedUniqueKeyColumnNames() (string, int, string, error) {
 	if c.Config.ForcedChunkingColumn != "" {
 		tokens := strings.Split(c.Config.ForcedChunkingColumn, ",")
+		column, keyType := c.Config.ForcedChunkingColumn, ""
 		if len(tokens) > 1 {
 			c.Verbose(fmt.Sprintf("Forced columns: %s", c.Config.ForcedChunkingColumn))
-			return c.Config.ForcedChunkingColumn, len(tokens), "", nil
 		} else {
 			if strings.Contains(c.Config.ForcedChunkingColumn, ":") {
 				parts := strings.Split(c.Config.ForcedChunkingColumn, ":")
 				c.Verbose(fmt.Sprintf("Forced column %s of type %s", parts[0], parts[1]))
-				return parts[0], 1, parts[1], nil
+				column, keyType = parts[0], parts[1]
 			} else {
 				c.Verbose(fmt.Sprintf("Forced column %s of ungiven type", c.Config.ForcedChunkingColumn))
-				return c.Config.ForcedChunkingColumn, 1, "", nil
 			}
 		}
+		column, err := c.mapKeyColumns(column)
+		if err != nil {
+			return "", 0, "", err
+		}
+		return column, len(tokens), keyType, nil
 	}
 
-	rows, err := c.db.GetPossibleUniqueKeyColumns(c.Config.Database, c.Config.Table)
+	rows, err := c.uniqueKeyColumns()
 	if err != nil {
 		return "", 0, "", err
 	}
@@ -116,107 +347,135 @@ func (c *Chunker) GetSelectedUniqueKeyColumnNames() (string, int, string, error)
 		return "", 0, "", nil
 	}
 
-	row := rows[0]
-	columnNames := strings.ToLower(row["COLUMN_NAMES"].(string))
+	selected := selectableKey(rows)
+	if selected < 0 {
+		var keys []string
+		for _, row := range rows {
+			keys = append(keys, fmt.Sprintf("(%s) with NULLable %s", row["COLUMN_NAMES"], row["NULLABLE_COLUMNS"]))
+		}
+		return "", 0, "", fmt.Errorf("every unique key of %s.%s has NULLable columns: %s. Rows with NULL in a key column fall outside every chunk's range and would be skipped; make the columns NOT NULL, or force other columns with --force-chunking-column", c.Config.Database, c.Config.Table, strings.Join(keys, ", "))
+	}
+	row := rows[selected]
+	if selected > 0 {
+		c.Verbose("Skipping unique keys with NULLable columns, whose NULL rows chunk boundaries would miss")
+	}
+	// Column names keep the case of their definition, which the generated
+	// statements and the result rows' keys use
+	columnNames, err := c.mapKeyColumns(row["COLUMN_NAMES"].(string))
+	if err != nil {
+		return "", 0, "", err
+	}
 	countColumns := int(row["COUNT_COLUMN_IN_INDEX"].(int64))
 	dataType := strings.ToLower(row["DATA_TYPE"].(string))
 	charSet := row["CHARACTER_SET_NAME"]
 
-	uniqueKeyType := ""
-	if charSet != nil && charSet.(string) != "" {
-		uniqueKeyType = "text"
-	} else if strings.Contains(dataType, "int") {
-		uniqueKeyType = "integer"
-	} else if strings.Contains(dataType, "time") || strings.Contains(dataType, "date") {
-		uniqueKeyType = "temporal"
+	if n := len(SplitColumnNames(columnNames)); n != countColumns {
+		return "", 0, "", fmt.Errorf("unique key column list %q has %d columns, expected %d; it may have been truncated", columnNames, n, countColumns)
+	}
+
+	uniqueKeyType := classifyKeyType(dataType, charSet)
+	if uniqueKeyType == "float" {
+		c.Warn(fmt.Sprintf("chunking on floating-point key %s; boundaries are kept server-side to avoid precision loss", columnNames))
+	}
+
+	// A unique prefix index, e.g. UNIQUE(url(100)), still makes the full values
+	// unique, so boundaries on the full columns are correct. But the index
+	// stores only the prefix and cannot return rows in full-value order, so
+	// every boundary lookup sorts the remaining rows instead of reading n.
+	if prefixColumns, ok := row["PREFIX_COLUMNS"].(string); ok && prefixColumns != "" {
+		c.Warn(fmt.Sprintf("unique key (%s) indexes only a prefix of %s; boundary lookups cannot use the index and will scan and sort the table for every chunk", columnNames, prefixColumns))
 	}
 
 	return columnNames, countColumns, uniqueKeyType, nil
 }
 
+// selectableKey returns the index of the first unique key in rows that has
+// no NULLable column, or -1 when there is none. A UNIQUE index admits any
+// number of rows with NULL in one of its columns, and such a row satisfies no
+// range predicate, (a,b) > (x,y) included, so chunking by that key would
+// silently skip it.
+func selectableKey(rows []map[string]interface{}) int {
+	for i, row := range rows {
+		if nullable, _ := row["NULLABLE_COLUMNS"].(string); nullable == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// classifyKeyType maps the first key column's DATA_TYPE and
+// CHARACTER_SET_NAME to the chunking key type.
+func classifyKeyType(dataType string, charSet interface{}) string {
+	dataType = strings.ToLower(dataType)
+	cs, _ := charSet.(string)
+	switch {
+	case cs != "":
+		return "text"
+	case strings.Contains(dataType, "int"):
+		return "integer"
+	case strings.Contains(dataType, "time") || strings.Contains(dataType, "date"):
+		return "temporal"
+	case dataType == "float" || dataType == "double" || dataType == "real":
+		return "float"
+	case dataType == "decimal":
+		return "decimal"
+	case dataType == "binary" || dataType == "varbinary":
+		return "binary"
+	}
+	return ""
+}
+
 func (c *Chunker) GetUniqueKeyRange() ([]interface{}, []interface{}, bool, error) {
 	minVars := c.getUniqueKeyMinValuesVariables()
 	maxVars := c.getUniqueKeyMaxValuesVariables()
 
 	if c.Config.StartWith != "" {
-		if c.Config.UniqueKeyType == "integer" && c.Config.CountColumnsInUniqueKey == 1 {
-			if startInt, err := strconv.Atoi(c.Config.StartWith); err == nil {
-				query := fmt.Sprintf("SELECT %d INTO %s", startInt, minVars)
-				_, err := c.db.Exec(query)
-				if err != nil {
-					return nil, nil, false, err
-				}
-				c.Verbose(fmt.Sprintf("Starting with: %d", startInt))
-			} else {
-				row, err := c.db.QueryRow(c.Config.StartWith)
-				if err != nil {
-					return nil, nil, false, err
-				}
-				startInt := row["start_with"].(int64)
-				query := fmt.Sprintf("SELECT %d INTO %s", startInt, minVars)
-				_, err = c.db.Exec(query)
-				if err != nil {
-					return nil, nil, false, err
-				}
-				c.Verbose(fmt.Sprintf("Starting with: %d", startInt))
-			}
-		} else {
-			return nil, nil, false, fmt.Errorf("--start-with only applies to single column integer chunking keys")
+		start, err := c.rangeBound(c.Config.StartWith, "start_with")
+		if err != nil {
+			return nil, nil, false, err
 		}
+		if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", start, minVars)); err != nil {
+			return nil, nil, false, err
+		}
+		c.Verbose(fmt.Sprintf("Starting with: %s", start))
 	} else {
 		query := fmt.Sprintf(`
 			SELECT %s INTO %s
-			FROM %s.%s
+			FROM %s
 			ORDER BY %s LIMIT 1
-		`, c.Config.UniqueKeyColumnNames, minVars, c.Config.Database, c.Config.Table, c.Config.UniqueKeyColumnNames)
-		_, err := c.db.Exec(query)
+		`, c.Config.UniqueKeyColumnNames, minVars, c.tableRef(), c.Config.UniqueKeyColumnNames)
+		_, err := c.state().Exec(c.ctx, query)
 		if err != nil {
 			return nil, nil, false, err
 		}
 	}
 
 	if c.Config.EndWith != "" {
-		if c.Config.UniqueKeyType == "integer" && c.Config.CountColumnsInUniqueKey == 1 {
-			if endInt, err := strconv.Atoi(c.Config.EndWith); err == nil {
-				query := fmt.Sprintf("SELECT %d INTO %s", endInt, maxVars)
-				_, err := c.db.Exec(query)
-				if err != nil {
-					return nil, nil, false, err
-				}
-			} else {
-				row, err := c.db.QueryRow(c.Config.EndWith)
-				if err != nil {
-					return nil, nil, false, err
-				}
-				endInt := row["end_with"].(int64)
-				query := fmt.Sprintf("SELECT %d INTO %s", endInt, maxVars)
-				_, err = c.db.Exec(query)
-				if err != nil {
-					return nil, nil, false, err
-				}
-			}
-		} else {
-			return nil, nil, false, fmt.Errorf("--end-with only applies to single column integer chunking keys")
+		end, err := c.rangeBound(c.Config.EndWith, "end_with")
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", end, maxVars)); err != nil {
+			return nil, nil, false, err
 		}
 	} else {
 		query := fmt.Sprintf(`
 			SELECT %s INTO %s
-			FROM %s.%s
+			FROM %s
 			ORDER BY %s DESC LIMIT 1
-		`, c.Config.UniqueKeyColumnNames, maxVars, c.Config.Database, c.Config.Table, c.Config.UniqueKeyColumnNames)
-		_, err := c.db.Exec(query)
+		`, c.Config.UniqueKeyColumnNames, maxVars, c.tableRef(), c.Config.UniqueKeyColumnNames)
+		_, err := c.state().Exec(c.ctx, query)
 		if err != nil {
 			return nil, nil, false, err
 		}
 	}
 
-	query := fmt.Sprintf("SELECT COUNT(*) AS range_exists FROM (SELECT NULL FROM %s.%s LIMIT 1) SEL1", c.Config.Database, c.Config.Table)
-	row, err := c.db.QueryRow(query)
+	query := fmt.Sprintf("SELECT COUNT(*) AS range_exists FROM (SELECT NULL FROM %s LIMIT 1) SEL1", c.tableRef())
+	row, err := c.state().QueryRow(c.ctx, query)
 	if err != nil {
 		return nil, nil, false, err
 	}
-	val := row["range_exists"]
-	rangeExists := val != nil && val.(int64) > 0
+	rangeExists := toFloat(row["range_exists"]) > 0
 
 	if rangeExists {
 		minValues := make([]interface{}, c.Config.CountColumnsInUniqueKey)
@@ -233,6 +492,12 @@ func (c *Chunker) GetUniqueKeyRange() ([]interface{}, []interface{}, bool, error
 			}
 			maxValues[i] = maxVal
 		}
+		if slices.Contains(minValues, nil) || slices.Contains(maxValues, nil) {
+			// A --start-with or --end-with query found no row, or a forced
+			// NULLable key holds NULLs; no key compares with a NULL bound
+			c.Warn(fmt.Sprintf("key range (%s, %s) has a NULL bound, nothing to process", c.formatRangeValue(minValues), c.formatRangeValue(maxValues)))
+			return nil, nil, false, nil
+		}
 		if c.Config.Verbose {
 			fmt.Printf("-- %s (min, max) values: (%s, %s)\n", c.Config.UniqueKeyColumnNames, c.formatRangeValue(minValues), c.formatRangeValue(maxValues))
 		}
@@ -242,6 +507,215 @@ func (c *Chunker) GetUniqueKeyRange() ([]interface{}, []interface{}, bool, error
 	return nil, nil, false, nil
 }
 
+// rangeBound returns the literal a --start-with or --end-with value sets its
+// range variable to. A number of the key's type, or a date or datetime on a
+// temporal key, is taken as is; on a float key the number is written as a
+// DOUBLE literal, see floatBound. Any other value is a query, whose column of
+// that name in its first row is used. On a text key the value is always the
+// bound itself, quoted and escaped. On a composite key it is a tuple such as
+// (100,'abc'), and the literals are returned comma-separated.
+func (c *Chunker) rangeBound(spec, column string) (string, error) {
+	flag := "--" + strings.ReplaceAll(column, "_", "-")
+	if n := c.Config.CountColumnsInUniqueKey; n > 1 {
+		literals, err := parseKeyTuple(spec, n)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", flag, err)
+		}
+		return strings.Join(literals, ","), nil
+	}
+	keyType := c.Config.UniqueKeyType
+	supported := keyType == "integer" || keyType == "decimal" || keyType == "float" || keyType == "temporal" || keyType == "text"
+	if !supported {
+		return "", fmt.Errorf("%s only applies to integer, decimal, float, temporal, text and composite chunking keys", flag)
+	}
+	if keyType == "text" {
+		return sqlLiteral(spec), nil
+	}
+	if keyType == "decimal" && decimalLiteralRe.MatchString(spec) {
+		return spec, nil
+	}
+	if literal, ok := floatBound(spec); ok && keyType == "float" {
+		return literal, nil
+	}
+	if i, err := strconv.Atoi(spec); err == nil && keyType == "integer" {
+		return strconv.Itoa(i), nil
+	}
+	if keyType == "temporal" {
+		if literal, ok, err := temporalBound(spec); ok {
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", flag, err)
+			}
+			return literal, nil
+		}
+	}
+	row, err := c.state().QueryRow(c.ctx, spec)
+	if err != nil {
+		return "", err
+	}
+	switch v := row[column].(type) {
+	case int64:
+		if keyType != "temporal" {
+			return strconv.FormatInt(v, 10), nil
+		}
+	case float64:
+		if keyType == "float" {
+			return strconv.FormatFloat(v, 'e', -1, 64), nil
+		}
+	case time.Time:
+		if keyType == "temporal" {
+			return sqlLiteral(v), nil
+		}
+	case string:
+		if keyType == "decimal" && decimalLiteralRe.MatchString(v) {
+			return v, nil
+		}
+		if literal, ok := floatBound(v); ok && keyType == "float" {
+			return literal, nil
+		}
+		if literal, ok, err := temporalBound(v); keyType == "temporal" && ok && err == nil {
+			return literal, nil
+		}
+	}
+	return "", fmt.Errorf("%s query returned %v as %s, not a value of the %s key", flag, row[column], column, keyType)
+}
+
+// floatBound parses a range bound on a FLOAT or DOUBLE key and returns it as
+// a DOUBLE literal. The exponent makes the server read it as a DOUBLE, and
+// the shortest form that round-trips gives back exactly the parsed value, so
+// the bound is the same number on both sides. It reports false for anything
+// but a finite number.
+func floatBound(value string) (string, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'e', -1, 64), true
+}
+
+// temporalLayouts are the forms of a date or datetime range bound. Parsing
+// accepts fractional seconds after the seconds too.
+var temporalLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// temporalBound validates a date or datetime range bound, optionally in
+// single quotes, and returns it as a quoted literal. It reports false when
+// value does not start with a digit, as a query does not.
+func temporalBound(value string) (string, bool, error) {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+	if value == "" || value[0] < '0' || value[0] > '9' {
+		return "", false, nil
+	}
+	for _, layout := range temporalLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return sqlLiteral(value), true, nil
+		}
+	}
+	return "", true, fmt.Errorf("%q is not a date or datetime such as '2023-01-01 00:00:00'", value)
+}
+
+// parseKeyTuple parses a composite key bound such as (100,'abc') into the
+// SQL literals of its n values. A value is a number, a 0x hex literal for a
+// binary column, or a string; strings in single quotes may hold commas, with
+// quotes doubled or backslash-escaped, and bare ones are taken as is. Every
+// string is re-quoted, so none can break out of its literal.
+func parseKeyTuple(spec string, n int) ([]string, error) {
+	inner := strings.TrimSpace(spec)
+	if strings.HasPrefix(inner, "(") && strings.HasSuffix(inner, ")") {
+		inner = inner[1 : len(inner)-1]
+	}
+	var values []string
+	var current strings.Builder
+	quoted, wasQuoted, empty := false, false, false
+	finish := func() {
+		value := current.String()
+		if !wasQuoted {
+			value = strings.TrimSpace(value)
+		}
+		switch {
+		case wasQuoted:
+			values = append(values, sqlLiteral(value))
+		case value == "":
+			empty = true
+		case isNumericLiteral(value):
+			values = append(values, value)
+		default:
+			values = append(values, sqlLiteral(value))
+		}
+		current.Reset()
+		wasQuoted = false
+	}
+	for i := 0; i < len(inner); i++ {
+		ch := inner[i]
+		switch {
+		case quoted && ch == '\\' && i+1 < len(inner):
+			i++
+			current.WriteByte(inner[i])
+		case quoted && ch == '\'' && i+1 < len(inner) && inner[i+1] == '\'':
+			i++
+			current.WriteByte('\'')
+		case quoted && ch == '\'':
+			quoted = false
+		case quoted:
+			current.WriteByte(ch)
+		case ch == '\'' && strings.TrimSpace(current.String()) == "" && !wasQuoted:
+			current.Reset()
+			quoted, wasQuoted = true, true
+		case ch == ',':
+			finish()
+		case wasQuoted && ch != ' ':
+			return nil, fmt.Errorf("invalid key tuple %s: unexpected %q after a quoted value", spec, string(ch))
+		case !wasQuoted:
+			current.WriteByte(ch)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid key tuple %s: unterminated string literal", spec)
+	}
+	finish()
+	if empty {
+		return nil, fmt.Errorf("invalid key tuple %s: empty value; quote an empty string as ''", spec)
+	}
+	if len(values) != n {
+		return nil, fmt.Errorf("key tuple %s has %d values, expected %d for the key's columns", spec, len(values), n)
+	}
+	return values, nil
+}
+
+// isNumericLiteral reports whether value is an exact number or a 0x hex
+// literal.
+func isNumericLiteral(value string) bool {
+	if len(value) > 2 && strings.HasPrefix(strings.ToLower(value), "0x") {
+		_, err := hex.DecodeString(value[2:])
+		return err == nil
+	}
+	return decimalLiteralRe.MatchString(value)
+}
+
+// WithTableLock runs fn while holding a READ lock on the table. The lock is
+// released afterwards, unless fn failed and KeepLockOnError is set; the caller
+// then owns the still-locked connection.
+func (c *Chunker) WithTableLock(fn func() error) error {
+	c.Verbose("Table locked READ")
+	if err := c.db.LockTableRead(c.Config.Database, c.Config.Table); err != nil {
+		return fmt.Errorf("Lock error: %v", err)
+	}
+	c.locked = true
+
+	err := fn()
+	if err != nil && c.Config.KeepLockOnError {
+		return err
+	}
+
+	c.locked = false
+	c.Verbose("Table unlocked")
+	if unlockErr := c.db.UnlockTables(); unlockErr != nil && err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
 func (c *Chunker) getUniqueKeyMinValuesVariables() string {
 	vars := make([]string, c.Config.CountColumnsInUniqueKey)
 	for i := 0; i < c.Config.CountColumnsInUniqueKey; i++ {
@@ -274,21 +748,176 @@ func (c *Chunker) getUniqueKeyRangeEndVariables() string {
 	return strings.Join(vars, ",")
 }
 
+// rangePredicate returns the condition on the key columns cols selecting the
+// current chunk's keys, from the range start (exclusive, or inclusive with
+// ">=") up to the range end.
+func (c *Chunker) rangePredicate(cols, lowOp string) string {
+	if c.Config.CountColumnsInUniqueKey == 1 {
+		return fmt.Sprintf("%s %s @unique_key_range_start_0 AND %s <= @unique_key_range_end_0", cols, lowOp, cols)
+	}
+	return fmt.Sprintf("(%s) %s (%s) AND (%s) <= (%s)", cols, lowOp, c.getUniqueKeyRangeStartVariables(), cols, c.getUniqueKeyRangeEndVariables())
+}
+
+// boundarySource returns the FROM clause selecting the last key of the next
+// limit keys after startVars, a list of session variables. lowOp is ">=" on
+// the first chunk of a fresh run, which includes the minimum, and ">" after
+// that: the previous chunk's end is excluded by the predicate rather than
+// counted by the LIMIT, so each chunk holds exactly limit keys and no
+// LIMIT limit+1 compensation is needed. Chunks then tile the key range, each
+// starting where the previous one ended; --debug verifies this as it runs
+// (see checkChunkEdges).
+func (c *Chunker) boundarySource(startVars, lowOp string, limit int) string {
+	return c.boundarySourceLocking(startVars, lowOp, limit, "")
+}
+
+// chunkBoundarySource is boundarySource for the chunk about to run. With
+// LockBoundaryReads it reads the chunk's keys with a shared lock, held by the
+// chunk's transaction until its statement commits.
+func (c *Chunker) chunkBoundarySource(startVars, lowOp string, limit int) string {
+	lock := ""
+	if c.Config.LockBoundaryReads {
+		lock = " LOCK IN SHARE MODE"
+	}
+	return c.boundarySourceLocking(startVars, lowOp, limit, lock)
+}
+
+func (c *Chunker) boundarySourceLocking(startVars, lowOp string, limit int, lock string) string {
+	return fmt.Sprintf("FROM (SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d%s) t ORDER BY %s DESC LIMIT 1", c.Config.UniqueKeyColumnNames, c.tableRef(), c.remainingPredicate(c.Config.UniqueKeyColumnNames, startVars, lowOp), c.Config.UniqueKeyColumnNames, limit, lock, c.Config.UniqueKeyColumnNames)
+}
+
+// remainingPredicate selects the keys, in the key columns cols, from
+// startVars up to the range maximum.
+func (c *Chunker) remainingPredicate(cols, startVars, lowOp string) string {
+	if c.Config.CountColumnsInUniqueKey == 1 {
+		return fmt.Sprintf("%s %s %s AND %s <= @unique_key_max_value_0", cols, lowOp, startVars, cols)
+	}
+	return fmt.Sprintf("(%s) %s (%s) AND (%s) <= (%s)", cols, lowOp, startVars, cols, c.getUniqueKeyMaxValuesVariables())
+}
+
 func (c *Chunker) getSessionVariableValue(name string) (interface{}, error) {
 	query := fmt.Sprintf("SELECT @%s AS %s", name, name)
-	row, err := c.db.QueryRow(query)
+	row, err := c.state().QueryRow(c.ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	return row[name], nil
 }
 
-func (c *Chunker) ChunkUpdate(executeQuery string) error {
-	if c.Config.NoLogBin {
-		_, err := c.db.Exec("SET SESSION SQL_LOG_BIN=0")
+// ParseChunkToken returns the [database.]table argument of the first GO_CHUNK
+// token in query and its alias, if any, or false when there is no token.
+func ParseChunkToken(query string) (string, string, bool) {
+	m := chunkTokenRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// replaceChunkPlaceholder substitutes the predicate built by predicate for
+// GO_CHUNK(table) and GO_CHUNK(database.table). predicate gets the key
+// columns to compare, qualified with the token's alias when it has one, as in
+// GO_CHUNK(table AS t), so the predicate can tell the chunked table's key
+// from a joined table's column of the same name. Qualified columns are
+// quoted, as a key column may be named like a reserved word.
+func (c *Chunker) replaceChunkPlaceholder(executeQuery string, predicate func(cols string) string) string {
+	return chunkTokenRe.ReplaceAllStringFunc(executeQuery, func(token string) string {
+		m := chunkTokenRe.FindStringSubmatch(token)
+		if m[1] != c.Config.Table && m[1] != c.Config.Database+"."+c.Config.Table {
+			return token
+		}
+		if m[2] == "" {
+			return predicate(c.Config.UniqueKeyColumnNames)
+		}
+		cols := make([]string, len(c.Config.UniqueKeyColumnNamesList))
+		for i, col := range c.Config.UniqueKeyColumnNamesList {
+			cols[i] = m[2] + "." + QuoteIdentifier(col)
+		}
+		return predicate(strings.Join(cols, ","))
+	})
+}
+
+// setRangeEnd stores the boundary row of the current chunk in the
+// @unique_key_range_end_* session variables.
+func (c *Chunker) setRangeEnd(row map[string]interface{}, boundarySource string, chunkIndex int) error {
+	var err error
+	if c.Config.UniqueKeyType == "float" || c.Config.UniqueKeyType == "binary" {
+		// A floating-point value formatted on the client may not parse back
+		// to the stored value, and a binary one (e.g. from UUID_TO_BIN) is
+		// no valid string literal, so the boundary never leaves the server
+		_, err = c.state().Exec(c.ctx, c.annotate(fmt.Sprintf("SELECT %s INTO %s %s", c.Config.UniqueKeyColumnNames, c.getUniqueKeyRangeEndVariables(), boundarySource), chunkIndex))
+	} else {
+		// A text or temporal boundary must stay a string literal: bare, it
+		// would be read as a column name or compared as a number
+		vals := make([]string, c.Config.CountColumnsInUniqueKey)
+		for i, col := range c.Config.UniqueKeyColumnNamesList {
+			vals[i] = c.keyLiteral(row[col])
+		}
+		endVars := c.getUniqueKeyRangeEndVariables()
+		_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(vals, ","), endVars))
+	}
+	return err
+}
+
+// ChunkUpdate runs executeQuery chunk by chunk over the selected key range and
+// reports a summary of the run, including when it fails part way. Cancelling
+// ctx between chunks ends the run with ReasonInterrupted, as the stop signal
+// does; cancelling it during a statement abandons the statement and fails the
+// run. The batch is still committed or rolled back and the session restored.
+func (c *Chunker) ChunkUpdate(ctx context.Context, executeQuery string) (RunSummary, error) {
+	var summary RunSummary
+	start := time.Now()
+	defer func(previous context.Context) { c.ctx = previous }(c.ctx)
+	c.ctx = ctx
+	c.retries, c.skipped = 0, 0
+	c.manifest = nil
+	c.setStatus("starting")
+	stopHeartbeat := c.startHeartbeat()
+	err := c.chunkUpdate(executeQuery, &summary)
+	stopHeartbeat()
+	c.ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		err = c.endBatch(err, &summary)
+	}
+	if restoreErr := c.restoreSession(); err == nil {
+		err = restoreErr
+	}
+	summary.Elapsed = time.Since(start)
+	summary.Retries, summary.Skipped = c.retries, c.skipped
+	if err != nil {
+		summary.Reason = ReasonError
+	}
+	c.report().Summary(summary)
+	return summary, err
+}
+
+func (c *Chunker) chunkUpdate(executeQuery string, summary *RunSummary) error {
+	start := time.Now()
+	if c.Config.Partition != "" {
+		scoped, err := ScopeToPartition(executeQuery, c.Config.Database, c.Config.Table, c.Config.Partition)
+		if err != nil {
+			return err
+		}
+		executeQuery = scoped
+	}
+	c.queryHash = hashQuery(executeQuery)
+
+	if c.Config.DryRun && c.batching() {
+		// A dry run commits nothing, so it has no transactions to batch
+		return fmt.Errorf("a dry run cannot batch chunks into transactions")
+	}
+
+	stragglers := ""
+	// Every matching row is left over after a dry run
+	if c.Config.VerifyNoGaps && !c.Config.DryRun {
+		query, err := c.stragglerQuery(executeQuery)
 		if err != nil {
 			return err
 		}
+		stragglers = query
+	}
+
+	if err := c.setupSession(nil); err != nil {
+		return err
 	}
 
 	// Get min and max for progress calculation
@@ -300,33 +929,128 @@ func (c *Chunker) ChunkUpdate(executeQuery string) error {
 	if err != nil {
 		return err
 	}
+	if minVal == nil || maxVal == nil {
+		// GetUniqueKeyRange found no range, or was not run
+		c.Verbose("Key range has a NULL bound, nothing to process")
+		summary.Reason = ReasonCompleted
+		return nil
+	}
 
-	// Build queries
-	var firstQuery, restQuery string
-	if c.Config.CountColumnsInUniqueKey == 1 {
-		firstQuery = strings.Replace(executeQuery, "GO_CHUNK("+c.Config.Table+")", fmt.Sprintf("%s >= @unique_key_min_value_0 AND %s < @unique_key_range_end_0", c.Config.UniqueKeyColumnNames, c.Config.UniqueKeyColumnNames), -1)
-		restQuery = strings.Replace(executeQuery, "GO_CHUNK("+c.Config.Table+")", fmt.Sprintf("%s > @unique_key_range_start_0 AND %s < @unique_key_range_end_0", c.Config.UniqueKeyColumnNames, c.Config.UniqueKeyColumnNames), -1)
-	} else {
-		cols := c.Config.UniqueKeyColumnNames
-		minVars := c.getUniqueKeyMinValuesVariables()
-		startVars := c.getUniqueKeyRangeStartVariables()
-		endVars := c.getUniqueKeyRangeEndVariables()
-		firstQuery = strings.Replace(executeQuery, "GO_CHUNK("+c.Config.Table+")", fmt.Sprintf("(%s) >= (%s) AND (%s) < (%s)", cols, minVars, cols, endVars), -1)
-		restQuery = strings.Replace(executeQuery, "GO_CHUNK("+c.Config.Table+")", fmt.Sprintf("(%s) > (%s) AND (%s) < (%s)", cols, startVars, cols, endVars), -1)
+	// Build queries. The first chunk includes its lower bound; every chunk
+	// includes its upper bound, so consecutive chunks share no rows and skip none.
+	firstQuery := c.replaceChunkPlaceholder(executeQuery, func(cols string) string { return c.rangePredicate(cols, ">=") })
+	restQuery := c.replaceChunkPlaceholder(executeQuery, func(cols string) string { return c.rangePredicate(cols, ">") })
+
+	if c.Config.ThrottleFile != "" {
+		c.throttle = &throttleFile{path: c.Config.ThrottleFile}
+	}
+
+	var resume *Checkpoint
+	if c.Config.CheckpointFile != "" {
+		resume, err = LoadCheckpoint(c.Config.CheckpointFile)
+		if err != nil {
+			return err
+		}
+		if resume == nil && c.Config.ResumeStrict {
+			// A missing checkpoint would restart the job from the minimum
+			return fmt.Errorf("no checkpoint in %s to resume from, refusing to start from the beginning", c.Config.CheckpointFile)
+		}
+		if resume != nil && c.Config.RemapKey != "" {
+			if strings.EqualFold(resume.Columns, c.Config.UniqueKeyColumnNames) && resume.KeyType == c.Config.UniqueKeyType {
+				c.Warn("checkpoint already matches the current key, ignoring --remap-key")
+			} else {
+				remapped, err := resume.Remap(c.Config.RemapKey, c.Config)
+				if err != nil {
+					return err
+				}
+				c.Verbose(fmt.Sprintf("Remapped checkpoint boundary (%s) on (%s) to (%s) on (%s)", strings.Join(resume.Boundary, ","), resume.Columns, strings.Join(remapped.Boundary, ","), remapped.Columns))
+				resume = remapped
+			}
+		}
+		if resume != nil {
+			if err := resume.Validate(c.Config); err != nil {
+				if c.metadata != nil {
+					c.metadata.Invalidate(c.Config.Database, c.Config.Table)
+				}
+				return err
+			}
+			if err := resume.ValidateQuery(executeQuery); err != nil {
+				return fmt.Errorf("%s: %v", c.Config.CheckpointFile, err)
+			}
+		}
 	}
 
 	// Set initial range
-	if c.Config.CountColumnsInUniqueKey == 1 {
-		query := "SELECT @unique_key_min_value_0 INTO @unique_key_range_start_0"
-		_, err = c.db.Exec(query)
+	startVars := c.getUniqueKeyRangeStartVariables()
+	if resume != nil {
+		_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(resume.Boundary, ","), startVars))
 		if err != nil {
 			return err
 		}
+		c.Verbose(fmt.Sprintf("Resuming after committed boundary (%s) from %s", strings.Join(resume.Boundary, ","), c.Config.CheckpointFile))
+		c.resumedFrom(resume.Boundary)
 	} else {
-		minVars := c.getUniqueKeyMinValuesVariables()
-		startVars := c.getUniqueKeyRangeStartVariables()
-		query := fmt.Sprintf("SELECT %s INTO %s", minVars, startVars)
-		_, err = c.db.Exec(query)
+		_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", c.getUniqueKeyMinValuesVariables(), startVars))
+		if err != nil {
+			return err
+		}
+	}
+
+	// A resumed run must start strictly after the committed boundary
+	firstRound := resume == nil
+	if c.Config.ManifestFile != "" {
+		firstRound, err = c.startManifest(executeQuery, firstRound)
+		if err != nil {
+			return err
+		}
+	}
+
+	// keysDone counts the keys the chunks done covered, so merged and
+	// shrunk chunks count for their share of the chunks of ChunkSize keys
+	totalChunks, keysDone := 0, 0
+	if c.Config.SkipUpfrontEstimate && (c.Config.AccurateProgress || c.Config.SingleStatement) {
+		c.Verbose("Skipping the upfront chunk count, starting the first chunk at once with interpolated progress")
+	}
+	if c.Config.AccurateProgress && !c.Config.SkipUpfrontEstimate {
+		computeStart := time.Now()
+		boundaries, err := c.ComputeBoundaries(firstRound)
+		if err != nil {
+			return err
+		}
+		totalChunks = len(boundaries)
+		c.Verbose(fmt.Sprintf("Counted %d chunks for progress in %.1f seconds", totalChunks, time.Since(computeStart).Seconds()))
+	}
+	if c.manifest != nil {
+		totalChunks, keysDone = len(c.manifest.manifest.Boundaries), c.manifest.next*c.Config.ChunkSize
+	}
+
+	if c.Config.BoundaryPrefetch && c.reader == nil {
+		return fmt.Errorf("boundary prefetch needs a read connection separate from the writer")
+	}
+
+	if c.Config.LockBoundaryReads {
+		// The shared locks must be taken by the writer's transaction
+		if c.reader != nil {
+			return fmt.Errorf("locking boundary reads cannot run on a separate read connection")
+		}
+		if c.Config.DenseKeys != "" && c.Config.DenseKeys != DenseKeysOff {
+			return fmt.Errorf("locking boundary reads need boundary queries, which the dense key optimization skips")
+		}
+	}
+
+	// A manifest fixes every boundary, so none is queried or computed
+	planned := c.manifest != nil
+	single := false
+	if c.Config.SingleStatement && !planned && !c.Config.SkipUpfrontEstimate {
+		single, err = c.fitsOneChunk(firstRound)
+		if err != nil {
+			return err
+		}
+	}
+
+	var dense *denseKey
+	if !single && !planned {
+		dense, err = c.startDenseKey(firstRound)
 		if err != nil {
 			return err
 		}
@@ -334,130 +1058,280 @@ func (c *Chunker) ChunkUpdate(executeQuery string) error {
 
 	totalAffected := int64(0)
 	totalElapsed := time.Duration(0)
-	firstRound := true
+	mergeFactor := 1
+	// chunkSize is ChunkSize, or less while chunks overrun MaxChunkTime
+	chunkSize := c.Config.ChunkSize
+	chunkIndex := 0
+	var prefetched *boundaryPrefetch
+	// prevEnd is the end of the previous chunk of this run
+	var prevEnd []interface{}
+
+	// Replicas already behind would only fall further behind with the first
+	// chunk
+	c.waitForReplicas()
 
 	for {
-		// Set range end
-		limit := c.Config.ChunkSize
-		if !firstRound {
-			limit++
+		if c.stopRequested() {
+			summary.Reason = ReasonInterrupted
+			break
 		}
-		var whereClause string
-		if c.Config.CountColumnsInUniqueKey == 1 {
-			whereClause = fmt.Sprintf("%s > @unique_key_range_start_0 AND %s <= @unique_key_max_value_0", c.Config.UniqueKeyColumnNames, c.Config.UniqueKeyColumnNames)
-		} else {
-			whereClause = fmt.Sprintf("(%s) > (%s) AND (%s) <= (%s)", c.Config.UniqueKeyColumnNames, c.getUniqueKeyRangeStartVariables(), c.Config.UniqueKeyColumnNames, c.getUniqueKeyMaxValuesVariables())
+		chunkIndex++
+		// Set range end
+		limit := chunkSize * mergeFactor
+		lowOp := ">"
+		if firstRound {
+			lowOp = ">="
 		}
-		query := fmt.Sprintf("SELECT %s FROM (SELECT %s FROM %s.%s WHERE %s ORDER BY %s LIMIT %d) t ORDER BY %s DESC LIMIT 1", c.Config.UniqueKeyColumnNames, c.Config.UniqueKeyColumnNames, c.Config.Database, c.Config.Table, whereClause, c.Config.UniqueKeyColumnNames, limit, c.Config.UniqueKeyColumnNames)
-		row, err := c.db.QueryRow(query)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				// No more rows, set end to max
-				if c.Config.CountColumnsInUniqueKey == 1 {
-					_, err = c.db.Exec("SELECT @unique_key_max_value_0 INTO @unique_key_range_end_0")
-				} else {
-					maxVars := c.getUniqueKeyMaxValuesVariables()
-					endVars := c.getUniqueKeyRangeEndVariables()
-					_, err = c.db.Exec(fmt.Sprintf("SELECT %s INTO %s", maxVars, endVars))
-				}
-				if err != nil {
-					return err
-				}
+		boundarySource := c.chunkBoundarySource(c.getUniqueKeyRangeStartVariables(), lowOp, limit)
+		c.setStatus("finding the end of chunk %d", chunkIndex)
+		var row map[string]interface{}
+		if single {
+			// The whole remaining range is one chunk, ending at the maximum
+			_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", c.getUniqueKeyMaxValuesVariables(), c.getUniqueKeyRangeEndVariables()))
+		} else if planned {
+			err = c.manifestBoundary()
+		} else if dense != nil {
+			if end, ok := dense.boundary(limit); ok {
+				_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %d INTO @unique_key_range_end_0", end))
 			} else {
-				return err
+				err = sql.ErrNoRows
+			}
+		} else if prefetched != nil && prefetched.limit == limit {
+			row, err = prefetched.row, prefetched.err
+			if err == nil {
+				err = c.checkBoundaryTime(prefetched.elapsed, chunkIndex)
 			}
 		} else {
-			// Normal processing
-			if c.Config.CountColumnsInUniqueKey == 1 {
-				endVal := row[c.Config.UniqueKeyColumnNames]
-				var endValStr string
-				if s, ok := endVal.(string); ok {
-					endValStr = s
-				} else if i, ok := endVal.(int64); ok {
-					endValStr = strconv.FormatInt(i, 10)
-				} else {
-					endValStr = fmt.Sprintf("%v", endVal)
-				}
-				_, err = c.db.Exec(fmt.Sprintf("SELECT %s INTO @unique_key_range_end_0", endValStr))
-			} else {
-				vals := make([]string, c.Config.CountColumnsInUniqueKey)
-				for i, col := range c.Config.UniqueKeyColumnNamesList {
-					val := row[col]
-					if s, ok := val.(string); ok {
-						vals[i] = fmt.Sprintf("'%s'", s)
-					} else {
-						vals[i] = fmt.Sprintf("%v", val)
-					}
-				}
-				endVars := c.getUniqueKeyRangeEndVariables()
-				_, err = c.db.Exec(fmt.Sprintf("SELECT %s INTO %s", strings.Join(vals, ","), endVars))
+			// No prefetch, or the merge factor changed since it was issued
+			queryStart := time.Now()
+			row, err = c.state().QueryRow(c.ctx, c.annotate(fmt.Sprintf("SELECT %s %s", c.Config.UniqueKeyColumnNames, boundarySource), chunkIndex))
+			if err == nil {
+				err = c.checkBoundaryTime(time.Since(queryStart), chunkIndex)
 			}
-			if err != nil {
+		}
+		prefetched = nil
+		if err == sql.ErrNoRows {
+			// No rows remain past the last processed boundary
+			summary.Reason = ReasonCompleted
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if dense == nil && !single && !planned {
+			if err := c.setRangeEnd(row, boundarySource, chunkIndex); err != nil {
 				return err
 			}
 		}
 
-		// Get current range for display
-		startVal, err := c.getSessionVariableValue("unique_key_range_start_0")
+		// Get current range for display, and keep it to restore the session
+		// after a reconnect
+		snapshot, err := c.sessionSnapshot()
 		if err != nil {
 			return err
 		}
-		endVal, err := c.getSessionVariableValue("unique_key_range_end_0")
-		if err != nil {
-			return err
+		if c.Config.Debug {
+			if err := c.checkChunkEdges(prevEnd, snapshot, firstRound); err != nil {
+				return err
+			}
 		}
+		startVal := snapshot["unique_key_range_start_0"]
+		endVal := snapshot["unique_key_range_end_0"]
 
 		// Calculate progress
-		progress := 0
-		if maxVal != nil && minVal != nil && startVal != nil {
-			minF := toFloat(minVal)
-			maxF := toFloat(maxVal)
-			startF := toFloat(startVal)
-			if maxF > minF {
-				progress = int((startF - minF) / (maxF - minF) * 100)
-			}
+		progress := interpolatedProgress(minVal, maxVal, startVal)
+		if c.Config.UniqueKeyType == "binary" {
+			progress = interpolatedProgress(binaryPosition(minVal), binaryPosition(maxVal), binaryPosition(startVal))
+		}
+		if totalChunks > 0 {
+			progress = chunkProgress(keysDone/c.Config.ChunkSize, totalChunks)
+		}
+		if c.Config.TotalRows > 0 {
+			progress = rowProgress(totalAffected, c.Config.TotalRows)
 		}
 
-		if c.Config.Verbose {
-			fmt.Printf("-- Performing chunks range %s, %s, progress: %d%%\n", c.formatRangeValue([]interface{}{startVal}), c.formatRangeValue([]interface{}{endVal}), progress)
+		report := ChunkReport{
+			Index:    chunkIndex,
+			Start:    c.formatRangeValue([]interface{}{startVal}),
+			End:      c.formatRangeValue([]interface{}{endVal}),
+			Progress: progress,
 		}
+		c.report().ChunkStarted(report)
 
-		// Check if overflow
-		if !firstRound {
-			row, err := c.db.QueryRow("SELECT @unique_key_range_start_0 >= @unique_key_max_value_0 AS overflow")
+		q := restQuery
+		if firstRound {
+			q = firstQuery
+		}
+
+		if c.Config.ConfirmEachChunk {
+			if c.confirmer == nil {
+				c.SetConfirmInput(os.Stdin, os.Stderr)
+			}
+			ok, err := c.confirmer.confirm(q, report)
 			if err != nil {
 				return err
 			}
-			if row["overflow"].(int64) == 1 {
+			if !ok {
+				c.logAnomaly(AnomalySkip, chunkIndex, fmt.Sprintf("chunk %d declined at the confirmation prompt; stopping", chunkIndex))
+				summary.Reason = ReasonDeclined
 				break
 			}
 		}
 
-		q := restQuery
-		if firstRound {
-			q = firstQuery
+		if err := c.syncRangeToWriter(); err != nil {
+			return err
 		}
+		c.dumpPlan(q)
 
-		startTime := time.Now()
-		affected, err := c.db.Exec(q)
+		if err := c.beginBatchChunk(); err != nil {
+			return err
+		}
+
+		// The next boundary only depends on this chunk's end, so it can be
+		// read while this chunk runs on the writer
+		if c.Config.BoundaryPrefetch && dense == nil && !single && !planned {
+			prefetched = c.prefetchBoundary(limit, chunkIndex+1)
+		}
+
+		c.setStatus("running chunk %d (%d%%), %d rows affected so far", chunkIndex, progress, totalAffected)
+		startTime := c.now()
+		var affected int64
+		if c.Config.DryRun {
+			c.printDryRun(c.annotate(q, chunkIndex), snapshot)
+		} else {
+			affected, err = c.execChunk(c.annotate(q, chunkIndex), chunkIndex, snapshot)
+		}
+		prefetched.wait()
 		if err != nil {
+			c.batch.failed = true
+			report.Elapsed = c.now().Sub(startTime)
+			c.report().ChunkFailed(report, err)
 			return err
 		}
+
+		// A chunk can only reach chunk-size rows (times the merge factor)
+		// through its key range; far more means the statement's predicate
+		// escapes GO_CHUNK, e.g. an OR without parentheses.
+		runaway := c.Config.MaxAffectedPerChunk > 0 && affected > c.Config.MaxAffectedPerChunk
+		if runaway && c.batching() {
+			// Within a batch the chunk is not committed yet, so a runaway
+			// chunk can still be rolled back
+			c.batch.failed = true
+			err := fmt.Errorf("chunk %d affected %d rows, more than --max-affected-per-chunk %d; aborting", chunkIndex, affected, c.Config.MaxAffectedPerChunk)
+			report.Affected = affected
+			report.Elapsed = c.now().Sub(startTime)
+			c.report().ChunkFailed(report, err)
+			return err
+		}
+
 		totalAffected += affected
+		keysDone += limit
+		summary.Chunks++
+		summary.RowsAffected = totalAffected
 
-		elapsed := time.Since(startTime)
+		elapsed := c.now().Sub(startTime)
 		totalElapsed += elapsed
-		if c.Config.Verbose {
-			fmt.Printf("-- + Rows: %d affected, %d accumulating; seconds: %.1f elapsed; %.1f executed\n", affected, totalAffected, elapsed.Seconds(), totalElapsed.Seconds())
+		summary.chunkTook(elapsed)
+		report.Affected = affected
+		report.TotalAffected = totalAffected
+		report.Elapsed = elapsed
+		report.TotalElapsed = totalElapsed
+
+		end := make([]interface{}, c.Config.CountColumnsInUniqueKey)
+		for i := range end {
+			end[i] = snapshot[fmt.Sprintf("unique_key_range_end_%d", i)]
+		}
+		prevEnd = end
+		if c.batching() {
+			// The chunk is reported done once its batch commits
+			if err := c.addToBatch(end, report); err != nil {
+				return err
+			}
+		} else if !c.Config.DryRun {
+			c.committed(end)
+			if err := c.markManifest(end); err != nil {
+				return err
+			}
+			if c.Config.CheckpointFile != "" {
+				// The chunk is committed (autocommit); only now may it be checkpointed.
+				// A crash between the commit and this write re-applies this one chunk.
+				if err := c.saveCheckpoint(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !c.batching() {
+			c.report().ChunkDone(report)
+		}
+		if runaway {
+			// Committed and counted, the chunk is reported done first
+			return fmt.Errorf("chunk %d affected %d rows, more than --max-affected-per-chunk %d; aborting, this chunk is already committed", chunkIndex, affected, c.Config.MaxAffectedPerChunk)
+		}
+
+		if single {
+			summary.Reason = ReasonCompleted
+			break
+		}
+		if c.Config.TerminateOnNotFound && affected == 0 && !c.Config.DryRun {
+			summary.Reason = ReasonNotFound
+			break
+		}
+		if c.Config.MaxChunks > 0 && summary.Chunks >= c.Config.MaxChunks {
+			summary.Reason = ReasonMaxChunks
+			break
+		}
+		if c.Config.MaxRuntime > 0 && time.Since(start) >= c.Config.MaxRuntime {
+			summary.Reason = ReasonMaxRuntime
+			break
+		}
+		if c.stopRequested() {
+			summary.Reason = ReasonInterrupted
+			break
+		}
+
+		if dense != nil && dense.verify && affected < dense.span() && !c.Config.DryRun {
+			// Fewer rows than keys: either the statement skipped some, or the
+			// key has gaps and arithmetic chunks would run ever emptier
+			gaps, err := c.hasGaps(dense, lowOp)
+			if err != nil {
+				return err
+			}
+			if gaps {
+				c.Verbose(fmt.Sprintf("Key has gaps in chunk %d, querying chunk boundaries from now on", chunkIndex))
+				dense = nil
+			}
+		}
+
+		if c.Config.MinAffectedPerChunk > 0 && !c.Config.DryRun {
+			newFactor := nextMergeFactor(mergeFactor, affected, c.Config.MinAffectedPerChunk)
+			if newFactor != mergeFactor {
+				c.Verbose(fmt.Sprintf("Merging %d key ranges per chunk (%d rows scanned)", newFactor, chunkSize*newFactor))
+			}
+			mergeFactor = newFactor
+		}
+
+		if c.Config.MaxChunkTime > 0 && !planned && !c.Config.DryRun {
+			newSize := nextChunkSize(chunkSize, c.Config.ChunkSize, elapsed, c.Config.MaxChunkTime)
+			if newSize != chunkSize {
+				c.Verbose(fmt.Sprintf("Chunk %d took %.2f seconds against --max-chunk-time %v; chunk size now %d", chunkIndex, elapsed.Seconds(), c.Config.MaxChunkTime, newSize))
+			}
+			chunkSize = newSize
 		}
 
 		// Sleep if needed
-		if c.Config.SleepMillis > 0 {
-			time.Sleep(time.Duration(c.Config.SleepMillis) * time.Millisecond)
+		c.setStatus("pausing after chunk %d, %d rows affected so far", chunkIndex, totalAffected)
+		c.applyThrottle()
+		if pause := c.pauseAfterChunk(elapsed); pause > 0 && !c.Config.DryRun {
+			c.sleep(pause)
+		}
+		c.waitForReplicas()
+		if err := c.yieldToLockWaiters(); err != nil {
+			return err
 		}
 
 		// Update range start
-		_, err = c.db.Exec("SELECT @unique_key_range_end_0 INTO @unique_key_range_start_0")
+		_, err = c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", c.getUniqueKeyRangeEndVariables(), c.getUniqueKeyRangeStartVariables()))
 		if err != nil {
 			return err
 		}
@@ -465,9 +1339,47 @@ func (c *Chunker) ChunkUpdate(executeQuery string) error {
 		firstRound = false
 	}
 
+	if err := c.endBatch(nil, summary); err != nil {
+		return err
+	}
+
+	if summary.Partial() {
+		// The checkpoint, if any, stays in place so the next run picks up here
+		c.Verbose(fmt.Sprintf("Stopped early after %d chunks: %s", summary.Chunks, summary.Reason))
+		return nil
+	}
+
+	// A dry run leaves the checkpoint for the real run to resume from
+	if c.Config.CheckpointFile != "" && !c.Config.DryRun {
+		if err := os.Remove(c.Config.CheckpointFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
 	if c.Config.Verbose {
 		fmt.Printf("-- Performing chunks range complete. Affected rows: %d\n", totalAffected)
-		fmt.Printf("-- Chunk update completed\n")
 	}
+
+	if stragglers != "" {
+		n, err := c.countStragglers(stragglers)
+		if err != nil {
+			return err
+		}
+		summary.Stragglers = n
+		if n > 0 {
+			c.Warn(fmt.Sprintf("%d rows matching the DELETE remain between the range minimum and maximum, e.g. inserted during the run", n))
+		} else {
+			c.Verbose("Verified no rows matching the DELETE remain in the range")
+		}
+	}
+
+	if c.Config.AnalyzeAfter {
+		c.Verbose(fmt.Sprintf("Analyzing table %s.%s", c.Config.Database, c.Config.Table))
+		if err := c.db.AnalyzeTable(c.Config.Database, c.Config.Table); err != nil {
+			return err
+		}
+	}
+
+	c.Verbose("Chunk update completed")
 	return nil
 }
@@ -13,22 +13,39 @@ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
 package chunk
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 // MockDB implements a minimal DB interface for testing
 type MockDB struct {
 	uniqueKeyColumns []map[string]interface{}
+	analyzed         []string
+	version          string
+	grants           []string
+	tableMissing     bool
+	locks            int
+	unlocks          int
 }
 
-func (m *MockDB) Exec(query string, args ...interface{}) (int64, error) {
+func (m *MockDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
 	return 0, nil
 }
 
-func (m *MockDB) QueryRow(query string, args ...interface{}) (map[string]interface{}, error) {
+func (m *MockDB) QueryRow(ctx context.Context, query string, args ...interface{}) (map[string]interface{}, error) {
 	// Mock responses based on query
 	if strings.Contains(query, "range_exists") {
 		return map[string]interface{}{"range_exists": int64(1)}, nil
@@ -43,14 +60,29 @@ func (m *MockDB) QueryRow(query string, args ...interface{}) (map[string]interfa
 }
 
 func (m *MockDB) TableExists(db, table string) (bool, error) {
-	return true, nil
+	return !m.tableMissing, nil
+}
+
+func (m *MockDB) ServerVersion() (string, error) {
+	return m.version, nil
+}
+
+func (m *MockDB) CurrentGrants() ([]string, error) {
+	return m.grants, nil
 }
 
 func (m *MockDB) LockTableRead(db, table string) error {
+	m.locks++
 	return nil
 }
 
 func (m *MockDB) UnlockTables() error {
+	m.unlocks++
+	return nil
+}
+
+func (m *MockDB) AnalyzeTable(db, table string) error {
+	m.analyzed = append(m.analyzed, db+"."+table)
 	return nil
 }
 
@@ -102,6 +134,79 @@ func TestGetSelectedUniqueKeyColumnNamesForced(t *testing.T) {
 	}
 }
 
+// columnDB lists the table's columns, as a schema of one environment.
+type columnDB struct {
+	MockDB
+	columns []string
+}
+
+func (d *columnDB) TableColumns(database, table string) ([]string, error) {
+	return d.columns, nil
+}
+
+func TestColumnMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		forced   string
+		columns  []string
+		expected string
+		keyType  string
+		err      bool
+	}{
+		{"renamed column", "legacy_id:integer", []string{"id", "name"}, "`id`", "integer", false},
+		{"old schema keeps its name", "legacy_id:integer", []string{"legacy_id", "name"}, "legacy_id", "integer", false},
+		{"one of several columns", "tenant,legacy_id", []string{"tenant", "id"}, "`tenant`,`id`", "", false},
+		{"neither column", "legacy_id", []string{"uuid"}, "", "", true},
+		{"unmapped column", "uuid", []string{"uuid"}, "uuid", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunker := NewChunker(&columnDB{columns: tt.columns}, Config{
+				Database:             "test",
+				Table:                "t",
+				ForcedChunkingColumn: tt.forced,
+				ColumnMap:            "legacy_id=id, old_name=name",
+			})
+			columns, count, keyType, err := chunker.GetSelectedUniqueKeyColumnNames()
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Expected an error, got key (%s)", columns)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if columns != tt.expected || keyType != tt.keyType || count != len(SplitColumnNames(tt.expected)) {
+				t.Errorf("Expected (%s) of type %q, got (%s) of type %q with %d columns", tt.expected, tt.keyType, columns, keyType, count)
+			}
+
+			chunker.Config.UniqueKeyColumnNames = columns
+			chunker.Config.UniqueKeyColumnNamesList = SplitColumnNames(columns)
+			chunker.Config.CountColumnsInUniqueKey = count
+			query := chunker.replaceChunkPlaceholder("UPDATE t SET x=1 WHERE GO_CHUNK(t)", func(cols string) string { return chunker.rangePredicate(cols, ">=") })
+			if !strings.Contains(query, tt.expected+" >= ") && !strings.Contains(query, "("+tt.expected+") >= ") {
+				t.Errorf("Expected the statement to chunk by (%s), got %s", tt.expected, query)
+			}
+		})
+	}
+}
+
+func TestParseColumnMap(t *testing.T) {
+	mapping, err := ParseColumnMap("Legacy_ID=id, `old`=`new`")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mapping) != 2 || mapping["legacy_id"] != "id" || mapping["old"] != "new" {
+		t.Errorf("Unexpected mapping %v", mapping)
+	}
+	for _, spec := range []string{"id", "a=", "=b", "a=b,a=c", ""} {
+		if _, err := ParseColumnMap(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
 func TestGetUniqueKeyMinValuesVariables(t *testing.T) {
 	chunker := &Chunker{Config: Config{CountColumnsInUniqueKey: 3}}
 	result := chunker.getUniqueKeyMinValuesVariables()
@@ -241,6 +346,36 @@ func TestUniqueKeySelection(t *testing.T) {
 			expectedCount:   1,
 			expectedType:    "text",
 		},
+		{
+			name:         "auto-detect float",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "reading",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"DATA_TYPE":             "double",
+					"CHARACTER_SET_NAME":    nil,
+				},
+			},
+			expectedColumns: "reading",
+			expectedCount:   1,
+			expectedType:    "float",
+		},
+		{
+			name:         "auto-detect decimal",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "amount",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"DATA_TYPE":             "decimal",
+					"CHARACTER_SET_NAME":    nil,
+				},
+			},
+			expectedColumns: "amount",
+			expectedCount:   1,
+			expectedType:    "decimal",
+		},
 		{
 			name:         "auto-detect temporal",
 			forcedColumn: "",
@@ -256,6 +391,68 @@ func TestUniqueKeySelection(t *testing.T) {
 			expectedCount:   1,
 			expectedType:    "temporal",
 		},
+		{
+			name:         "auto-detect binary UUID",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "uuid",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"DATA_TYPE":             "binary",
+					"CHARACTER_SET_NAME":    nil,
+				},
+			},
+			expectedColumns: "uuid",
+			expectedCount:   1,
+			expectedType:    "binary",
+		},
+		{
+			name:         "auto-detect varbinary",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "token",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"DATA_TYPE":             "varbinary",
+					"CHARACTER_SET_NAME":    nil,
+				},
+			},
+			expectedColumns: "token",
+			expectedCount:   1,
+			expectedType:    "binary",
+		},
+		{
+			// A textual UUID sorts by its collation like any string
+			name:         "auto-detect char(36) UUID as text",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "uuid",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"DATA_TYPE":             "char",
+					"CHARACTER_SET_NAME":    "ascii",
+				},
+			},
+			expectedColumns: "uuid",
+			expectedCount:   1,
+			expectedType:    "text",
+		},
+		{
+			name:         "auto-detect prefix-indexed text",
+			forcedColumn: "",
+			mockResponse: []map[string]interface{}{
+				{
+					"COLUMN_NAMES":          "`url`",
+					"COUNT_COLUMN_IN_INDEX": int64(1),
+					"PREFIX_COLUMNS":        "url(100)",
+					"DATA_TYPE":             "varchar",
+					"CHARACTER_SET_NAME":    "utf8mb4",
+				},
+			},
+			expectedColumns: "`url`",
+			expectedCount:   1,
+			expectedType:    "text",
+		},
 	}
 
 	for _, tt := range tests {
@@ -295,6 +492,105 @@ func TestUniqueKeySelection(t *testing.T) {
 	}
 }
 
+func TestUniqueKeyWithNullableColumn(t *testing.T) {
+	// (tenant_id, region, id) with region NULLable: a row with a NULL region
+	// compares as neither before nor after any boundary tuple
+	nullable := map[string]interface{}{
+		"INDEX_NAME":            "uk_tenant_region",
+		"COLUMN_NAMES":          "`tenant_id`,`region`,`id`",
+		"COUNT_COLUMN_IN_INDEX": int64(3),
+		"NULLABLE_COLUMNS":      "region",
+		"DATA_TYPE":             "int",
+		"CHARACTER_SET_NAME":    nil,
+	}
+	notNull := map[string]interface{}{
+		"INDEX_NAME":            "uk_tenant_seq",
+		"COLUMN_NAMES":          "`tenant_id`,`seq`",
+		"COUNT_COLUMN_IN_INDEX": int64(2),
+		"DATA_TYPE":             "int",
+		"CHARACTER_SET_NAME":    nil,
+	}
+
+	chunker := NewChunker(&MockDB{uniqueKeyColumns: []map[string]interface{}{nullable, notNull}}, Config{Database: "test", Table: "t"})
+	columns, count, _, err := chunker.GetSelectedUniqueKeyColumnNames()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if columns != "`tenant_id`,`seq`" || count != 2 {
+		t.Errorf("Expected the NOT NULL key (tenant_id,seq), got (%s)", columns)
+	}
+
+	chunker = NewChunker(&MockDB{uniqueKeyColumns: []map[string]interface{}{nullable}}, Config{Database: "test", Table: "t"})
+	_, _, _, err = chunker.GetSelectedUniqueKeyColumnNames()
+	if err == nil || !strings.Contains(err.Error(), "NULLable region") || !strings.Contains(err.Error(), "--force-chunking-column") {
+		t.Errorf("Expected a key with a NULLable column to be refused, got %v", err)
+	}
+}
+
+func TestSplitColumnNames(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{"id", []string{"id"}},
+		{"col1,col2", []string{"col1", "col2"}},
+		{"`id`", []string{"id"}},
+		{"`a`,`b,c`,`d``e`", []string{"a", "b,c", "d`e"}},
+	}
+	for _, tt := range tests {
+		got := SplitColumnNames(tt.list)
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("SplitColumnNames(%s) = %q, expected %q", tt.list, got, tt.expected)
+		}
+	}
+
+	if got := QuoteIdentifier("d`e"); got != "`d``e`" {
+		t.Errorf("Expected `d``e`, got %s", got)
+	}
+}
+
+func TestUniqueKeySelectionLongCompositeKey(t *testing.T) {
+	quoted := make([]string, 16)
+	for i := range quoted {
+		quoted[i] = QuoteIdentifier(fmt.Sprintf("a_rather_long_column_name_number_%02d", i))
+	}
+	quoted[3] = QuoteIdentifier("tricky,name`with`quotes")
+	columnNames := strings.Join(quoted, ",")
+
+	chunker := &Chunker{db: &MockDB{uniqueKeyColumns: []map[string]interface{}{
+		{
+			"COLUMN_NAMES":          columnNames,
+			"COUNT_COLUMN_IN_INDEX": int64(16),
+			"DATA_TYPE":             "int",
+			"CHARACTER_SET_NAME":    nil,
+		},
+	}}}
+	result, count, _, err := chunker.GetSelectedUniqueKeyColumnNames()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 16 || result != columnNames {
+		t.Errorf("Expected %d columns %s, got %d %s", 16, columnNames, count, result)
+	}
+	names := SplitColumnNames(result)
+	if len(names) != 16 || names[3] != "tricky,name`with`quotes" {
+		t.Errorf("Unexpected split names %q", names)
+	}
+
+	// A list cut short by group_concat_max_len must not be used
+	chunker.db = &MockDB{uniqueKeyColumns: []map[string]interface{}{
+		{
+			"COLUMN_NAMES":          columnNames[:len(columnNames)/2],
+			"COUNT_COLUMN_IN_INDEX": int64(16),
+			"DATA_TYPE":             "int",
+			"CHARACTER_SET_NAME":    nil,
+		},
+	}}
+	if _, _, _, err := chunker.GetSelectedUniqueKeyColumnNames(); err == nil {
+		t.Error("Expected error for truncated column list")
+	}
+}
+
 // Test range specification logic
 func TestRangeSpecifications(t *testing.T) {
 	tests := []struct {
@@ -307,8 +603,9 @@ func TestRangeSpecifications(t *testing.T) {
 		errorMsg    string
 	}{
 		{"valid integer start/end", "1", "100", "integer", 1, false, ""},
-		{"invalid start for multi-column", "1", "", "integer", 2, true, "--start-with only applies to single column integer chunking keys"},
-		{"invalid end for text", "", "abc", "text", 1, true, "--end-with only applies to single column integer chunking keys"},
+		{"short tuple for multi-column", "1", "", "integer", 2, true, "--start-with: key tuple 1 has 1 values, expected 2 for the key's columns"},
+		{"invalid end for binary", "", "abc", "binary", 1, true, "--end-with only applies to integer, decimal, float, temporal, text and composite chunking keys"},
+		{"invalid start for an unknown key type", "1.5", "", "", 1, true, "--start-with only applies to integer, decimal, float, temporal, text and composite chunking keys"},
 		{"valid no range", "", "", "integer", 1, false, ""},
 	}
 
@@ -335,6 +632,289 @@ func TestRangeSpecifications(t *testing.T) {
 	}
 }
 
+func TestDecimalKeyRange(t *testing.T) {
+	newChunker := func(db *simDB) *Chunker {
+		chunker := newSimChunker(db, 10)
+		chunker.Config.UniqueKeyType = "decimal"
+		return chunker
+	}
+
+	// A DECIMAL(20,4) bound with more digits than a float64 holds is written
+	// as an exact literal
+	db := newSimDB(seqKeys(1, 100))
+	chunker := newChunker(db)
+	chunker.Config.StartWith, chunker.Config.EndWith = "1234567890123456.0001", "-0.5"
+	if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT 1234567890123456.0001 INTO @unique_key_min_value_0",
+		"SELECT -0.5 INTO @unique_key_max_value_0",
+	}
+	if !reflect.DeepEqual(db.statements[:2], expected) {
+		t.Errorf("Expected %q, got %q", expected, db.statements)
+	}
+
+	// Any other value is a query for the bound
+	db = newSimDB(seqKeys(1, 100))
+	chunker = newChunker(db)
+	chunker.Config.StartWith = "SELECT MIN(amount) AS start_with FROM t WHERE created > NOW() - INTERVAL 1 DAY"
+	chunker.db = &boundQueryDB{simDB: db, row: map[string]interface{}{"start_with": "17.2500"}}
+	chunker.Config.EndWith = "1e5"
+	_, _, _, err := chunker.GetUniqueKeyRange()
+	if len(db.statements) == 0 || db.statements[0] != "SELECT 17.2500 INTO @unique_key_min_value_0" {
+		t.Errorf("Expected the queried bound as an exact literal, got %q", db.statements)
+	}
+	if err == nil || !strings.Contains(err.Error(), "--end-with query returned") {
+		t.Errorf("Expected 1e5, no exact literal, to be refused, got %v", err)
+	}
+
+	// Restored range variables stay numbers, unlike a text key's digits
+	chunker = newChunker(newSimDB(seqKeys(1, 10)))
+	if got := chunker.keyLiteral("1234567890123456.0001"); got != "1234567890123456.0001" {
+		t.Errorf("Expected an unquoted decimal literal, got %s", got)
+	}
+	chunker.Config.UniqueKeyType = "integer"
+	if got := chunker.keyLiteral("9007199254740993"); got != "9007199254740993" {
+		t.Errorf("Expected an unquoted integer literal, got %s", got)
+	}
+	chunker.Config.UniqueKeyType = "text"
+	if got := chunker.keyLiteral("0042"); got != "'0042'" {
+		t.Errorf("Expected a quoted text literal, got %s", got)
+	}
+
+	if c, ok := compareKey("decimal", "1234567890123456.0001", "1234567890123456.0002"); !ok || c != -1 {
+		t.Errorf("Expected decimals beyond float64 precision to compare exactly, got %d, %v", c, ok)
+	}
+}
+
+func TestFloatKeyRange(t *testing.T) {
+	newChunker := func(db *simDB) *Chunker {
+		chunker := newSimChunker(db, 10)
+		chunker.Config.UniqueKeyType = "float"
+		return chunker
+	}
+
+	// Numbers are parsed with ParseFloat and written as DOUBLE literals that
+	// read back as the same value
+	db := newSimDB(seqKeys(1, 100))
+	chunker := newChunker(db)
+	chunker.Config.StartWith, chunker.Config.EndWith = "0.1", "-2.5E3"
+	if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT 1e-01 INTO @unique_key_min_value_0",
+		"SELECT -2.5e+03 INTO @unique_key_max_value_0",
+	}
+	if !reflect.DeepEqual(db.statements[:2], expected) {
+		t.Errorf("Expected %q, got %q", expected, db.statements)
+	}
+	for _, literal := range []string{"1e-01", "-2.5e+03"} {
+		if f, err := strconv.ParseFloat(literal, 64); err != nil || strconv.FormatFloat(f, 'e', -1, 64) != literal {
+			t.Errorf("Expected %s to round-trip, got %v, %v", literal, f, err)
+		}
+	}
+
+	// A query's DOUBLE or string result is a bound too; anything else is a
+	// query, and NaN is not a bound
+	for _, value := range []interface{}{float64(17.25), "17.25"} {
+		db = newSimDB(seqKeys(1, 100))
+		chunker = newChunker(db)
+		chunker.db = &boundQueryDB{simDB: db, row: map[string]interface{}{"start_with": value}}
+		chunker.Config.StartWith = "SELECT MIN(score) AS start_with FROM t"
+		if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil || len(db.statements) == 0 || db.statements[0] != "SELECT 1.725e+01 INTO @unique_key_min_value_0" {
+			t.Errorf("%T bound: expected the queried bound as a DOUBLE literal, got %q, %v", value, db.statements, err)
+		}
+	}
+	if _, ok := floatBound("NaN"); ok {
+		t.Error("Expected NaN to be refused as a bound")
+	}
+}
+
+func TestTemporalKeyRange(t *testing.T) {
+	db := newSimDB(seqKeys(1, 10))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.UniqueKeyType = "temporal"
+	chunker.Config.StartWith, chunker.Config.EndWith = "2023-01-01 00:00:00", "'2023-06-30'"
+	if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT '2023-01-01 00:00:00' INTO @unique_key_min_value_0",
+		"SELECT '2023-06-30' INTO @unique_key_max_value_0",
+	}
+	if !reflect.DeepEqual(db.statements[:2], expected) {
+		t.Errorf("Expected %q, got %q", expected, db.statements)
+	}
+
+	for _, bad := range []string{"2023-13-01 00:00:00", "2023-01-01 25:00", "20230101"} {
+		db = newSimDB(seqKeys(1, 10))
+		chunker = newSimChunker(db, 10)
+		chunker.Config.UniqueKeyType = "temporal"
+		chunker.Config.StartWith = bad
+		_, _, _, err := chunker.GetUniqueKeyRange()
+		if err == nil || !strings.HasPrefix(err.Error(), "--start-with: ") || !strings.Contains(err.Error(), "is not a date or datetime") {
+			t.Errorf("Expected %q to be refused, got %v", bad, err)
+		}
+		if len(db.statements) != 0 {
+			t.Errorf("Expected nothing assigned for %q, got %q", bad, db.statements)
+		}
+	}
+
+	// A query may return the bound as a time.Time
+	db = newSimDB(seqKeys(1, 10))
+	chunker = newSimChunker(db, 10)
+	chunker.Config.UniqueKeyType = "temporal"
+	chunker.Config.StartWith = "SELECT MIN(created_at) AS start_with FROM t"
+	chunker.db = &boundQueryDB{simDB: db, row: map[string]interface{}{"start_with": time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)}}
+	chunker.Config.EndWith = "2024-01-01"
+	if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil {
+		t.Fatal(err)
+	}
+	if db.statements[0] != "SELECT '2023-03-04 05:06:07' INTO @unique_key_min_value_0" {
+		t.Errorf("Expected the queried bound as a datetime literal, got %q", db.statements)
+	}
+}
+
+func TestTextKeyRange(t *testing.T) {
+	db := newSimDBValues([]interface{}{"a", "m", "z"})
+	chunker := newSimChunker(db, 10)
+	chunker.Config.UniqueKeyType = "text"
+	chunker.Config.StartWith, chunker.Config.EndWith = "O'Brien", `x'; DROP TABLE t; -- \`
+	low, _, _, err := chunker.GetUniqueKeyRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT 'O''Brien' INTO @unique_key_min_value_0",
+		`SELECT 'x''; DROP TABLE t; -- \\' INTO @unique_key_max_value_0`,
+	}
+	if !reflect.DeepEqual(db.statements[:2], expected) {
+		t.Errorf("Expected %q, got %q", expected, db.statements)
+	}
+	if low[0] != "O'Brien" {
+		t.Errorf("Expected the start verbatim, got %q", low[0])
+	}
+}
+
+func TestNullKeyRangeBound(t *testing.T) {
+	// An --end-with query that found no row leaves the maximum NULL
+	db := newSimDB(seqKeys(1, 10))
+	chunker := newSimChunker(db, 3)
+	db.vars["unique_key_max_value_0"] = nil
+	stderr := captureStderr(t, func() {
+		low, high, exists, err := chunker.GetUniqueKeyRange()
+		if err != nil || exists || low != nil || high != nil {
+			t.Errorf("Expected no range, got %v, %v, %v, %v", low, high, exists, err)
+		}
+	})
+	if !strings.Contains(stderr, "key range (1, NULL) has a NULL bound") {
+		t.Errorf("Expected a NULL bound warning, got %q", stderr)
+	}
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Reason != ReasonCompleted || summary.Chunks != 0 || len(db.execs) != 0 {
+		t.Errorf("Expected a completed run with no chunks, got %+v, %q", summary, db.execs)
+	}
+}
+
+func TestFormatRangeValue(t *testing.T) {
+	tests := []struct {
+		keyType  string
+		vals     []interface{}
+		expected string
+	}{
+		{"integer", []interface{}{int64(-42)}, "-42"},
+		{"integer", []interface{}{"9007199254740993"}, "9007199254740993"},
+		{"decimal", []interface{}{"17.2500"}, "17.2500"},
+		{"float", []interface{}{0.5}, "5e-01"},
+		{"text", []interface{}{"O'Brien"}, "'O''Brien'"},
+		{"text", []interface{}{"42"}, "'42'"},
+		{"text", []interface{}{"NULL"}, "'NULL'"},
+		{"text", []interface{}{nil}, "NULL"},
+		{"temporal", []interface{}{"2023-01-01 00:00:00"}, "'2023-01-01 00:00:00'"},
+		{"binary", []interface{}{"\x01\xff"}, "0x01ff"},
+		{"binary", []interface{}{nil}, "NULL"},
+		{"integer", []interface{}{int64(7), "O'Brien", nil}, "(7,'O''Brien',NULL)"},
+	}
+	for _, tt := range tests {
+		chunker := NewChunker(&MockDB{}, Config{UniqueKeyType: tt.keyType, CountColumnsInUniqueKey: len(tt.vals)})
+		if got := chunker.formatRangeValue(tt.vals); got != tt.expected {
+			t.Errorf("%s %q: expected %s, got %s", tt.keyType, tt.vals, tt.expected, got)
+		}
+	}
+}
+
+func TestParseKeyTuple(t *testing.T) {
+	tests := []struct {
+		spec     string
+		n        int
+		expected []string
+		err      string
+	}{
+		{"(100,'abc')", 2, []string{"100", "'abc'"}, ""},
+		{" ( -5 , 'a,b' , 0x0aff ) ", 3, []string{"-5", "'a,b'", "0x0aff"}, ""},
+		{"100,abc", 2, []string{"100", "'abc'"}, ""},
+		{`(1,'O''Brien')`, 2, []string{"1", "'O''Brien'"}, ""},
+		{`(1,'it\'s')`, 2, []string{"1", "'it''s'"}, ""},
+		{"(1,'x'' OR 1=1 -- ')", 2, []string{"1", "'x'' OR 1=1 -- '"}, ""},
+		{"(1,'')", 2, []string{"1", "''"}, ""},
+		{"(12.50,'007')", 2, []string{"12.50", "'007'"}, ""},
+		{"(100)", 2, nil, "key tuple (100) has 1 values, expected 2"},
+		{"(1,'abc)", 2, nil, "unterminated string literal"},
+		{"(1,'a'b)", 2, nil, `unexpected "b" after a quoted value`},
+		{"(1,)", 2, nil, "empty value"},
+	}
+	for _, tt := range tests {
+		got, err := parseKeyTuple(tt.spec, tt.n)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tt.spec, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %q, got %q, %v", tt.spec, tt.expected, got, err)
+		}
+	}
+}
+
+func TestCompositeKeyRange(t *testing.T) {
+	db := newSimDB(seqKeys(1, 10))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.UniqueKeyColumnNames = "id,name"
+	chunker.Config.UniqueKeyColumnNamesList = []string{"id", "name"}
+	chunker.Config.CountColumnsInUniqueKey = 2
+	chunker.Config.StartWith, chunker.Config.EndWith = "(100,'abc')", "(200,'O''Brien')"
+	if _, _, _, err := chunker.GetUniqueKeyRange(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SELECT 100,'abc' INTO @unique_key_min_value_0,@unique_key_min_value_1",
+		"SELECT 200,'O''Brien' INTO @unique_key_max_value_0,@unique_key_max_value_1",
+	}
+	if !reflect.DeepEqual(db.statements[:2], expected) {
+		t.Errorf("Expected %q, got %q", expected, db.statements)
+	}
+}
+
+// boundQueryDB answers a --start-with or --end-with query with row.
+type boundQueryDB struct {
+	*simDB
+	row map[string]interface{}
+}
+
+func (b *boundQueryDB) QueryRow(ctx context.Context, query string, args ...interface{}) (map[string]interface{}, error) {
+	if strings.HasPrefix(query, "SELECT MIN(") {
+		return b.row, nil
+	}
+	return b.simDB.QueryRow(ctx, query, args...)
+}
+
 // Test chunk size validation
 func TestChunkSizeValidation(t *testing.T) {
 	tests := []struct {
@@ -406,3 +986,597 @@ func TestPerformanceOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestNextMergeFactor(t *testing.T) {
+	tests := []struct {
+		current  int
+		affected int64
+		expected int
+	}{
+		{1, 0, 2},
+		{2, 5, 4},
+		{maxMergeFactor, 0, maxMergeFactor},
+		{1, 10, 1},
+		{4, 15, 4},
+		{4, 20, 2},
+		{1, 100, 1},
+	}
+	for _, tt := range tests {
+		if got := nextMergeFactor(tt.current, tt.affected, 10); got != tt.expected {
+			t.Errorf("nextMergeFactor(%d, %d, 10) = %d, expected %d", tt.current, tt.affected, got, tt.expected)
+		}
+	}
+}
+
+func TestChunkUpdateMergesSparseRanges(t *testing.T) {
+	keys := seqKeys(1, 1000)
+	run := func(minAffected int) int {
+		db := newSimDB(keys)
+		// Only the last hundred rows match the UPDATE's extra predicate
+		db.matches = func(key interface{}) bool { return key.(int64) > 900 }
+		chunker := newSimChunker(db, 10)
+		chunker.Config.MinAffectedPerChunk = minAffected
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return len(db.execs)
+	}
+
+	plain := run(0)
+	merged := run(5)
+	if merged >= plain {
+		t.Errorf("Expected merging to reduce statements, got %d merged vs %d plain", merged, plain)
+	}
+}
+
+func TestChunkUpdateMergeGrowsBoundary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 1000))
+	db.matches = func(key interface{}) bool { return false }
+	chunker := newSimChunker(db, 10)
+	chunker.Config.MinAffectedPerChunk = 1
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Boundaries grow 10, 20, 40, ... rows so far fewer statements run than 100
+	if len(db.execs) > 10 {
+		t.Errorf("Expected boundaries to grow, got %d statements", len(db.execs))
+	}
+}
+
+func TestNextChunkSize(t *testing.T) {
+	tests := []struct {
+		current  int
+		elapsed  time.Duration
+		expected int
+	}{
+		{1000, 3 * time.Second, 500},
+		{1, 3 * time.Second, 1},
+		{500, 1500 * time.Millisecond, 500},
+		{500, 500 * time.Millisecond, 1000},
+		{800, 500 * time.Millisecond, 1000},
+		{1000, 0, 1000},
+	}
+	for _, tt := range tests {
+		if got := nextChunkSize(tt.current, 1000, tt.elapsed, 2*time.Second); got != tt.expected {
+			t.Errorf("nextChunkSize(%d, 1000, %v, 2s) = %d, expected %d", tt.current, tt.elapsed, got, tt.expected)
+		}
+	}
+}
+
+// slowDB advances the chunker's clock by slow for each of its first
+// slowChunks chunk statements; the others take no time at all.
+type slowDB struct {
+	*simDB
+	clock      time.Time
+	slow       time.Duration
+	slowChunks int
+	updates    int
+}
+
+func (d *slowDB) now() time.Time {
+	return d.clock
+}
+
+func (d *slowDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") {
+		d.updates++
+		if d.updates <= d.slowChunks {
+			d.clock = d.clock.Add(d.slow)
+		}
+	}
+	return d.simDB.Exec(ctx, query, args...)
+}
+
+func TestMaxChunkTimeAdaptsChunkSize(t *testing.T) {
+	db := &slowDB{simDB: newSimDB(seqKeys(1, 100)), slow: 60 * time.Millisecond, slowChunks: 2}
+	chunker := newSimChunker(db.simDB, 16)
+	chunker.db = db
+	chunker.now = db.now
+	chunker.Config.MaxChunkTime = 40 * time.Millisecond
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Two slow chunks halve the size twice, then fast ones double it back
+	expected := []string{"1-16", "16-24", "24-28", "28-36", "36-52", "52-68", "68-84", "84-100"}
+	if !reflect.DeepEqual(reporter.ranges(), expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, reporter.ranges())
+	}
+	checkTouchedOnce(t, db.simDB)
+}
+
+func TestChunkUpdateProcessesEveryRowOnce(t *testing.T) {
+	for _, chunkSize := range []int{1, 3, 10, 99, 100, 1000} {
+		db := newSimDB(seqKeys(1, 100))
+		chunker := newSimChunker(db, chunkSize)
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, key := range db.keys {
+			if db.touched[key] != 1 {
+				t.Errorf("chunk size %d: key %d processed %d times", chunkSize, key, db.touched[key])
+			}
+		}
+	}
+}
+
+func TestChunkUpdateAnalyzeAfter(t *testing.T) {
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	db := newSimDB(seqKeys(1, 100))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.AnalyzeAfter = true
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(db.analyzed) != 1 || db.analyzed[0] != "test.t" {
+		t.Errorf("Expected one ANALYZE of test.t, got %v", db.analyzed)
+	}
+
+	db = newSimDB(seqKeys(1, 100))
+	db.failAt = 2
+	chunker = newSimChunker(db, 10)
+	chunker.Config.AnalyzeAfter = true
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+	if len(db.analyzed) != 0 {
+		t.Errorf("Expected no ANALYZE after a failed run, got %v", db.analyzed)
+	}
+}
+
+func TestChunkUpdateFloatKeyNearEqualValues(t *testing.T) {
+	// Nearly adjacent FLOAT values whose shortest client representation
+	// ("0.1", ...) parses back below the stored double, so a client-side
+	// boundary would exclude its own row
+	keys := make([]interface{}, 0, 50)
+	for v := float32(0.1); len(keys) < 50; v = math.Nextafter32(v, 1) {
+		parsed, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		if parsed < float64(v) {
+			keys = append(keys, float64(v))
+		}
+	}
+	db := newSimDBValues(keys)
+	db.rowValue = func(key interface{}) interface{} { return float32(key.(float64)) }
+	chunker := newSimChunker(db, 7)
+	chunker.Config.UniqueKeyType = "float"
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %v processed %d times", key, db.touched[key])
+		}
+	}
+	if len(db.execs) != 8 {
+		t.Errorf("Expected 8 chunks, got %d", len(db.execs))
+	}
+}
+
+func TestChunkUpdateTextKeyEndsAtMaximum(t *testing.T) {
+	// Prefixes sort before their extensions, digits sort as text, and a
+	// chunk edge falls on the maximum for some chunk sizes and just before
+	// it for others
+	keys := []interface{}{"", "10", "9", "a", "a ", "aa", "ab", "b", "o'k", "z", "zz"}
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+	for size := 1; size <= len(keys)+1; size++ {
+		db := newSimDBValues(append([]interface{}(nil), keys...))
+		chunker := newSimChunker(db, size)
+		chunker.Config.UniqueKeyType = "text"
+		summary, err := chunker.ChunkUpdate(context.Background(), query)
+		if err != nil {
+			t.Fatalf("chunk size %d: %v", size, err)
+		}
+		if summary.Reason != ReasonCompleted {
+			t.Errorf("chunk size %d: expected completion, got %s", size, summary.Reason)
+		}
+		checkTouchedOnce(t, db)
+		if expected := (len(keys) + size - 1) / size; len(db.execs) != expected {
+			t.Errorf("chunk size %d: expected %d chunks, got %d", size, expected, len(db.execs))
+		}
+		if size == 1 && !slices.Contains(db.statements, "SELECT '9' INTO @unique_key_range_end_0") {
+			t.Errorf("Expected text boundaries as string literals, got %q", db.statements)
+		}
+	}
+
+	// A run resumed at the maximum has nothing left to do
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	db := newSimDBValues(append([]interface{}(nil), keys...))
+	chunker := newSimChunker(db, 3)
+	chunker.Config.UniqueKeyType = "text"
+	chunker.Config.CheckpointFile = path
+	cp := &Checkpoint{Database: "test", Table: "t", Columns: "id", KeyType: "text", Boundary: []string{"'zz'"}}
+	if err := cp.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("Expected no chunk after the maximum, got %v", db.execs)
+	}
+}
+
+func TestChunkUpdateBinaryKey(t *testing.T) {
+	// 16-byte keys like UUID_TO_BIN values, which are neither valid UTF-8
+	// nor free of NUL bytes
+	keys := make([]interface{}, 40)
+	for i := range keys {
+		key := make([]byte, 16)
+		key[0], key[1], key[15] = byte(i*6), 0xff, byte(i)
+		keys[i] = string(key)
+	}
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	db := newSimDBValues(keys)
+	db.failAt = 3
+	chunker := newSimChunker(db, 10)
+	chunker.Config.UniqueKeyType = "binary"
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil || cp == nil {
+		t.Fatalf("Expected checkpoint after failure, got %v, %v", cp, err)
+	}
+	if !strings.HasPrefix(cp.Boundary[0], "0x") {
+		t.Errorf("Expected a hex checkpoint boundary, got %q", cp.Boundary[0])
+	}
+
+	db.failAt = 0
+	chunker = newSimChunker(db, 10)
+	chunker.Config.UniqueKeyType = "binary"
+	chunker.Config.CheckpointFile = path
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %x processed %d times", key, db.touched[key])
+		}
+	}
+	for _, statement := range append(db.execs, db.queries...) {
+		if !utf8.ValidString(statement) {
+			t.Errorf("Statement carries raw binary: %q", statement)
+		}
+	}
+}
+
+func TestWithTableLock(t *testing.T) {
+	failure := errors.New("chunk failed")
+	tests := []struct {
+		name        string
+		keepOnError bool
+		fnErr       error
+		unlocks     int
+	}{
+		{"success unlocks", false, nil, 1},
+		{"error unlocks by default", false, failure, 1},
+		{"error keeps lock when asked", true, failure, 0},
+		{"success unlocks even when keeping on error", true, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &MockDB{}
+			chunker := NewChunker(db, Config{Database: "test", Table: "t", KeepLockOnError: tt.keepOnError})
+			err := chunker.WithTableLock(func() error { return tt.fnErr })
+			if err != tt.fnErr {
+				t.Errorf("Expected error %v, got %v", tt.fnErr, err)
+			}
+			if db.locks != 1 || db.unlocks != tt.unlocks {
+				t.Errorf("Expected 1 lock and %d unlocks, got %d and %d", tt.unlocks, db.locks, db.unlocks)
+			}
+		})
+	}
+}
+
+func TestChunkUpdateReaderWriterSplit(t *testing.T) {
+	keys := seqKeys(1, 100)
+	reader := newSimDB(keys)
+	writer := newSimDB(keys)
+	chunker := newSimChunker(reader, 10)
+	chunker.db = writer
+	chunker.SetReader(reader)
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(reader.execs) != 0 {
+		t.Errorf("Expected no mutations on the reader, got %v", reader.execs)
+	}
+	if len(writer.execs) != 10 {
+		t.Errorf("Expected 10 mutations on the writer, got %d", len(writer.execs))
+	}
+	for _, query := range writer.queries {
+		if strings.Contains(query, "LIMIT") {
+			t.Errorf("Expected boundary detection on the reader, got %s on the writer", query)
+		}
+	}
+	for _, key := range writer.keys {
+		if writer.touched[key] != 1 {
+			t.Errorf("Key %v processed %d times", key, writer.touched[key])
+		}
+	}
+}
+
+func TestChunkUpdateStatementComment(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.JobID = "nightly"
+	chunker.Config.StatementComment = DefaultStatementComment
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var boundaries []string
+	for _, query := range db.queries {
+		if strings.Contains(query, "LIMIT") {
+			boundaries = append(boundaries, query)
+		}
+	}
+	var updates []string
+	for _, stmt := range db.statements {
+		if strings.Contains(stmt, "UPDATE") {
+			updates = append(updates, stmt)
+		}
+	}
+	if len(boundaries) != 4 || len(updates) != 3 {
+		t.Fatalf("Expected 4 boundary queries and 3 updates, got %d and %d", len(boundaries), len(updates))
+	}
+	for i, query := range updates {
+		prefix := fmt.Sprintf("/* go-chunk-update job=nightly chunk=%d */ UPDATE", i+1)
+		if !strings.HasPrefix(query, prefix) {
+			t.Errorf("Expected update to start with %q, got %s", prefix, query)
+		}
+		if !strings.HasPrefix(boundaries[i], fmt.Sprintf("/* go-chunk-update job=nightly chunk=%d */ SELECT", i+1)) {
+			t.Errorf("Expected boundary query to be tagged for chunk %d, got %s", i+1, boundaries[i])
+		}
+	}
+}
+
+func TestAnnotateTemplate(t *testing.T) {
+	chunker := NewChunker(nil, Config{Database: "shop", Table: "orders", JobID: "j1", StatementComment: "{job}:{table}:{chunk} */ DROP"})
+	got := chunker.annotate("SELECT 1", 7)
+	if got != "/* j1:shop.orders:7 * / DROP */ SELECT 1" {
+		t.Errorf("Unexpected annotated query %s", got)
+	}
+
+	chunker.Config.JobID = "j1*/ DROP TABLE orders; /*"
+	chunker.Config.Table = "x*"
+	chunker.Config.StatementComment = "{job} {table}/"
+	if got := chunker.annotate("SELECT 1", 7); got != "/* j1* / DROP TABLE orders; /* shop.x* / */ SELECT 1" {
+		t.Errorf("Expected */ in substituted values broken up, got %s", got)
+	}
+
+	chunker.Config.StatementComment = ""
+	if got := chunker.annotate("SELECT 1", 7); got != "SELECT 1" {
+		t.Errorf("Expected no comment when disabled, got %s", got)
+	}
+}
+
+// captureStderr returns what fn writes to os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = orig }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPrefixIndexedKeyWarns(t *testing.T) {
+	row := map[string]interface{}{
+		"COLUMN_NAMES":          "`site_id`,`url`",
+		"COUNT_COLUMN_IN_INDEX": int64(2),
+		"PREFIX_COLUMNS":        "url(100)",
+		"DATA_TYPE":             "int",
+		"CHARACTER_SET_NAME":    nil,
+	}
+	chunker := NewChunker(&MockDB{uniqueKeyColumns: []map[string]interface{}{row}}, Config{})
+	var columns string
+	stderr := captureStderr(t, func() {
+		var err error
+		columns, _, _, err = chunker.GetSelectedUniqueKeyColumnNames()
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+	if columns != "`site_id`,`url`" {
+		t.Errorf("Expected the prefix-indexed key to be chunked on its full columns, got %s", columns)
+	}
+	if !strings.Contains(stderr, "indexes only a prefix of url(100)") {
+		t.Errorf("Expected prefix warning, got %q", stderr)
+	}
+
+	delete(row, "PREFIX_COLUMNS")
+	stderr = captureStderr(t, func() {
+		if _, _, _, err := chunker.GetSelectedUniqueKeyColumnNames(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+	if stderr != "" {
+		t.Errorf("Expected no warning for a full-column key, got %q", stderr)
+	}
+}
+
+func TestUniqueKeyColumnCasePreserved(t *testing.T) {
+	chunker := NewChunker(&MockDB{uniqueKeyColumns: []map[string]interface{}{
+		{
+			"COLUMN_NAMES":          "TenantID,OrderNo",
+			"COUNT_COLUMN_IN_INDEX": int64(2),
+			"DATA_TYPE":             "INT",
+			"CHARACTER_SET_NAME":    nil,
+		},
+	}}, Config{Database: "test", Table: "Orders"})
+	columns, count, keyType, err := chunker.GetSelectedUniqueKeyColumnNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns != "TenantID,OrderNo" || count != 2 || keyType != "integer" {
+		t.Errorf("Expected TenantID,OrderNo as a 2-column integer key, got %s, %d, %s", columns, count, keyType)
+	}
+
+	chunker.Config.UniqueKeyColumnNames = columns
+	chunker.Config.CountColumnsInUniqueKey = count
+	chunker.Config.UniqueKeyType = keyType
+	chunker.Config.UniqueKeyColumnNamesList = SplitColumnNames(columns)
+	query := chunker.replaceChunkPlaceholder("DELETE FROM Orders WHERE GO_CHUNK(Orders)", func(cols string) string { return chunker.rangePredicate(cols, ">") })
+	expected := "DELETE FROM Orders WHERE (TenantID,OrderNo) > (@unique_key_range_start_0,@unique_key_range_start_1) AND (TenantID,OrderNo) <= (@unique_key_range_end_0,@unique_key_range_end_1)"
+	if query != expected {
+		t.Errorf("Expected predicate on the real column case:\n%s\ngot:\n%s", expected, query)
+	}
+
+	// A checkpoint written with the names lowercased still resumes
+	cp := Checkpoint{Database: "test", Table: "Orders", Columns: "tenantid,orderno", KeyType: "integer", Boundary: []string{"1", "2"}}
+	if err := cp.Validate(chunker.Config); err != nil {
+		t.Errorf("Expected a lowercased checkpoint to validate, got %v", err)
+	}
+}
+
+func TestReplaceChunkPlaceholder(t *testing.T) {
+	chunker := NewChunker(nil, Config{Database: "archive", Table: "events", UniqueKeyColumnNames: "id", UniqueKeyColumnNamesList: []string{"id"}})
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"DELETE FROM events WHERE GO_CHUNK(events)", "DELETE FROM events WHERE id < 5"},
+		{"UPDATE archive.events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE GO_CHUNK(archive.events)", "UPDATE archive.events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE id < 5"},
+		{"UPDATE archive.events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE GO_CHUNK(archive.events AS e)", "UPDATE archive.events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE e.`id` < 5"},
+		{"UPDATE events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE GO_CHUNK(events, e)", "UPDATE events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE e.`id` < 5"},
+		{"DELETE FROM events WHERE GO_CHUNK( events as `ev` )", "DELETE FROM events WHERE `ev`.`id` < 5"},
+		{"DELETE FROM users WHERE GO_CHUNK(users)", "DELETE FROM users WHERE GO_CHUNK(users)"},
+	}
+	for _, tt := range tests {
+		if got := chunker.replaceChunkPlaceholder(tt.query, func(cols string) string { return cols + " < 5" }); got != tt.expected {
+			t.Errorf("replaceChunkPlaceholder(%q) = %q, want %q", tt.query, got, tt.expected)
+		}
+	}
+}
+
+func TestReplaceChunkPlaceholderQuotesAliasedKey(t *testing.T) {
+	chunker := NewChunker(nil, Config{Table: "events", UniqueKeyColumnNames: "`Order`,`odd``name`", UniqueKeyColumnNamesList: SplitColumnNames("`Order`,`odd``name`")})
+	query := chunker.replaceChunkPlaceholder("UPDATE events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE GO_CHUNK(events AS e)", func(cols string) string { return "(" + cols + ") < (1,2)" })
+	expected := "UPDATE events e JOIN users u ON u.id = e.user_id SET e.x=1 WHERE (e.`Order`,e.`odd``name`) < (1,2)"
+	if query != expected {
+		t.Errorf("Expected quoted aliased key columns:\n%s\ngot:\n%s", expected, query)
+	}
+}
+
+func TestParseChunkToken(t *testing.T) {
+	tests := []struct {
+		query, table, alias string
+	}{
+		{"DELETE FROM events WHERE GO_CHUNK(events)", "events", ""},
+		{"UPDATE app.events e JOIN users u ON u.id = e.uid SET e.x=1 WHERE GO_CHUNK(app.events AS e)", "app.events", "e"},
+		{"UPDATE events e JOIN users u ON u.id = e.uid SET e.x=1 WHERE GO_CHUNK(events, e)", "events", "e"},
+	}
+	for _, tt := range tests {
+		table, alias, ok := ParseChunkToken(tt.query)
+		if !ok || table != tt.table || alias != tt.alias {
+			t.Errorf("ParseChunkToken(%q) = %q, %q, %v; want %q, %q", tt.query, table, alias, ok, tt.table, tt.alias)
+		}
+	}
+	if _, _, ok := ParseChunkToken("UPDATE users SET col=val WHERE GO_CHUNK()"); ok {
+		t.Error("Expected an empty GO_CHUNK token to be rejected")
+	}
+}
+
+func TestAliasedChunkInMultiTableUpdate(t *testing.T) {
+	db := newSimDB(seqKeys(1, 25))
+	chunker := newSimChunker(db, 10)
+	chunker.SetReporter(&recordingReporter{})
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t AS e JOIN users u ON u.id = e.owner SET e.x=1 WHERE GO_CHUNK(t AS e) AND u.active"); err != nil {
+		t.Fatal(err)
+	}
+	checkTouchedOnce(t, db)
+	for _, stmt := range db.execs {
+		if !strings.Contains(stmt, "WHERE e.`id` > @unique_key_range_start_0 AND e.`id` <= @unique_key_range_end_0 AND u.active") &&
+			!strings.Contains(stmt, "WHERE e.`id` >= @unique_key_range_start_0 AND e.`id` <= @unique_key_range_end_0 AND u.active") {
+			t.Errorf("Expected the key qualified with the alias, got %s", stmt)
+		}
+	}
+}
+
+// runawayDB reports a near-full-table update for its n-th chunk, as a
+// predicate that escapes GO_CHUNK would.
+type runawayDB struct {
+	*simDB
+	n int
+}
+
+func (r *runawayDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	affected, err := r.simDB.Exec(ctx, query, args...)
+	if err == nil && strings.Contains(query, "UPDATE") && len(r.execs) == r.n {
+		return 5000000, nil
+	}
+	return affected, err
+}
+
+func TestMaxAffectedPerChunkAborts(t *testing.T) {
+	sim := newSimDB(seqKeys(1, 50))
+	db := &runawayDB{simDB: sim, n: 2}
+	chunker := NewChunker(db, newSimChunker(sim, 10).Config)
+	chunker.Config.MaxAffectedPerChunk = 1000
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t) OR x IS NULL")
+	if err == nil || !strings.Contains(err.Error(), "chunk 2 affected 5000000 rows") {
+		t.Fatalf("Expected abort on chunk 2, got %v", err)
+	}
+	// The runaway chunk is committed, so it is reported done and counted
+	var reported int64
+	for _, r := range reporter.done {
+		reported += r.Affected
+	}
+	if len(reporter.done) != 2 || len(reporter.failed) != 0 || reported != summary.RowsAffected || summary.Chunks != 2 {
+		t.Errorf("Expected both chunks reported done as in the summary, got %d done, %d failed, %d rows against %+v", len(reporter.done), len(reporter.failed), reported, summary)
+	}
+	if len(sim.execs) != 2 {
+		t.Errorf("Expected no chunks after the runaway one, got %d", len(sim.execs))
+	}
+	if summary.Reason != ReasonError {
+		t.Errorf("Expected reason error, got %s", summary.Reason)
+	}
+
+	// Within the bound the run completes
+	sim = newSimDB(seqKeys(1, 50))
+	chunker = newSimChunker(sim, 10)
+	chunker.Config.MaxAffectedPerChunk = 10
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
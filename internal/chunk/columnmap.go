@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnLister is implemented by connections that can list a table's
+// columns.
+type ColumnLister interface {
+	TableColumns(database, table string) ([]string, error)
+}
+
+// ParseColumnMap parses a --column-map spec, a comma-separated list of
+// old=new column renames, into a map keyed by the lowercased old name.
+func ParseColumnMap(spec string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, part := range strings.Split(spec, ",") {
+		old, renamed, ok := strings.Cut(part, "=")
+		old = strings.Trim(strings.TrimSpace(old), "`")
+		renamed = strings.Trim(strings.TrimSpace(renamed), "`")
+		if !ok || old == "" || renamed == "" {
+			return nil, fmt.Errorf("invalid --column-map entry %q, expected old=new", strings.TrimSpace(part))
+		}
+		if _, dup := mapping[strings.ToLower(old)]; dup {
+			return nil, fmt.Errorf("--column-map maps %s more than once", old)
+		}
+		mapping[strings.ToLower(old)] = renamed
+	}
+	return mapping, nil
+}
+
+// mapKeyColumns applies ColumnMap to the key column list, so that one
+// command chunks by a column renamed between environments. A column is only
+// renamed where the table lacks it, so the map is harmless on a schema that
+// still has the old name; a connection that cannot list the table's columns
+// renames it unconditionally. The list is returned as is when nothing maps.
+func (c *Chunker) mapKeyColumns(list string) (string, error) {
+	if c.Config.ColumnMap == "" {
+		return list, nil
+	}
+	mapping, err := ParseColumnMap(c.Config.ColumnMap)
+	if err != nil {
+		return "", err
+	}
+	var existing map[string]bool
+	if lister, ok := c.db.(ColumnLister); ok {
+		columns, err := lister.TableColumns(c.Config.Database, c.Config.Table)
+		if err != nil {
+			return "", err
+		}
+		existing = make(map[string]bool, len(columns))
+		for _, col := range columns {
+			existing[strings.ToLower(col)] = true
+		}
+	}
+
+	names := SplitColumnNames(list)
+	mapped := false
+	for i, name := range names {
+		renamed, ok := mapping[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if existing != nil {
+			if existing[strings.ToLower(name)] {
+				continue
+			}
+			if !existing[strings.ToLower(renamed)] {
+				return "", fmt.Errorf("key column %s is not in %s.%s, nor is %s, which --column-map renames it to", name, c.Config.Database, c.Config.Table, renamed)
+			}
+		}
+		c.Verbose(fmt.Sprintf("Chunking by column %s, mapped from %s", renamed, name))
+		names[i] = renamed
+		mapped = true
+	}
+	if !mapped {
+		return list, nil
+	}
+	for i, name := range names {
+		names[i] = QuoteIdentifier(name)
+	}
+	return strings.Join(names, ","), nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// confirmer asks the operator before each chunk (--confirm-each-chunk).
+type confirmer struct {
+	in  *bufio.Reader
+	out io.Writer
+	all bool
+}
+
+// SetConfirmInput sets where --confirm-each-chunk reads answers from and
+// writes its prompts to.
+func (c *Chunker) SetConfirmInput(in io.Reader, out io.Writer) {
+	c.confirmer = &confirmer{in: bufio.NewReader(in), out: out}
+}
+
+// confirm shows the statement and boundary of the next chunk and reports
+// whether to execute it. "all" executes this and every later chunk without
+// asking; "no", or the end of input, declines.
+func (p *confirmer) confirm(statement string, r ChunkReport) (bool, error) {
+	if p.all {
+		return true, nil
+	}
+	fmt.Fprintf(p.out, "-- Chunk %d: range %v, %v\n%s\n", r.Index, r.Start, r.End, statement)
+	for {
+		fmt.Fprint(p.out, "Execute this chunk? [y]es/[a]ll/[n]o: ")
+		line, err := p.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		case "a", "all":
+			p.all = true
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		if err == io.EOF {
+			fmt.Fprintln(p.out)
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConfirmEachChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		chunks  int
+		prompts int
+		reason  StopReason
+	}{
+		{"yes to each", "y\nyes\nY\ny\ny\n", 5, 5, ReasonCompleted},
+		{"all stops prompting", "y\nall\n", 5, 2, ReasonCompleted},
+		{"no declines", "y\nn\n", 1, 2, ReasonDeclined},
+		{"unknown answer asks again", "maybe\ny\nno\n", 1, 2, ReasonDeclined},
+		{"end of input declines", "y\n", 1, 2, ReasonDeclined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSimDB(seqKeys(1, 50))
+			chunker := newSimChunker(db, 10)
+			chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+			chunker.Config.ConfirmEachChunk = true
+			var out bytes.Buffer
+			chunker.SetConfirmInput(strings.NewReader(tt.input), &out)
+
+			summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(db.execs) != tt.chunks || summary.Chunks != tt.chunks {
+				t.Errorf("Expected %d chunks executed, got %d", tt.chunks, len(db.execs))
+			}
+			if summary.Reason != tt.reason {
+				t.Errorf("Expected reason %s, got %s", tt.reason, summary.Reason)
+			}
+			if declined := tt.reason == ReasonDeclined; declined != (summary.Skipped == 1) {
+				t.Errorf("Expected a declined chunk to count as skipped, got %d skipped", summary.Skipped)
+			}
+			if got := strings.Count(out.String(), "-- Chunk "); got != tt.prompts {
+				t.Errorf("Expected %d chunk prompts, got %d:\n%s", tt.prompts, got, out.String())
+			}
+		})
+	}
+}
+
+func TestConfirmShowsStatementAndBoundary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	chunker.Config.ConfirmEachChunk = true
+	var out bytes.Buffer
+	chunker.SetConfirmInput(strings.NewReader("n\n"), &out)
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "-- Chunk 1: range 1, 10\nUPDATE t SET x=1 WHERE id >= @unique_key_range_start_0 AND id <= @unique_key_range_end_0\n"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("Unexpected prompt:\n%s", out.String())
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("Expected the declined chunk not to run")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"math/big"
+	"regexp"
+)
+
+// decimalLiteralRe matches an exact numeric literal. An exponent would make
+// it a DOUBLE literal, which the server compares approximately.
+var decimalLiteralRe = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+
+// keyLiteral renders a key value as a SQL literal, like sqlLiteral. The
+// driver returns integer and DECIMAL values as strings, and a quoted one
+// would make the server compare the key column with it as a DOUBLE, so on a
+// single-column integer or decimal key a value is written as the number it is.
+func (c *Chunker) keyLiteral(val interface{}) string {
+	if (c.Config.UniqueKeyType == "integer" || c.Config.UniqueKeyType == "decimal") && c.Config.CountColumnsInUniqueKey == 1 {
+		if s, ok := val.(string); ok && decimalLiteralRe.MatchString(s) {
+			return s
+		}
+	}
+	return sqlLiteral(val)
+}
+
+// decimalValue converts a decimal or integer key value as returned by the
+// driver into an exact number.
+func decimalValue(val interface{}) (*big.Rat, bool) {
+	switch v := val.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case uint64:
+		return new(big.Rat).SetUint64(v), true
+	case []byte:
+		return decimalValue(string(v))
+	case string:
+		if !decimalLiteralRe.MatchString(v) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(v)
+	}
+	return nil, false
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Modes of Config.DenseKeys. "auto" computes chunk boundaries arithmetically
+// until a chunk reveals a gap in the key, "on" throughout the run.
+const (
+	DenseKeysOff  = "off"
+	DenseKeysAuto = "auto"
+	DenseKeysOn   = "on"
+)
+
+// ValidateDenseKeys checks a --dense-key-optimization value.
+func ValidateDenseKeys(mode string) error {
+	switch mode {
+	case "", DenseKeysOff, DenseKeysAuto, DenseKeysOn:
+		return nil
+	}
+	return fmt.Errorf("invalid dense key mode %q, expected off, auto or on", mode)
+}
+
+// denseKey computes chunk boundaries arithmetically on a single-column
+// integer key: when the key has no gaps, the chunk of n keys starting at k
+// ends at k+n-1, with no boundary query needed. Boundaries stay correct on a
+// key with gaps, as every chunk still covers a closed key range; the chunks
+// just hold fewer rows.
+type denseKey struct {
+	// next is the first key of the next chunk
+	next int64
+	max  int64
+	// verify checks chunks that affected fewer rows than their span for
+	// gaps, and gives up on arithmetic boundaries once one is found
+	verify bool
+	// start and end are the range of the current chunk
+	start, end int64
+	done       bool
+}
+
+// startDenseKey returns the arithmetic boundary state for this run, or nil
+// when boundaries are to be queried. inclusive includes the range start
+// itself, as on the first chunk of a fresh run.
+func (c *Chunker) startDenseKey(inclusive bool) (*denseKey, error) {
+	mode := c.Config.DenseKeys
+	if mode == "" || mode == DenseKeysOff {
+		return nil, nil
+	}
+	if c.Config.UniqueKeyType != "integer" || c.Config.CountColumnsInUniqueKey != 1 {
+		if mode == DenseKeysOn {
+			return nil, fmt.Errorf("dense key optimization needs a single-column integer key, not (%s)", c.Config.UniqueKeyColumnNames)
+		}
+		c.Verbose(fmt.Sprintf("Key (%s) is not a single integer column, querying chunk boundaries", c.Config.UniqueKeyColumnNames))
+		return nil, nil
+	}
+	startVal, err := c.getSessionVariableValue("unique_key_range_start_0")
+	if err != nil {
+		return nil, err
+	}
+	maxVal, err := c.getSessionVariableValue("unique_key_max_value_0")
+	if err != nil {
+		return nil, err
+	}
+	start, ok := denseKeyValue(startVal)
+	max, maxOK := denseKeyValue(maxVal)
+	if !ok || !maxOK {
+		if mode == DenseKeysOn {
+			return nil, fmt.Errorf("dense key optimization cannot step from %v to %v", startVal, maxVal)
+		}
+		c.Verbose(fmt.Sprintf("Key range %v to %v does not fit a signed 64-bit integer, querying chunk boundaries", startVal, maxVal))
+		return nil, nil
+	}
+	if !inclusive {
+		start++
+	}
+	c.Verbose("Computing chunk boundaries arithmetically on the dense key")
+	return &denseKey{next: start, max: max, verify: mode == DenseKeysAuto}, nil
+}
+
+// boundary advances to the chunk of limit keys after the previous one and
+// returns its last key, or false when the key range is exhausted.
+func (d *denseKey) boundary(limit int) (int64, bool) {
+	if d.done || d.next > d.max {
+		return 0, false
+	}
+	d.start = d.next
+	d.end = d.max
+	if d.start <= d.max-int64(limit)+1 {
+		d.end = d.start + int64(limit) - 1
+	}
+	// The last key may be the largest int64, past which next cannot step
+	d.done = d.end == d.max
+	d.next = d.end + 1
+	return d.end, true
+}
+
+// span is the number of keys in the current chunk's range.
+func (d *denseKey) span() int64 {
+	return d.end - d.start + 1
+}
+
+// hasGaps reports whether the current chunk's key range holds fewer rows
+// than keys. lowOp is the comparison the chunk used against its range start.
+func (c *Chunker) hasGaps(d *denseKey, lowOp string) (bool, error) {
+	col := c.Config.UniqueKeyColumnNames
+	row, err := c.state().QueryRow(c.ctx, fmt.Sprintf("SELECT COUNT(*) AS n FROM %s WHERE %s %s @unique_key_range_start_0 AND %s <= @unique_key_range_end_0", c.tableRef(), col, lowOp, col))
+	if err != nil {
+		return false, err
+	}
+	n, ok := denseKeyValue(row["n"])
+	if !ok {
+		return false, fmt.Errorf("unexpected row count %v", row["n"])
+	}
+	return n < d.span(), nil
+}
+
+// denseKeyValue converts an integer key value as returned by the driver.
+func denseKeyValue(val interface{}) (int64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= 1<<63-1
+	case []byte:
+		i, err := strconv.ParseInt(string(v), 10, 64)
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// boundaryQueries counts the boundary SELECTs issued on db.
+func boundaryQueries(db *simDB) int {
+	n := 0
+	for _, q := range db.queries {
+		if strings.Contains(q, "LIMIT") {
+			n++
+		}
+	}
+	return n
+}
+
+func checkTouchedOnce(t *testing.T, db *simDB) {
+	t.Helper()
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("key %v touched %d times, want once", key, db.touched[key])
+		}
+	}
+}
+
+func TestDenseKeysMatchQueriedBoundaries(t *testing.T) {
+	keys := seqKeys(1, 95)
+	queried, _ := runSplit(t, keys, false, nil)
+	var reader *simDB
+	dense, writer := runSplit(t, keys, false, func(c *Chunker, _ *simDB) {
+		c.Config.DenseKeys = DenseKeysAuto
+		reader = c.reader.(*simDB)
+	})
+	if !reflect.DeepEqual(dense, queried) {
+		t.Errorf("dense ranges = %v, want %v", dense, queried)
+	}
+	if n := boundaryQueries(reader); n != 0 {
+		t.Errorf("issued %d boundary queries on a dense key, want none", n)
+	}
+	checkTouchedOnce(t, writer)
+}
+
+func TestDenseKeysKeepStepWhenStatementSkipsRows(t *testing.T) {
+	var reader *simDB
+	_, writer := runSplit(t, seqKeys(1, 95), false, func(c *Chunker, writer *simDB) {
+		c.Config.DenseKeys = DenseKeysAuto
+		reader = c.reader.(*simDB)
+		writer.matches = func(key interface{}) bool { return key.(int64)%2 == 0 }
+	})
+	if n := boundaryQueries(reader); n != 0 {
+		t.Errorf("issued %d boundary queries, want none: the key has no gaps", n)
+	}
+	if len(writer.execs) != 10 {
+		t.Errorf("ran %d chunks, want 10", len(writer.execs))
+	}
+}
+
+func TestDenseKeysFallBackOnGaps(t *testing.T) {
+	var reader *simDB
+	ranges, writer := runSplit(t, skewedKeys(), false, func(c *Chunker, _ *simDB) {
+		c.Config.DenseKeys = DenseKeysAuto
+		reader = c.reader.(*simDB)
+	})
+	checkTouchedOnce(t, writer)
+	// Nine arithmetic chunks, the empty one revealing the gap, then queries;
+	// a chunk's start is the previous chunk's end
+	want := []string{"1-10", "10-20", "20-30", "30-40", "40-50", "50-60", "60-70", "70-80", "80-90", "90-100", "100-919"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ranges = %v, want %v", ranges, want)
+	}
+	if n := boundaryQueries(reader); n != 2 {
+		t.Errorf("issued %d boundary queries after the gap, want 2", n)
+	}
+}
+
+func TestDenseKeysForcedIgnoresGaps(t *testing.T) {
+	var reader *simDB
+	ranges, writer := runSplit(t, skewedKeys(), false, func(c *Chunker, _ *simDB) {
+		c.Config.DenseKeys = DenseKeysOn
+		reader = c.reader.(*simDB)
+	})
+	checkTouchedOnce(t, writer)
+	if len(ranges) != 92 || ranges[91] != "910-919" {
+		t.Errorf("ran %d chunks ending with %v, want 92 ending with 910-919", len(ranges), ranges[len(ranges)-1])
+	}
+	if n := boundaryQueries(reader); n != 0 {
+		t.Errorf("issued %d boundary queries, want none", n)
+	}
+}
+
+func TestDenseKeysForcedNeedsIntegerKey(t *testing.T) {
+	db := newSimDBValues([]interface{}{"a", "b"})
+	c := newSimChunker(db, 10)
+	c.Config.UniqueKeyType = "text"
+	c.Config.DenseKeys = DenseKeysOn
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil || !strings.Contains(err.Error(), "single-column integer key") {
+		t.Errorf("expected a text key to be rejected, got %v", err)
+	}
+}
+
+func TestDenseKeyBoundaryStopsAtMaxInt64(t *testing.T) {
+	d := &denseKey{next: math.MaxInt64 - 14, max: math.MaxInt64}
+	var ends []int64
+	for {
+		end, ok := d.boundary(10)
+		if !ok {
+			break
+		}
+		ends = append(ends, end)
+	}
+	want := []int64{math.MaxInt64 - 5, math.MaxInt64}
+	if !reflect.DeepEqual(ends, want) {
+		t.Errorf("ends = %v, want %v", ends, want)
+	}
+}
+
+func TestValidateDenseKeys(t *testing.T) {
+	for _, mode := range []string{"", "off", "auto", "on"} {
+		if err := ValidateDenseKeys(mode); err != nil {
+			t.Errorf("%q: %v", mode, err)
+		}
+	}
+	if err := ValidateDenseKeys("yes"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
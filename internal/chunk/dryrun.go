@@ -0,0 +1,45 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+var rangeVariableRe = regexp.MustCompile(`@(unique_key_\w+)`)
+
+// SetDryRunOutput sets where DryRun prints the chunk statements; stdout by
+// default.
+func (c *Chunker) SetDryRunOutput(w io.Writer) {
+	c.dryRunOut = w
+}
+
+// printDryRun prints a chunk's statement instead of executing it, with the
+// range variables of snapshot resolved to literals, so that it can be
+// reviewed or run by hand.
+func (c *Chunker) printDryRun(statement string, snapshot map[string]interface{}) {
+	out := c.dryRunOut
+	if out == nil {
+		out = os.Stdout
+	}
+	resolved := rangeVariableRe.ReplaceAllStringFunc(statement, func(ref string) string {
+		if val, ok := snapshot[ref[1:]]; ok {
+			return c.keyLiteral(val)
+		}
+		return ref
+	})
+	fmt.Fprintf(out, "%s;\n", resolved)
+}
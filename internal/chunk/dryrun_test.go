@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunIssuesNoMutation(t *testing.T) {
+	db := newSimDB(seqKeys(1, 25))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.DryRun = true
+	chunker.Config.TerminateOnNotFound = true
+	chunker.Config.CheckpointFile = filepath.Join(t.TempDir(), "job.ckpt")
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	var out bytes.Buffer
+	chunker.SetDryRunOutput(&out)
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(db.execs) != 0 || len(db.touched) != 0 {
+		t.Errorf("Expected no mutating statement in a dry run, got %v", db.execs)
+	}
+	if summary.Chunks != 3 || summary.Reason != ReasonCompleted || summary.RowsAffected != 0 {
+		t.Errorf("Expected 3 chunks of a completed run, got %+v", summary)
+	}
+	want := "UPDATE t SET x=1 WHERE id >= 1 AND id <= 10;\n" +
+		"UPDATE t SET x=1 WHERE id > 10 AND id <= 20;\n" +
+		"UPDATE t SET x=1 WHERE id > 20 AND id <= 25;\n"
+	if out.String() != want {
+		t.Errorf("Expected the resolved statements\n%s\ngot\n%s", want, out.String())
+	}
+	if _, err := os.Stat(chunker.Config.CheckpointFile); !os.IsNotExist(err) {
+		t.Errorf("Expected a dry run to write no checkpoint, got %v", err)
+	}
+}
+
+func TestDryRunRefusesBatches(t *testing.T) {
+	chunker := newSimChunker(newSimDB(seqKeys(1, 25)), 10)
+	chunker.Config.DryRun = true
+	chunker.Config.BatchCommit = 2
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil || !strings.Contains(err.Error(), "dry run") {
+		t.Errorf("Expected a dry run with batches to be refused, got %v", err)
+	}
+}
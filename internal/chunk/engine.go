@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EngineDB can report a table's storage engine.
+type EngineDB interface {
+	TableEngine(database, table string) (string, error)
+}
+
+// transactionalEngines are the storage engines whose chunks commit or roll
+// back as a unit and lock only the rows they touch.
+var transactionalEngines = map[string]bool{
+	"innodb":     true,
+	"ndbcluster": true,
+	"ndb":        true,
+	"rocksdb":    true,
+}
+
+// IsTransactionalEngine reports whether engine is a transactional storage
+// engine, ignoring case.
+func IsTransactionalEngine(engine string) bool {
+	return transactionalEngines[strings.ToLower(engine)]
+}
+
+// CheckTransactionalEngine returns an error describing the risk when
+// database.table does not use a transactional engine. On MyISAM and similar
+// engines a failed chunk is not rolled back and every chunk locks the whole
+// table.
+func CheckTransactionalEngine(db EngineDB, database, table string) error {
+	engine, err := db.TableEngine(database, table)
+	if err != nil {
+		return err
+	}
+	if IsTransactionalEngine(engine) {
+		return nil
+	}
+	if engine == "" {
+		engine = "unknown"
+	}
+	return fmt.Errorf("table %s.%s uses the non-transactional %s engine: a failed chunk is not rolled back and each chunk locks the whole table", database, table, engine)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"strings"
+	"testing"
+)
+
+type engineMockDB struct {
+	MockDB
+	engine string
+}
+
+func (m *engineMockDB) TableEngine(database, table string) (string, error) {
+	return m.engine, nil
+}
+
+func TestCheckTransactionalEngine(t *testing.T) {
+	for _, engine := range []string{"InnoDB", "innodb", "ndbcluster"} {
+		if err := CheckTransactionalEngine(&engineMockDB{engine: engine}, "test", "t"); err != nil {
+			t.Errorf("%s: unexpected error: %v", engine, err)
+		}
+	}
+	err := CheckTransactionalEngine(&engineMockDB{engine: "MyISAM"}, "test", "t")
+	if err == nil || !strings.Contains(err.Error(), "non-transactional MyISAM engine") {
+		t.Errorf("Expected MyISAM to be rejected, got %v", err)
+	}
+	err = CheckTransactionalEngine(&engineMockDB{}, "test", "t")
+	if err == nil || !strings.Contains(err.Error(), "unknown engine") {
+		t.Errorf("Expected an unknown engine to be rejected, got %v", err)
+	}
+}
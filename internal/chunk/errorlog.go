@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of anomaly recorded in an ErrorLog.
+const (
+	AnomalyWarning = "warning"
+	AnomalyRetry   = "retry"
+	AnomalySkip    = "skip"
+	AnomalyError   = "error"
+)
+
+// ErrorLogEntry is one line of an ErrorLog.
+type ErrorLogEntry struct {
+	Time    time.Time `json:"time"`
+	Job     string    `json:"job,omitempty"`
+	Kind    string    `json:"kind"`
+	Chunk   int       `json:"chunk,omitempty"`
+	Message string    `json:"message"`
+}
+
+// ErrorLog appends a JSON line for every warning, retry, skipped chunk and
+// error of a run, whatever the output mode. A nil *ErrorLog discards
+// everything, so callers need not check whether one is configured.
+type ErrorLog struct {
+	mu   sync.Mutex
+	file *os.File
+	job  string
+	now  func() time.Time
+}
+
+// OpenErrorLog opens path for appending, creating it if needed. Entries are
+// tagged with job.
+func OpenErrorLog(path, job string) (*ErrorLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &ErrorLog{file: file, job: job, now: time.Now}, nil
+}
+
+// Log records an anomaly of kind, about chunk when it is not 0. A write
+// failure is ignored: the log must never stop a run.
+func (l *ErrorLog) Log(kind string, chunk int, msg string) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(ErrorLogEntry{Time: l.now().UTC(), Job: l.job, Kind: kind, Chunk: chunk, Message: msg})
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file.Write(append(line, '\n'))
+}
+
+func (l *ErrorLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// SetErrorLog records the run's anomalies in log as well as on stderr.
+func (c *Chunker) SetErrorLog(log *ErrorLog) {
+	c.errorLog = log
+}
+
+// anomaly reports msg as a warning and records it in the error log as kind.
+func (c *Chunker) anomaly(kind string, chunk int, msg string) {
+	fmt.Fprintf(os.Stderr, "-- Warning: %s\n", msg)
+	c.logAnomaly(kind, chunk, msg)
+}
+
+// logAnomaly records msg in the error log as kind, and counts retried and
+// skipped chunks for the run summary.
+func (c *Chunker) logAnomaly(kind string, chunk int, msg string) {
+	switch kind {
+	case AnomalyRetry:
+		c.retries++
+	case AnomalySkip:
+		c.skipped++
+	}
+	c.errorLog.Log(kind, chunk, msg)
+}
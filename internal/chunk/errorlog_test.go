@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readErrorLog returns the entries written to path.
+func readErrorLog(t *testing.T, path string) []ErrorLogEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []ErrorLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry ErrorLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func openTestErrorLog(t *testing.T) (*ErrorLog, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	log, err := OpenErrorLog(path, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log, path
+}
+
+func TestErrorLogRecordsRetry(t *testing.T) {
+	_, chunker := newReconnectChunker(3)
+	chunker.Config.RetryLostChunk = true
+	log, path := openTestErrorLog(t)
+	chunker.SetErrorLog(log)
+
+	captureStderr(t, func() {
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+	entries := readErrorLog(t, path)
+	if len(entries) != 1 {
+		t.Fatalf("Expected one entry, got %+v", entries)
+	}
+	e := entries[0]
+	if e.Kind != AnomalyRetry || e.Chunk != 3 || e.Job != "job1" || !strings.Contains(e.Message, "retrying the chunk") || e.Time.IsZero() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestErrorLogRecordsSkippedChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	chunker.Config.ConfirmEachChunk = true
+	chunker.SetConfirmInput(strings.NewReader("y\nn\n"), &bytes.Buffer{})
+	log, path := openTestErrorLog(t)
+	chunker.SetErrorLog(log)
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries := readErrorLog(t, path)
+	if len(entries) != 1 || entries[0].Kind != AnomalySkip || entries[0].Chunk != 2 {
+		t.Errorf("Expected a skip entry for chunk 2, got %+v", entries)
+	}
+}
+
+func TestErrorLogRecordsWarnings(t *testing.T) {
+	log, path := openTestErrorLog(t)
+	chunker := NewChunker(&MockDB{}, Config{})
+	chunker.SetErrorLog(log)
+	captureStderr(t, func() { chunker.Warn("something odd") })
+
+	entries := readErrorLog(t, path)
+	if len(entries) != 1 || entries[0].Kind != AnomalyWarning || entries[0].Message != "something odd" || entries[0].Chunk != 0 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	// A nil log discards entries
+	var none *ErrorLog
+	none.Log(AnomalyError, 0, "ignored")
+}
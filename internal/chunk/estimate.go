@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Estimate is a preflight prediction of a run's size and duration.
+type Estimate struct {
+	Chunks int
+	// PerChunk is the measured time of the probe standing in for one chunk
+	PerChunk time.Duration
+	// Sleep is the configured pause between chunks
+	Sleep time.Duration
+	Total time.Duration
+}
+
+// estimateRuntime predicts the duration of chunks chunks taking perChunk each,
+// with sleep between consecutive ones.
+func estimateRuntime(chunks int, perChunk, sleep time.Duration) time.Duration {
+	if chunks <= 0 {
+		return 0
+	}
+	return time.Duration(chunks)*perChunk + time.Duration(chunks-1)*sleep
+}
+
+// PreflightEstimate counts the chunks of the whole key range with
+// ComputeBoundaries and times a read-only probe of the first chunk, a COUNT(*)
+// over its key range, to predict the run's duration at the configured pacing.
+// Nothing is modified. The probe does not include the cost of writing, so the
+// estimate is a lower bound for UPDATE and DELETE statements. GetUniqueKeyRange
+// must have been called first.
+func (c *Chunker) PreflightEstimate() (Estimate, error) {
+	estimate := Estimate{Sleep: time.Duration(c.Config.SleepMillis) * time.Millisecond}
+	startVars := c.getUniqueKeyRangeStartVariables()
+	if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", c.getUniqueKeyMinValuesVariables(), startVars)); err != nil {
+		return estimate, err
+	}
+	boundaries, err := c.ComputeBoundaries(true)
+	if err != nil {
+		return estimate, err
+	}
+	estimate.Chunks = len(boundaries)
+	if estimate.Chunks == 0 {
+		return estimate, nil
+	}
+
+	source := c.boundarySource(startVars, ">=", c.Config.ChunkSize)
+	if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s %s", c.Config.UniqueKeyColumnNames, c.getUniqueKeyRangeEndVariables(), source)); err != nil {
+		return estimate, err
+	}
+	probeStart := time.Now()
+	if _, err := c.state().QueryRow(c.ctx, fmt.Sprintf("SELECT COUNT(*) AS probe_rows FROM %s WHERE %s", c.tableRef(), c.rangePredicate(c.Config.UniqueKeyColumnNames, ">="))); err != nil {
+		return estimate, err
+	}
+	estimate.PerChunk = time.Since(probeStart)
+	estimate.Total = estimateRuntime(estimate.Chunks, estimate.PerChunk, estimate.Sleep)
+	return estimate, nil
+}
+
+// Print writes the estimate as comment lines.
+func (e Estimate) Print(w io.Writer) {
+	fmt.Fprintf(w, "-- Estimate: %d chunks\n", e.Chunks)
+	fmt.Fprintf(w, "-- Estimate: %.3f seconds per chunk (read-only probe), %.3f seconds sleep between chunks\n", e.PerChunk.Seconds(), e.Sleep.Seconds())
+	fmt.Fprintf(w, "-- Estimate: %s in total, more if writes dominate\n", e.Total.Round(time.Second))
+}
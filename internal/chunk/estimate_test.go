@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateRuntime(t *testing.T) {
+	tests := []struct {
+		chunks   int
+		perChunk time.Duration
+		sleep    time.Duration
+		expected time.Duration
+	}{
+		{0, time.Second, time.Second, 0},
+		{1, 2 * time.Second, time.Second, 2 * time.Second},
+		{10, 2 * time.Second, 500 * time.Millisecond, 24500 * time.Millisecond},
+		{1000, 40 * time.Millisecond, 0, 40 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := estimateRuntime(tt.chunks, tt.perChunk, tt.sleep); got != tt.expected {
+			t.Errorf("estimateRuntime(%d, %v, %v) = %v, expected %v", tt.chunks, tt.perChunk, tt.sleep, got, tt.expected)
+		}
+	}
+}
+
+func TestPreflightEstimate(t *testing.T) {
+	db := newSimDB(seqKeys(1, 95))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.SleepMillis = 200
+
+	estimate, err := chunker.PreflightEstimate()
+	if err != nil {
+		t.Fatalf("PreflightEstimate: %v", err)
+	}
+	if estimate.Chunks != 10 {
+		t.Errorf("Expected 10 chunks, got %d", estimate.Chunks)
+	}
+	if estimate.Total != estimateRuntime(10, estimate.PerChunk, 200*time.Millisecond) {
+		t.Errorf("Unexpected total %v for %v per chunk", estimate.Total, estimate.PerChunk)
+	}
+	if len(db.execs) != 0 || len(db.touched) != 0 {
+		t.Errorf("Expected no modification, got %v", db.execs)
+	}
+	probe := db.queries[len(db.queries)-1]
+	if !strings.HasPrefix(probe, "SELECT COUNT(*) AS probe_rows FROM test.t WHERE id >= @unique_key_range_start_0") {
+		t.Errorf("Unexpected probe %q", probe)
+	}
+
+	var out bytes.Buffer
+	estimate.Print(&out)
+	if !strings.Contains(out.String(), "-- Estimate: 10 chunks") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
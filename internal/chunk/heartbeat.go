@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// heartbeat prints what the run is doing every LivenessInterval, so that a
+// log watched for inactivity, as by CI systems, keeps moving through a long
+// chunk or pause.
+type heartbeat struct {
+	mu     sync.Mutex
+	status string
+	out    io.Writer
+}
+
+// SetHeartbeatOutput sets where LivenessInterval heartbeats are written;
+// stdout by default.
+func (c *Chunker) SetHeartbeatOutput(w io.Writer) {
+	c.heartbeat.out = w
+}
+
+// setStatus records what the run is doing, for the next heartbeat.
+func (c *Chunker) setStatus(format string, args ...interface{}) {
+	c.heartbeat.mu.Lock()
+	c.heartbeat.status = fmt.Sprintf(format, args...)
+	c.heartbeat.mu.Unlock()
+}
+
+// startHeartbeat starts printing heartbeats, if LivenessInterval is set, and
+// returns a function stopping them.
+func (c *Chunker) startHeartbeat() func() {
+	interval := c.Config.LivenessInterval
+	if interval <= 0 {
+		return func() {}
+	}
+	out := c.heartbeat.out
+	if out == nil {
+		out = os.Stdout
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.heartbeat.mu.Lock()
+				status := c.heartbeat.status
+				c.heartbeat.mu.Unlock()
+				fmt.Fprintf(out, "-- Heartbeat: %s; %.0f seconds elapsed\n", status, time.Since(start).Seconds())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
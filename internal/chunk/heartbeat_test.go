@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for the heartbeat goroutine to write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeatDuringLongPause(t *testing.T) {
+	db := newSimDB(seqKeys(1, 20))
+	c := newSimChunker(db, 10)
+	c.Config.LivenessInterval = 5 * time.Millisecond
+	c.Config.SleepMillis = 1
+	c.sleep = func(time.Duration) { time.Sleep(60 * time.Millisecond) }
+	out := &syncBuffer{}
+	c.SetHeartbeatOutput(out)
+	c.SetReporter(&recordingReporter{})
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	paused := 0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "-- Heartbeat: ") {
+			t.Errorf("Unexpected output line %q", line)
+		}
+		if strings.Contains(line, "pausing after chunk 1, 10 rows affected so far") {
+			paused++
+		}
+	}
+	if paused < 2 {
+		t.Errorf("Expected repeated heartbeats during the pause after chunk 1, got:\n%s", out)
+	}
+
+	// No heartbeat is printed once the run has returned
+	n := len(out.String())
+	time.Sleep(20 * time.Millisecond)
+	if len(out.String()) != n {
+		t.Error("Heartbeats continued after the run ended")
+	}
+}
+
+func TestNoHeartbeatByDefault(t *testing.T) {
+	db := newSimDB(seqKeys(1, 20))
+	c := newSimChunker(db, 10)
+	out := &syncBuffer{}
+	c.SetHeartbeatOutput(out)
+	c.SetReporter(&recordingReporter{})
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "" {
+		t.Errorf("Expected no heartbeat without LivenessInterval, got %q", out)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// checkChunkEdges verifies, in debug mode, that the chunk whose range
+// variables are in snapshot continues the key range without a gap or an
+// overlap. prevEnd is the previous chunk's end, nil for the first chunk of
+// this run; inclusive is set when the chunk includes its start. A chunk after
+// another must start at exactly its end, which the exclusive predicate then
+// leaves out, and every chunk must end at or after its start.
+func (c *Chunker) checkChunkEdges(prevEnd []interface{}, snapshot map[string]interface{}, inclusive bool) error {
+	n := c.Config.CountColumnsInUniqueKey
+	start, end := make([]interface{}, n), make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		start[i] = snapshot[fmt.Sprintf("unique_key_range_start_%d", i)]
+		end[i] = snapshot[fmt.Sprintf("unique_key_range_end_%d", i)]
+	}
+	if prevEnd != nil {
+		if inclusive {
+			return fmt.Errorf("chunk edge check: chunk after %s includes its start, so the boundary row is processed twice", c.formatRangeValue(prevEnd))
+		}
+		if !sameKey(start, prevEnd) {
+			return fmt.Errorf("chunk edge check: chunk starts after %s, but the previous chunk ended at %s", c.formatRangeValue(start), c.formatRangeValue(prevEnd))
+		}
+	}
+	if n != 1 {
+		return nil
+	}
+	// Only numeric and binary keys order the same on the client as on the
+	// server; text follows the column's collation
+	cmp, ok := compareKey(c.Config.UniqueKeyType, end[0], start[0])
+	if ok && (cmp < 0 || (cmp == 0 && !inclusive)) {
+		return fmt.Errorf("chunk edge check: chunk %s to %s is empty or reversed", c.formatRangeValue(start), c.formatRangeValue(end))
+	}
+	return nil
+}
+
+// sameKey reports whether two key tuples hold the same values.
+func sameKey(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if fmt.Sprint(a[i]) != fmt.Sprint(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareKey compares two values of a key of keyType, and reports false when
+// their order cannot be told on the client.
+func compareKey(keyType string, a, b interface{}) (int, bool) {
+	switch keyType {
+	case "float":
+		af, bf := toFloat(a), toFloat(b)
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	case "integer", "decimal":
+		// Exactly: BIGINT values above 2^53 and DECIMAL(20,4) values differ
+		// beyond a float64's precision
+		ad, aok := decimalValue(a)
+		bd, bok := decimalValue(b)
+		if !aok || !bok {
+			return 0, false
+		}
+		return ad.Cmp(bd), true
+	case "binary":
+		ab, aok := keyBytes(a)
+		bb, bok := keyBytes(b)
+		return bytes.Compare(ab, bb), aok && bok
+	}
+	return 0, false
+}
+
+func keyBytes(val interface{}) ([]byte, bool) {
+	switch v := val.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
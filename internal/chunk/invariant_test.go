@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func edgeSnapshot(start, end interface{}) map[string]interface{} {
+	return map[string]interface{}{"unique_key_range_start_0": start, "unique_key_range_end_0": end}
+}
+
+func TestCheckChunkEdges(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyType   string
+		prevEnd   []interface{}
+		snapshot  map[string]interface{}
+		inclusive bool
+		err       string
+	}{
+		{"first chunk", "integer", nil, edgeSnapshot(int64(1), int64(10)), true, ""},
+		{"single-row first chunk", "integer", nil, edgeSnapshot(int64(1), int64(1)), true, ""},
+		{"contiguous", "integer", []interface{}{int64(10)}, edgeSnapshot(int64(10), int64(20)), false, ""},
+		{"gap", "integer", []interface{}{int64(10)}, edgeSnapshot(int64(11), int64(20)), false, "previous chunk ended at 10"},
+		{"boundary row twice", "integer", []interface{}{int64(10)}, edgeSnapshot(int64(10), int64(20)), true, "processed twice"},
+		{"empty", "integer", []interface{}{int64(10)}, edgeSnapshot(int64(10), int64(10)), false, "empty or reversed"},
+		{"reversed", "float", []interface{}{"2.5"}, edgeSnapshot("2.5", "1.5"), false, "empty or reversed"},
+		{"adjacent bigints", "integer", []interface{}{int64(1<<62 + 1)}, edgeSnapshot(int64(1<<62+1), int64(1<<62+2)), false, ""},
+		{"adjacent unsigned bigints", "integer", []interface{}{"18446744073709551614"}, edgeSnapshot("18446744073709551614", uint64(math.MaxUint64)), false, ""},
+		{"adjacent floats", "float", []interface{}{1.0}, edgeSnapshot(1.0, math.Nextafter(1, 2)), false, ""},
+		{"binary", "binary", []interface{}{"\x01\xff"}, edgeSnapshot("\x01\xff", "\x02\x00"), false, ""},
+		{"text order is the server's", "text", []interface{}{"b"}, edgeSnapshot("b", "B"), false, ""},
+	}
+	for _, tt := range tests {
+		c := NewChunker(nil, Config{CountColumnsInUniqueKey: 1, UniqueKeyType: tt.keyType})
+		err := c.checkChunkEdges(tt.prevEnd, tt.snapshot, tt.inclusive)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestDebugRunOnBigintKeys(t *testing.T) {
+	// Adjacent BIGINT values above 2^53 are one float64 apart or less
+	db := newSimDB(seqKeys(1<<62, 1<<62+20))
+	c := newSimChunker(db, 1)
+	c.Config.Debug = true
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkTouchedOnce(t, db)
+}
+
+func TestDebugRunKeepsChunkEdgesContiguous(t *testing.T) {
+	// Adjacent doubles meet at every chunk edge, where an off-by-one in
+	// the boundary query would skip or repeat a row
+	keys := make([]interface{}, 0, 35)
+	for k := 1.0; len(keys) < 35; k = math.Nextafter(k, 2) {
+		keys = append(keys, k)
+	}
+	for _, minAffected := range []int{0, 100} {
+		db := newSimDBValues(keys)
+		c := newSimChunker(db, 10)
+		c.Config.UniqueKeyType = "float"
+		c.Config.Debug = true
+		c.Config.MinAffectedPerChunk = minAffected
+		if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("min affected %d: %v", minAffected, err)
+		}
+		checkTouchedOnce(t, db)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ChunkEvent is the JSON line JSONReporter writes for an executed chunk
+// (event "chunk") or a failed one (event "chunk-failed").
+type ChunkEvent struct {
+	Event         string      `json:"event"`
+	Chunk         int         `json:"chunk"`
+	Start         interface{} `json:"start"`
+	End           interface{} `json:"end"`
+	Affected      int64       `json:"affected"`
+	TotalAffected int64       `json:"total_affected"`
+	ElapsedMillis int64       `json:"elapsed_ms"`
+	Progress      int         `json:"progress"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// SummaryEvent is the JSON line JSONReporter writes when a run ends.
+type SummaryEvent struct {
+	Event         string     `json:"event"`
+	Chunks        int        `json:"chunks"`
+	RowsAffected  int64      `json:"rows_affected"`
+	ElapsedMillis int64      `json:"elapsed_ms"`
+	Rate          float64    `json:"rows_per_second"`
+	AverageMillis int64      `json:"avg_chunk_ms"`
+	FastestMillis int64      `json:"min_chunk_ms"`
+	SlowestMillis int64      `json:"max_chunk_ms"`
+	Reason        StopReason `json:"reason"`
+	Partial       bool       `json:"partial"`
+	Retries       int        `json:"retries"`
+	Skipped       int        `json:"skipped"`
+}
+
+// JSONReporter writes one JSON object per line for every executed or failed
+// chunk and for the summary, for orchestration tools parsing the output.
+// With summaryOnly, only the summary is written.
+type JSONReporter struct {
+	enc         *json.Encoder
+	summaryOnly bool
+}
+
+func NewJSONReporter(w io.Writer, summaryOnly bool) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(w), summaryOnly: summaryOnly}
+}
+
+func (j *JSONReporter) ChunkStarted(r ChunkReport) {}
+
+func (j *JSONReporter) ChunkDone(r ChunkReport) {
+	if !j.summaryOnly {
+		j.enc.Encode(chunkEvent("chunk", r))
+	}
+}
+
+func (j *JSONReporter) ChunkFailed(r ChunkReport, err error) {
+	if !j.summaryOnly {
+		event := chunkEvent("chunk-failed", r)
+		event.Error = err.Error()
+		j.enc.Encode(event)
+	}
+}
+
+func (j *JSONReporter) Summary(s RunSummary) {
+	j.enc.Encode(SummaryEvent{
+		Event:         "summary",
+		Chunks:        s.Chunks,
+		RowsAffected:  s.RowsAffected,
+		ElapsedMillis: s.Elapsed.Milliseconds(),
+		Rate:          s.Rate(),
+		AverageMillis: s.AverageChunk().Milliseconds(),
+		FastestMillis: s.FastestChunk.Milliseconds(),
+		SlowestMillis: s.SlowestChunk.Milliseconds(),
+		Reason:        s.Reason,
+		Partial:       s.Partial(),
+		Retries:       s.Retries,
+		Skipped:       s.Skipped,
+	})
+}
+
+func chunkEvent(event string, r ChunkReport) ChunkEvent {
+	return ChunkEvent{
+		Event:         event,
+		Chunk:         r.Index,
+		Start:         r.Start,
+		End:           r.End,
+		Affected:      r.Affected,
+		TotalAffected: r.TotalAffected,
+		ElapsedMillis: r.Elapsed.Milliseconds(),
+		Progress:      r.Progress,
+	}
+}
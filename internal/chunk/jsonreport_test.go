@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeEvents decodes the JSON lines of out into generic objects.
+func decodeEvents(t *testing.T, out string) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Line %q is not JSON: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestJSONReporterEmitsChunksAndSummary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.AccurateProgress = true
+	var out bytes.Buffer
+	chunker.SetReporter(NewJSONReporter(&out, false))
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	events := decodeEvents(t, out.String())
+	if len(events) != 4 {
+		t.Fatalf("Expected 3 chunk events and a summary, got %s", out.String())
+	}
+	for i, event := range events[:3] {
+		n := float64(i + 1)
+		if event["event"] != "chunk" || event["chunk"] != n || event["affected"] != 10.0 || event["total_affected"] != 10*n {
+			t.Errorf("Unexpected chunk event %d: %v", i+1, event)
+		}
+		if _, ok := event["elapsed_ms"].(float64); !ok {
+			t.Errorf("Expected elapsed_ms in chunk event %d: %v", i+1, event)
+		}
+		if _, ok := event["error"]; ok {
+			t.Errorf("Expected no error in chunk event %d: %v", i+1, event)
+		}
+	}
+	if events[1]["start"] != "10" || events[1]["end"] != "20" || events[1]["progress"] != 33.0 {
+		t.Errorf("Unexpected range or progress of chunk 2: %v", events[1])
+	}
+	summary := events[3]
+	if summary["event"] != "summary" || summary["chunks"] != 3.0 || summary["rows_affected"] != 30.0 || summary["reason"] != "completed" {
+		t.Errorf("Unexpected summary event: %v", summary)
+	}
+	for _, field := range []string{"avg_chunk_ms", "min_chunk_ms", "max_chunk_ms"} {
+		if _, ok := summary[field].(float64); !ok {
+			t.Errorf("Expected %s in the summary event: %v", field, summary)
+		}
+	}
+	if summary["partial"] != false {
+		t.Errorf("Expected a complete run not to be partial: %v", summary)
+	}
+}
+
+func TestJSONReporterEmitsFailedChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	db.failAt = 2
+	chunker := newSimChunker(db, 10)
+	var out bytes.Buffer
+	chunker.SetReporter(NewJSONReporter(&out, false))
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Fatal("Expected the run to fail")
+	}
+
+	events := decodeEvents(t, out.String())
+	if len(events) != 3 || events[1]["event"] != "chunk-failed" || events[1]["error"] != "simulated failure" {
+		t.Errorf("Expected a chunk-failed event with the error, got %s", out.String())
+	}
+	if events[2]["event"] != "summary" || events[2]["reason"] != "error" {
+		t.Errorf("Expected a failed summary last, got %v", events[2])
+	}
+}
+
+func TestJSONReporterSummaryOnly(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	var out bytes.Buffer
+	chunker.SetReporter(NewJSONReporter(&out, true))
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	events := decodeEvents(t, out.String())
+	if len(events) != 1 || events[0]["event"] != "summary" {
+		t.Errorf("Expected only the summary, got %s", out.String())
+	}
+}
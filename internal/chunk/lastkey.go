@@ -0,0 +1,46 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import "strings"
+
+// LastKey returns the upper boundary of the last chunk committed by this
+// chunker, or of the checkpoint it resumed from, and false when there is
+// none yet. It is safe to call while a run is in progress, e.g. from a
+// signal handler.
+func (c *Chunker) LastKey() (string, bool) {
+	c.lastKeyMu.Lock()
+	defer c.lastKeyMu.Unlock()
+	return c.lastKey, c.lastKey != ""
+}
+
+// committed records end as the last committed boundary.
+func (c *Chunker) committed(end []interface{}) {
+	c.setLastKey(c.formatRangeValue(end))
+}
+
+// resumedFrom records a checkpoint's boundary literals as the last committed
+// boundary.
+func (c *Chunker) resumedFrom(boundary []string) {
+	key := strings.Join(boundary, ",")
+	if len(boundary) > 1 {
+		key = "(" + key + ")"
+	}
+	c.setLastKey(key)
+}
+
+func (c *Chunker) setLastKey(key string) {
+	c.lastKeyMu.Lock()
+	defer c.lastKeyMu.Unlock()
+	c.lastKey = key
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Chunker, db *simDB)
+		wantErr   bool
+		want      string
+	}{
+		{"completed", nil, false, "100"},
+		{"error", func(c *Chunker, db *simDB) { db.failAt = 3 }, true, "20"},
+		{"interrupted", func(c *Chunker, db *simDB) {
+			stop := make(chan struct{})
+			c.SetReporter(afterChunks(2, func() { close(stop) }))
+			c.SetStopSignal(stop)
+		}, false, "20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSimDB(seqKeys(1, 100))
+			c := newSimChunker(db, 10)
+			c.Config.SkipRetryChunk = true
+			if tt.configure != nil {
+				tt.configure(c, db)
+			}
+			_, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ChunkUpdate error = %v, want error %v", err, tt.wantErr)
+			}
+			if got, ok := c.LastKey(); !ok || got != tt.want {
+				t.Errorf("LastKey = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastKeyFromResumedCheckpoint(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	c.Config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := (&Checkpoint{Database: "test", Table: "t", Columns: "id", KeyType: "integer", Boundary: []string{"40"}}).Save(c.Config.CheckpointFile); err != nil {
+		t.Fatal(err)
+	}
+	db.failAt = 1
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Fatal("expected the first chunk to fail")
+	}
+	if got, ok := c.LastKey(); !ok || got != "40" {
+		t.Errorf("LastKey = %q, %v, want the checkpoint's 40", got, ok)
+	}
+}
+
+func TestLastKeyBeforeAnyChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	db.failAt = 1
+	c.Config.SkipRetryChunk = true
+	c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if got, ok := c.LastKey(); ok {
+		t.Errorf("LastKey = %q, want none before a chunk committed", got)
+	}
+}
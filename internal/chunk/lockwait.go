@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lockWaitPollInterval is how often a yielding run checks whether the
+// sessions it blocked got their metadata lock.
+const lockWaitPollInterval = time.Second
+
+// LockWaitDB is implemented by connections that can describe the sessions
+// waiting for a metadata lock they hold, such as a DDL statement queued
+// behind the job's open transaction.
+type LockWaitDB interface {
+	MetadataLockWaiters() ([]string, error)
+}
+
+// yieldToLockWaiters lets sessions blocked by the job's metadata locks
+// through between chunks, when YieldToLockWaits is set. An open batch is
+// committed to release its locks, then the run pauses until no session waits
+// on it any more, for at most YieldToLockWaits. The table lock of LOCK TABLES
+// is only released at the end of the run, so under it the run does not pause.
+func (c *Chunker) yieldToLockWaiters() error {
+	if c.Config.YieldToLockWaits <= 0 {
+		return nil
+	}
+	db, ok := c.db.(LockWaitDB)
+	if !ok {
+		return nil
+	}
+	var waited time.Duration
+	for {
+		waiters, err := db.MetadataLockWaiters()
+		if err != nil {
+			c.Warn(fmt.Sprintf("cannot check for sessions waiting on the job's metadata locks: %v", err))
+			return nil
+		}
+		if len(waiters) == 0 {
+			if waited > 0 {
+				c.Verbose(fmt.Sprintf("Metadata lock waiters got through after %v; resuming", waited))
+			}
+			return nil
+		}
+		if waited == 0 {
+			c.Warn(fmt.Sprintf("%d sessions waiting for a metadata lock held by this job (%s)", len(waiters), strings.Join(waiters, "; ")))
+			if c.locked {
+				if !c.lockWaitWarned {
+					c.Warn("the table lock is held until the run ends, so the job cannot yield; use --skip-lock-tables to let DDL through between chunks")
+					c.lockWaitWarned = true
+				}
+				return nil
+			}
+			if c.batch.pending > 0 {
+				if err := c.commitBatch(); err != nil {
+					return err
+				}
+			}
+		}
+		if c.stopRequested() {
+			// The waiters get through once the run ends
+			return nil
+		}
+		if waited >= c.Config.YieldToLockWaits {
+			c.Warn(fmt.Sprintf("sessions still waiting after yielding for %v; resuming", waited))
+			return nil
+		}
+		c.sleep(lockWaitPollInterval)
+		waited += lockWaitPollInterval
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// lockWaitDB reports waiters[i] sessions waiting on the i-th check, and none
+// after the script ends.
+type lockWaitDB struct {
+	*txDB
+	waiters []int
+	checks  int
+}
+
+func (d *lockWaitDB) MetadataLockWaiters() ([]string, error) {
+	n := 0
+	if d.checks < len(d.waiters) {
+		n = d.waiters[d.checks]
+	}
+	d.checks++
+	return make([]string, n), nil
+}
+
+func newLockWaitChunker(batchCommit int, waiters ...int) (*lockWaitDB, *Chunker, *[]time.Duration) {
+	tx, chunker := newBatchChunker(batchCommit, true)
+	db := &lockWaitDB{txDB: tx, waiters: waiters}
+	chunker.db = db
+	chunker.Config.YieldToLockWaits = 5 * time.Second
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return db, chunker, &sleeps
+}
+
+func countStatements(db *simDB, statement string) int {
+	n := 0
+	for _, s := range db.statements {
+		if s == statement {
+			n++
+		}
+	}
+	return n
+}
+
+func TestYieldToLockWaitersCommitsBatchEarly(t *testing.T) {
+	// A DDL queues behind the open batch after the second chunk and gets its
+	// lock two checks later
+	db, chunker, sleeps := newLockWaitChunker(5, 0, 1, 1, 0)
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Batches of chunks 1-2 (committed early), 3-7 and 8-10
+	if n := countStatements(db.simDB, "COMMIT"); n != 3 {
+		t.Errorf("Expected 3 commits, got %d", n)
+	}
+	if len(*sleeps) != 2 {
+		t.Errorf("Expected 2 pauses, got %v", *sleeps)
+	}
+	for _, key := range db.keys {
+		if db.committed[key] != 1 {
+			t.Errorf("Key %v committed %d times", key, db.committed[key])
+		}
+	}
+}
+
+func TestYieldToLockWaitersGivesUpAfterLimit(t *testing.T) {
+	db, chunker, sleeps := newLockWaitChunker(1, 0, 9, 9, 9, 9, 9, 9, 9, 9)
+	chunker.Config.YieldToLockWaits = 3 * time.Second
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The second chunk's check pauses three times, the next one three more
+	if len(*sleeps) != 6 {
+		t.Errorf("Expected 6 pauses, got %v", *sleeps)
+	}
+	if len(db.execs) != 10 {
+		t.Errorf("Expected all 10 chunks, got %d", len(db.execs))
+	}
+}
+
+func TestYieldToLockWaitersUnderTableLock(t *testing.T) {
+	db, chunker, sleeps := newLockWaitChunker(1, 1, 1, 1)
+	err := chunker.WithTableLock(func() error {
+		_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("Expected no pauses while holding the table lock, got %v", *sleeps)
+	}
+	if !chunker.lockWaitWarned {
+		t.Error("Expected a warning that the table lock cannot be yielded")
+	}
+	if db.checks != 10 {
+		t.Errorf("Expected a check after each chunk, got %d", db.checks)
+	}
+}
+
+func TestYieldToLockWaitersDisabled(t *testing.T) {
+	db, chunker, _ := newLockWaitChunker(1, 1, 1, 1)
+	chunker.Config.YieldToLockWaits = 0
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if db.checks != 0 {
+		t.Errorf("Expected no checks, got %d", db.checks)
+	}
+}
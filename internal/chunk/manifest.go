@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Manifest is the plan of a run: the job, its chunking key and the upper
+// boundary of every chunk, computed with ComputeBoundaries before the first
+// chunk runs. A boundary is marked done once its chunk commits. A later run
+// with the same manifest resumes at the first incomplete boundary, and a
+// finished manifest is kept as an audit of the planned against the done work.
+type Manifest struct {
+	Database   string             `json:"database"`
+	Table      string             `json:"table"`
+	Columns    string             `json:"columns"`
+	KeyType    string             `json:"key_type,omitempty"`
+	Query      string             `json:"query"`
+	ChunkSize  int                `json:"chunk_size"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Boundaries []ManifestBoundary `json:"boundaries"`
+}
+
+// ManifestBoundary is the upper boundary of one planned chunk, as SQL
+// literals like a checkpoint's.
+type ManifestBoundary struct {
+	End    []string  `json:"end"`
+	Done   bool      `json:"done"`
+	DoneAt time.Time `json:"done_at,omitzero"`
+}
+
+// LoadManifest reads a manifest file. It returns nil without error when the
+// file does not exist, meaning the run is still to be planned.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest file %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest atomically, like a checkpoint.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// Validate refuses to resume a manifest planned for another table, key or
+// statement: its boundaries would not describe this run's work.
+func (m *Manifest) Validate(config Config, query string) error {
+	if m.Database != config.Database || m.Table != config.Table {
+		return fmt.Errorf("manifest is for %s.%s, not %s.%s", m.Database, m.Table, config.Database, config.Table)
+	}
+	if !strings.EqualFold(m.Columns, config.UniqueKeyColumnNames) || (m.KeyType != "" && m.KeyType != config.UniqueKeyType) {
+		return fmt.Errorf("manifest is for key (%s), not (%s)", m.Columns, config.UniqueKeyColumnNames)
+	}
+	if m.Query != query {
+		return fmt.Errorf("manifest is for another statement: %s", m.Query)
+	}
+	for i, b := range m.Boundaries {
+		if len(b.End) != config.CountColumnsInUniqueKey {
+			return fmt.Errorf("manifest boundary %d has %d values, key has %d columns", i+1, len(b.End), config.CountColumnsInUniqueKey)
+		}
+	}
+	return nil
+}
+
+// FirstIncomplete returns the index of the first boundary not done, or the
+// number of boundaries when the plan is complete.
+func (m *Manifest) FirstIncomplete() int {
+	for i, b := range m.Boundaries {
+		if !b.Done {
+			return i
+		}
+	}
+	return len(m.Boundaries)
+}
+
+// manifestRun drives a run's chunk boundaries from its manifest.
+type manifestRun struct {
+	path     string
+	manifest *Manifest
+	// next is the index of the next boundary to run up to
+	next int
+}
+
+// startManifest plans the run into ManifestFile, or resumes the manifest
+// found there by setting the range start after its last done boundary. It
+// returns whether the first chunk includes the range start, as inclusive
+// does for a run without a manifest.
+func (c *Chunker) startManifest(query string, inclusive bool) (bool, error) {
+	switch {
+	case c.Config.CheckpointFile != "":
+		return false, fmt.Errorf("a manifest records its own progress and cannot be combined with a checkpoint file")
+	case c.Config.MinAffectedPerChunk > 0:
+		return false, fmt.Errorf("a manifest fixes the chunk boundaries, so chunks cannot be merged")
+	case c.Config.MaxChunkTime > 0:
+		return false, fmt.Errorf("a manifest fixes the chunk boundaries, so the chunk size cannot adapt to --max-chunk-time")
+	case c.Config.LockBoundaryReads:
+		return false, fmt.Errorf("a manifest fixes the chunk boundaries, so there are no boundary reads to lock")
+	}
+
+	m, err := LoadManifest(c.Config.ManifestFile)
+	if err != nil {
+		return false, err
+	}
+	if m == nil {
+		planStart := time.Now()
+		boundaries, err := c.ComputeBoundaries(inclusive)
+		if err != nil {
+			return false, err
+		}
+		m = &Manifest{
+			Database:   c.Config.Database,
+			Table:      c.Config.Table,
+			Columns:    c.Config.UniqueKeyColumnNames,
+			KeyType:    c.Config.UniqueKeyType,
+			Query:      query,
+			ChunkSize:  c.Config.ChunkSize,
+			CreatedAt:  time.Now().UTC(),
+			Boundaries: make([]ManifestBoundary, len(boundaries)),
+		}
+		for i, boundary := range boundaries {
+			end := make([]string, len(boundary))
+			for j, val := range boundary {
+				end[j] = c.keyLiteral(val)
+			}
+			m.Boundaries[i].End = end
+		}
+		if err := m.Save(c.Config.ManifestFile); err != nil {
+			return false, err
+		}
+		c.Verbose(fmt.Sprintf("Planned %d chunks into %s in %.1f seconds", len(m.Boundaries), c.Config.ManifestFile, time.Since(planStart).Seconds()))
+		c.manifest = &manifestRun{path: c.Config.ManifestFile, manifest: m}
+		return inclusive, nil
+	}
+
+	if err := m.Validate(c.Config, query); err != nil {
+		return false, err
+	}
+	run := &manifestRun{path: c.Config.ManifestFile, manifest: m, next: m.FirstIncomplete()}
+	c.manifest = run
+	if run.next == 0 {
+		return inclusive, nil
+	}
+	done := m.Boundaries[run.next-1].End
+	if _, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(done, ","), c.getUniqueKeyRangeStartVariables())); err != nil {
+		return false, err
+	}
+	c.Verbose(fmt.Sprintf("Resuming manifest %s after boundary (%s), %d of %d chunks done", run.path, strings.Join(done, ","), run.next, len(m.Boundaries)))
+	c.resumedFrom(done)
+	return false, nil
+}
+
+// manifestBoundary sets the range end to the next planned boundary, and
+// returns sql.ErrNoRows once the plan is exhausted.
+func (c *Chunker) manifestBoundary() error {
+	run := c.manifest
+	if run.next >= len(run.manifest.Boundaries) {
+		return sql.ErrNoRows
+	}
+	end := run.manifest.Boundaries[run.next].End
+	run.next++
+	_, err := c.state().Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(end, ","), c.getUniqueKeyRangeEndVariables()))
+	return err
+}
+
+// markManifest marks the planned boundaries up to the committed end done.
+func (c *Chunker) markManifest(end []interface{}) error {
+	if c.manifest == nil {
+		return nil
+	}
+	literals := make([]string, len(end))
+	for i, val := range end {
+		literals[i] = c.keyLiteral(val)
+	}
+	m := c.manifest.manifest
+	for i := m.FirstIncomplete(); i < c.manifest.next; i++ {
+		if strings.Join(m.Boundaries[i].End, ",") != strings.Join(literals, ",") {
+			continue
+		}
+		now := time.Now().UTC()
+		for j := m.FirstIncomplete(); j <= i; j++ {
+			m.Boundaries[j].Done, m.Boundaries[j].DoneAt = true, now
+		}
+		return m.Save(c.manifest.path)
+	}
+	return fmt.Errorf("committed boundary (%s) is not in manifest %s", strings.Join(literals, ","), c.manifest.path)
+}
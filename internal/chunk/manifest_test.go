@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newManifestChunker(db *simDB, path string) *Chunker {
+	chunker := newSimChunker(db, 10)
+	chunker.Config.ManifestFile = path
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	return chunker
+}
+
+func manifestEnds(m *Manifest) (ends []string, done int) {
+	for _, b := range m.Boundaries {
+		ends = append(ends, strings.Join(b.End, ","))
+		if b.Done {
+			done++
+		}
+	}
+	return ends, done
+}
+
+func TestManifestPlansAndMarksBoundaries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.manifest")
+	db := newSimDB(seqKeys(1, 45))
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	summary, err := newManifestChunker(db, path).ChunkUpdate(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Chunks != 5 || summary.Reason != ReasonCompleted {
+		t.Errorf("Expected 5 chunks of a completed run, got %d, reason %s", summary.Chunks, summary.Reason)
+	}
+	checkTouchedOnce(t, db)
+
+	m, err := LoadManifest(path)
+	if err != nil || m == nil {
+		t.Fatalf("Expected the manifest to be kept, got %v, %v", m, err)
+	}
+	ends, done := manifestEnds(m)
+	if strings.Join(ends, " ") != "10 20 30 40 45" || done != 5 {
+		t.Errorf("Expected boundaries 10 20 30 40 45 all done, got %v with %d done", ends, done)
+	}
+	if m.Query != query || m.Table != "t" || m.Columns != "id" || m.ChunkSize != 10 {
+		t.Errorf("Unexpected job parameters in manifest: %+v", m)
+	}
+	for _, b := range m.Boundaries {
+		if b.DoneAt.IsZero() {
+			t.Errorf("Expected boundary %v to record when it was done", b.End)
+		}
+	}
+}
+
+func TestResumeFromManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.manifest")
+	db := newSimDB(seqKeys(1, 50))
+	db.failAt = 3
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	if _, err := newManifestChunker(db, path).ChunkUpdate(context.Background(), query); err == nil {
+		t.Fatal("Expected the third chunk to fail")
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ends, done := manifestEnds(m); len(ends) != 5 || done != 2 {
+		t.Fatalf("Expected 2 of 5 boundaries done, got %d of %v", done, ends)
+	}
+
+	db.failAt = 0
+	db.queries = nil
+	summary, err := newManifestChunker(db, path).ChunkUpdate(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Chunks != 3 || summary.Reason != ReasonCompleted {
+		t.Errorf("Expected the 3 remaining chunks to complete, got %d, reason %s", summary.Chunks, summary.Reason)
+	}
+	checkTouchedOnce(t, db)
+	for _, q := range db.queries {
+		if strings.Contains(q, "LIMIT") {
+			t.Errorf("Expected the resumed run to follow the manifest without boundary queries, got %s", q)
+		}
+	}
+	m, _ = LoadManifest(path)
+	if _, done := manifestEnds(m); done != 5 {
+		t.Errorf("Expected all boundaries done, got %d", done)
+	}
+}
+
+func TestManifestRefusesAnotherJob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.manifest")
+	db := newSimDB(seqKeys(1, 50))
+	db.failAt = 2
+	newManifestChunker(db, path).ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+
+	db.failAt = 0
+	_, err := newManifestChunker(db, path).ChunkUpdate(context.Background(), "UPDATE t SET x=2 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "another statement") {
+		t.Errorf("Expected a manifest for another statement to be refused, got %v", err)
+	}
+
+	chunker := newManifestChunker(db, path)
+	chunker.Config.CheckpointFile = filepath.Join(t.TempDir(), "job.ckpt")
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil || !strings.Contains(err.Error(), "checkpoint") {
+		t.Errorf("Expected a manifest with a checkpoint file to be refused, got %v", err)
+	}
+}
+
+func TestManifestMarksBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.manifest")
+	db := newSimDB(seqKeys(1, 50))
+	db.failAt = 4
+	chunker := newManifestChunker(db, path)
+	chunker.Config.BatchCommit = 2
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Fatal("Expected the fourth chunk to fail")
+	}
+	m, _ := LoadManifest(path)
+	// The first batch committed; the failed chunk was rolled back with the
+	// third, which shared its batch
+	if _, done := manifestEnds(m); done != 2 {
+		t.Errorf("Expected the first batch's 2 boundaries done, got %d", done)
+	}
+}
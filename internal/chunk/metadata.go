@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import "sync"
+
+// MetadataDB reports the table metadata a run looks up before chunking.
+type MetadataDB interface {
+	GetPossibleUniqueKeyColumns(database, table string) ([]map[string]interface{}, error)
+	TableEngine(database, table string) (string, error)
+}
+
+// MetadataCache remembers the unique key candidates, with their column types,
+// and the storage engine of each database.table it is asked about, so that
+// information_schema is queried once per table however many times the run
+// needs them. It is safe for concurrent use and satisfies MetadataDB itself.
+type MetadataCache struct {
+	db     MetadataDB
+	mu     sync.Mutex
+	tables map[string]*tableMetadata
+}
+
+type tableMetadata struct {
+	keys   []map[string]interface{}
+	engine *string
+}
+
+// NewMetadataCache returns an empty cache that fetches through db.
+func NewMetadataCache(db MetadataDB) *MetadataCache {
+	return &MetadataCache{db: db, tables: map[string]*tableMetadata{}}
+}
+
+func (m *MetadataCache) table(database, table string) *tableMetadata {
+	key := database + "." + table
+	t, ok := m.tables[key]
+	if !ok {
+		t = &tableMetadata{}
+		m.tables[key] = t
+	}
+	return t
+}
+
+// GetPossibleUniqueKeyColumns returns the cached unique key rows of
+// database.table, fetching them on first use. Failed lookups are not cached.
+func (m *MetadataCache) GetPossibleUniqueKeyColumns(database, table string) ([]map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(database, table)
+	if t.keys == nil {
+		rows, err := m.db.GetPossibleUniqueKeyColumns(database, table)
+		if err != nil {
+			return nil, err
+		}
+		if rows == nil {
+			rows = []map[string]interface{}{}
+		}
+		t.keys = rows
+	}
+	return t.keys, nil
+}
+
+// TableEngine returns the cached storage engine of database.table, fetching
+// it on first use. Failed lookups are not cached.
+func (m *MetadataCache) TableEngine(database, table string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(database, table)
+	if t.engine == nil {
+		engine, err := m.db.TableEngine(database, table)
+		if err != nil {
+			return "", err
+		}
+		t.engine = &engine
+	}
+	return *t.engine, nil
+}
+
+// Invalidate drops everything cached for database.table, so the next lookup
+// reads it from the server again. The chunker calls it when a checkpoint's
+// key fingerprint no longer matches, since the table was altered since.
+func (m *MetadataCache) Invalidate(database, table string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tables, database+"."+table)
+}
+
+// SetMetadataCache routes the chunker's unique key lookups through cache.
+func (c *Chunker) SetMetadataCache(cache *MetadataCache) {
+	c.metadata = cache
+}
+
+// uniqueKeyColumns returns the unique key rows of the configured table, from
+// the metadata cache when one is set.
+func (c *Chunker) uniqueKeyColumns() ([]map[string]interface{}, error) {
+	if c.metadata != nil {
+		return c.metadata.GetPossibleUniqueKeyColumns(c.Config.Database, c.Config.Table)
+	}
+	return c.db.GetPossibleUniqueKeyColumns(c.Config.Database, c.Config.Table)
+}
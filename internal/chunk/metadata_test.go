@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingMetadataDB counts metadata fetches per database.table.
+type countingMetadataDB struct {
+	MockDB
+	keyFetches    map[string]int
+	engineFetches map[string]int
+}
+
+func newCountingMetadataDB() *countingMetadataDB {
+	return &countingMetadataDB{keyFetches: map[string]int{}, engineFetches: map[string]int{}}
+}
+
+func (m *countingMetadataDB) GetPossibleUniqueKeyColumns(database, table string) ([]map[string]interface{}, error) {
+	m.keyFetches[database+"."+table]++
+	return m.MockDB.GetPossibleUniqueKeyColumns(database, table)
+}
+
+func (m *countingMetadataDB) TableEngine(database, table string) (string, error) {
+	m.engineFetches[database+"."+table]++
+	return "InnoDB", nil
+}
+
+func TestMetadataCacheFetchesOncePerTable(t *testing.T) {
+	db := newCountingMetadataDB()
+	cache := NewMetadataCache(db)
+	for _, table := range []string{"a", "b", "a", "b", "a"} {
+		c := NewChunker(db, Config{Database: "test", Table: table})
+		c.SetMetadataCache(cache)
+		if _, _, _, err := c.GetSelectedUniqueKeyColumnNames(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.UniqueKeyCandidates(); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckTransactionalEngine(cache, "test", table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range []string{"test.a", "test.b"} {
+		if db.keyFetches[table] != 1 || db.engineFetches[table] != 1 {
+			t.Errorf("%s: fetched keys %d times and engine %d times, want once each", table, db.keyFetches[table], db.engineFetches[table])
+		}
+	}
+
+	cache.Invalidate("test", "a")
+	cache.GetPossibleUniqueKeyColumns("test", "a")
+	cache.GetPossibleUniqueKeyColumns("test", "b")
+	if db.keyFetches["test.a"] != 2 || db.keyFetches["test.b"] != 1 {
+		t.Errorf("after invalidating test.a, fetches = %v, want test.a refetched only", db.keyFetches)
+	}
+}
+
+func TestMetadataCacheInvalidatedOnKeyMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := os.WriteFile(path, []byte(`{"database":"test","table":"t","columns":"code","key_type":"text","boundary":["x"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := newSimDB(seqKeys(1, 10))
+	counting := newCountingMetadataDB()
+	cache := NewMetadataCache(counting)
+	cache.GetPossibleUniqueKeyColumns("test", "t")
+
+	c := newSimChunker(db, 5)
+	c.Config.CheckpointFile = path
+	c.SetMetadataCache(cache)
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE test.t SET x = 1"); err == nil {
+		t.Fatal("expected the checkpoint key mismatch to fail the run")
+	}
+	cache.GetPossibleUniqueKeyColumns("test", "t")
+	if counting.keyFetches["test.t"] != 2 {
+		t.Errorf("fetched keys %d times, want a refetch after the mismatch", counting.keyFetches["test.t"])
+	}
+}
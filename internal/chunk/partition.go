@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// PartitionDB is a DBInterface that can enumerate a table's partitions.
+type PartitionDB interface {
+	DBInterface
+	ListPartitions(database, table string) ([]string, error)
+}
+
+// ListPartitions returns the partitions of database.table in partition order,
+// or an error when the table is not partitioned.
+func ListPartitions(db PartitionDB, database, table string) ([]string, error) {
+	partitions, err := db.ListPartitions(database, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("table %s.%s is not partitioned", database, table)
+	}
+	return partitions, nil
+}
+
+// tableRef returns the table reference for range and boundary queries,
+// restricted to Config.Partition when one is set.
+func (c *Chunker) tableRef() string {
+	ref := c.Config.Database + "." + c.Config.Table
+	if c.Config.Partition != "" {
+		ref += " PARTITION (" + QuoteIdentifier(c.Config.Partition) + ")"
+	}
+	return ref
+}
+
+// ScopeToPartition adds a PARTITION clause to the first reference to the
+// chunked table that follows UPDATE, FROM or JOIN in executeQuery. The key
+// ranges of one partition can overlap rows of other partitions, so the
+// statement itself must be restricted, not only the boundary queries.
+func ScopeToPartition(executeQuery, database, table, partition string) (string, error) {
+	name := "`?" + regexp.QuoteMeta(table) + "`?"
+	qualified := "(?:`?" + regexp.QuoteMeta(database) + "`?\\.)?" + name
+	re := regexp.MustCompile(`(?i)\b(?:UPDATE|FROM|JOIN)\s+(` + qualified + `)(?:[\s;)]|$)`)
+	loc := re.FindStringSubmatchIndex(executeQuery)
+	if loc == nil {
+		return "", fmt.Errorf("cannot find %s after UPDATE, FROM or JOIN in the execute statement to scope it to partition %s", table, partition)
+	}
+	end := loc[3]
+	return executeQuery[:end] + " PARTITION (" + QuoteIdentifier(partition) + ")" + executeQuery[end:], nil
+}
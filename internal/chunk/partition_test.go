@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type partitionMockDB struct {
+	MockDB
+	partitions []string
+}
+
+func (m *partitionMockDB) ListPartitions(database, table string) ([]string, error) {
+	return m.partitions, nil
+}
+
+func TestListPartitions(t *testing.T) {
+	partitions, err := ListPartitions(&partitionMockDB{partitions: []string{"p2024", "p2025"}}, "test", "t")
+	if err != nil || strings.Join(partitions, ",") != "p2024,p2025" {
+		t.Errorf("Unexpected partitions %v, %v", partitions, err)
+	}
+	if _, err := ListPartitions(&partitionMockDB{}, "test", "t"); err == nil || !strings.Contains(err.Error(), "not partitioned") {
+		t.Errorf("Expected not partitioned error, got %v", err)
+	}
+}
+
+func TestScopeToPartition(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"UPDATE t SET x=1 WHERE GO_CHUNK(t)",
+			"UPDATE t PARTITION (`p1`) SET x=1 WHERE GO_CHUNK(t)",
+		},
+		{
+			"update test.t AS a SET x=1 WHERE GO_CHUNK(t)",
+			"update test.t PARTITION (`p1`) AS a SET x=1 WHERE GO_CHUNK(t)",
+		},
+		{
+			"DELETE FROM `t` WHERE GO_CHUNK(t) AND created < '2020-01-01'",
+			"DELETE FROM `t` PARTITION (`p1`) WHERE GO_CHUNK(t) AND created < '2020-01-01'",
+		},
+		{
+			"INSERT INTO t_archive SELECT * FROM t WHERE GO_CHUNK(t)",
+			"INSERT INTO t_archive SELECT * FROM t PARTITION (`p1`) WHERE GO_CHUNK(t)",
+		},
+		{
+			"UPDATE other o JOIN t ON t.id = o.t_id SET o.x=1 WHERE GO_CHUNK(t)",
+			"UPDATE other o JOIN t PARTITION (`p1`) ON t.id = o.t_id SET o.x=1 WHERE GO_CHUNK(t)",
+		},
+	}
+	for _, tt := range tests {
+		got, err := ScopeToPartition(tt.query, "test", "t", "p1")
+		if err != nil {
+			t.Errorf("ScopeToPartition(%q): unexpected error: %v", tt.query, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ScopeToPartition(%q):\n got  %s\n want %s", tt.query, got, tt.expected)
+		}
+	}
+
+	if _, err := ScopeToPartition("UPDATE t2 SET x=1 WHERE GO_CHUNK(t)", "test", "t", "p1"); err == nil {
+		t.Error("Expected error when the chunked table is not referenced")
+	}
+}
+
+func TestChunkUpdatePerPartition(t *testing.T) {
+	// Hash-style partitioning interleaves keys, so each partition's key
+	// range covers rows of the other partition
+	db := newSimDB(seqKeys(1, 40))
+	db.partitionOf = func(key interface{}) string {
+		if key.(int64)%2 == 0 {
+			return "p_even"
+		}
+		return "p_odd"
+	}
+	chunker := newSimChunker(db, 5)
+
+	for _, partition := range []string{"p_odd", "p_even"} {
+		var first, last interface{}
+		for _, key := range db.keys {
+			if db.partitionOf(key) == partition {
+				if first == nil {
+					first = key
+				}
+				last = key
+			}
+		}
+		db.vars["unique_key_min_value_0"] = first
+		db.vars["unique_key_max_value_0"] = last
+
+		chunker.Config.Partition = partition
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("Partition %s: unexpected error: %v", partition, err)
+		}
+	}
+
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %d processed %d times", key, db.touched[key])
+		}
+	}
+	for _, stmt := range db.execs {
+		if !strings.HasPrefix(stmt, "UPDATE t PARTITION (`p_") {
+			t.Errorf("Expected update scoped to a partition, got %s", stmt)
+		}
+	}
+	for _, query := range db.queries {
+		if strings.Contains(query, "LIMIT") && !strings.Contains(query, "FROM test.t PARTITION (`p_") {
+			t.Errorf("Expected boundary query scoped to a partition, got %s", query)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"os"
+)
+
+// dumpPlan writes the optimizer plan of query, the first chunk's statement,
+// to DumpPlanFile. The range variables are set, so the plan is the one the
+// server uses for real chunks rather than for a template. It runs once per
+// Chunker, so a run split into partitions keeps the first partition's plan.
+// A failure is a warning: the plan is a diagnostic, not a precondition.
+func (c *Chunker) dumpPlan(query string) {
+	if c.Config.DumpPlanFile == "" || c.planDumped {
+		return
+	}
+	c.planDumped = true
+	row, err := c.db.QueryRow(c.ctx, "EXPLAIN FORMAT=JSON "+query)
+	if err != nil {
+		c.Warn(fmt.Sprintf("cannot explain the first chunk: %v", err))
+		return
+	}
+	plan := fmt.Sprintf("%v\n", row["EXPLAIN"])
+	if err := os.WriteFile(c.Config.DumpPlanFile, []byte(plan), 0644); err != nil {
+		c.Warn(fmt.Sprintf("cannot write the plan: %v", err))
+		return
+	}
+	c.Verbose(fmt.Sprintf("Wrote the plan of the first chunk to %s", c.Config.DumpPlanFile))
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpPlanOnce(t *testing.T) {
+	db := newSimDB(seqKeys(1, 50))
+	c := newSimChunker(db, 10)
+	c.Config.DumpPlanFile = filepath.Join(t.TempDir(), "plan.json")
+	c.SetReporter(&recordingReporter{})
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+	explains := 0
+	for _, q := range db.queries {
+		if strings.HasPrefix(q, "EXPLAIN") {
+			explains++
+		}
+	}
+	if explains != 1 {
+		t.Errorf("explained %d chunks, want the first one only", explains)
+	}
+	plan, err := os.ReadFile(c.Config.DumpPlanFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first chunk includes its lower bound
+	if want := `{"statement": "UPDATE t SET x=1 WHERE id >= @unique_key_range_start_0 AND id <= @unique_key_range_end_0"}` + "\n"; string(plan) != want {
+		t.Errorf("plan = %q, want %q", plan, want)
+	}
+
+	// A second run on the same Chunker, as for the next partition, keeps it
+	if err := os.Remove(c.Config.DumpPlanFile); err != nil {
+		t.Fatal(err)
+	}
+	db.seed()
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.Config.DumpPlanFile); !os.IsNotExist(err) {
+		t.Errorf("plan was written again on a second run: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"regexp"
+	"strings"
+)
+
+// IndexDB lists a table's indexed columns.
+type IndexDB interface {
+	IndexedColumns(database, table string) ([]string, error)
+}
+
+var (
+	whereRe    = regexp.MustCompile(`(?is)\bWHERE\b(.*?)(?:\bGROUP\s+BY\b|\bORDER\s+BY\b|\bLIMIT\b|$)`)
+	comparedRe = regexp.MustCompile("(?i)((?:`[^`]+`|[\\w$]+)(?:\\.(?:`[^`]+`|[\\w$]+)){0,2})\\s*(?:<=>|<>|!=|<=|>=|=|<|>|\\bNOT\\s+IN\\b|\\bIN\\b|\\bNOT\\s+LIKE\\b|\\bLIKE\\b|\\bNOT\\s+BETWEEN\\b|\\bBETWEEN\\b|\\bIS\\b|\\bREGEXP\\b)")
+	numberRe   = regexp.MustCompile(`^[0-9.]+$`)
+)
+
+// predicateKeywords can stand left of an operator without being a column.
+var predicateKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "null": true, "true": true, "false": true, "where": true,
+}
+
+// PredicateColumns returns the columns query compares in its WHERE clauses,
+// besides the GO_CHUNK predicate, as a best-effort reading of the SQL: it
+// finds column = value, column IN (...), column LIKE ... and the like, not
+// columns wrapped in functions. Columns qualified with anything but table or
+// database.table, e.g. the alias of a joined table, are left out, as they may
+// belong to another table. Names are lowercased and unquoted.
+func PredicateColumns(query, database, table string) []string {
+	unquoted := chunkTokenRe.ReplaceAllString(blankQuoted(query), "TRUE")
+
+	var columns []string
+	seen := map[string]bool{}
+	for _, where := range whereRe.FindAllStringSubmatch(unquoted, -1) {
+		for _, m := range comparedRe.FindAllStringSubmatch(where[1], -1) {
+			parts := strings.Split(strings.ToLower(m[1]), ".")
+			for i := range parts {
+				parts[i] = strings.Trim(parts[i], "`")
+			}
+			column := parts[len(parts)-1]
+			qualifier := strings.Join(parts[:len(parts)-1], ".")
+			if qualifier != "" && qualifier != strings.ToLower(table) && qualifier != strings.ToLower(database+"."+table) {
+				continue
+			}
+			if predicateKeywords[column] || numberRe.MatchString(column) || seen[column] {
+				continue
+			}
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// UnindexedPredicateColumns returns the columns query filters database.table
+// on that lead no index and are not part of the chunking key, keyColumns.
+// Each chunk still reads at most its key range, but a filter on such a
+// column cannot narrow that range, and in a join or subquery it may scan far
+// more rows than the chunk holds.
+func UnindexedPredicateColumns(db IndexDB, database, table, query string, keyColumns []string) ([]string, error) {
+	indexed, err := db.IndexedColumns(database, table)
+	if err != nil {
+		return nil, err
+	}
+	covered := map[string]bool{}
+	for _, col := range append(indexed, keyColumns...) {
+		covered[strings.ToLower(col)] = true
+	}
+	var unindexed []string
+	for _, col := range PredicateColumns(query, database, table) {
+		if !covered[col] {
+			unindexed = append(unindexed, col)
+		}
+	}
+	return unindexed, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"reflect"
+	"testing"
+)
+
+type indexMockDB struct {
+	indexed []string
+}
+
+func (m *indexMockDB) IndexedColumns(database, table string) ([]string, error) {
+	return m.indexed, nil
+}
+
+func TestPredicateColumns(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"UPDATE t SET a = 1 WHERE GO_CHUNK(t)", nil},
+		{"UPDATE t SET a = 1 WHERE GO_CHUNK(t) AND status = 'x = y' AND `Created_At` < NOW()", []string{"status", "created_at"}},
+		{"DELETE FROM t WHERE GO_CHUNK(t) AND t.kind IN (1, 2) AND name NOT LIKE 'a%' AND deleted_at IS NULL", []string{"kind", "name", "deleted_at"}},
+		{"UPDATE t JOIN u ON u.id = t.uid SET t.a = 1 WHERE GO_CHUNK(t) AND u.flag = 1 AND test.t.owner = 2", []string{"owner"}},
+		{"DELETE FROM t WHERE GO_CHUNK(t) AND status = 1 ORDER BY score = 2", []string{"status"}},
+	} {
+		if got := PredicateColumns(tc.query, "test", "t"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestUnindexedPredicateColumns(t *testing.T) {
+	db := &indexMockDB{indexed: []string{"id", "Status"}}
+	query := "UPDATE t SET a = 1 WHERE GO_CHUNK(t) AND status = 2 AND id > 5 AND note = ''"
+	got, err := UnindexedPredicateColumns(db, "test", "t", query, []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"note"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	db.indexed = []string{"note", "status"}
+	got, err = UnindexedPredicateColumns(db, "test", "t", query, []string{"id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("indexed and key columns reported as unindexed: %v", got)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ProbeDB is the connection surface needed to validate a target without
+// running any mutation.
+type ProbeDB interface {
+	DBInterface
+	ServerVersion() (string, error)
+	CurrentGrants() ([]string, error)
+}
+
+// probePrivileges are the table privileges a chunk run relies on.
+var probePrivileges = []string{"SELECT", "UPDATE", "LOCK TABLES"}
+
+// ProbeResult reports what Probe found about a target table.
+type ProbeResult struct {
+	Database      string
+	Table         string
+	ServerVersion string
+	TableExists   bool
+	Privileges    map[string]bool
+	UniqueKey     string
+	UniqueKeyType string
+	KeyError      error
+}
+
+// Probe changes nothing: it reports the server version, whether the table
+// exists, which required privileges the account holds on it, and which unique
+// key the chunker would select.
+func Probe(db ProbeDB, config Config) (*ProbeResult, error) {
+	result := &ProbeResult{
+		Database:   config.Database,
+		Table:      config.Table,
+		Privileges: map[string]bool{},
+	}
+
+	version, err := db.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	result.ServerVersion = version
+
+	result.TableExists, err = db.TableExists(config.Database, config.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := db.CurrentGrants()
+	if err != nil {
+		return nil, err
+	}
+	for _, grant := range grants {
+		for _, priv := range grantedTablePrivileges(grant, config.Database, config.Table) {
+			result.Privileges[priv] = true
+		}
+	}
+
+	if result.TableExists {
+		chunker := NewChunker(db, config)
+		key, _, keyType, err := chunker.GetSelectedUniqueKeyColumnNames()
+		if err != nil {
+			result.KeyError = err
+		} else if key == "" {
+			result.KeyError = fmt.Errorf("no unique key found")
+		}
+		result.UniqueKey = key
+		result.UniqueKeyType = keyType
+	}
+
+	return result, nil
+}
+
+// OK reports whether a chunk run against the probed table can proceed.
+func (r *ProbeResult) OK() bool {
+	if !r.TableExists || r.KeyError != nil {
+		return false
+	}
+	for _, priv := range probePrivileges {
+		if !r.Privileges[priv] {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes the probe report in the tool's "-- " comment style.
+func (r *ProbeResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "-- Server version: %s\n", r.ServerVersion)
+	if r.TableExists {
+		fmt.Fprintf(w, "-- Table %s.%s: exists\n", r.Database, r.Table)
+	} else {
+		fmt.Fprintf(w, "-- Table %s.%s: does not exist\n", r.Database, r.Table)
+	}
+	for _, priv := range probePrivileges {
+		status := "missing"
+		if r.Privileges[priv] {
+			status = "granted"
+		}
+		fmt.Fprintf(w, "-- Privilege %s: %s\n", priv, status)
+	}
+	switch {
+	case !r.TableExists:
+	case r.KeyError != nil:
+		fmt.Fprintf(w, "-- Chunking key: %v\n", r.KeyError)
+	case r.UniqueKeyType != "":
+		fmt.Fprintf(w, "-- Chunking key: %s (%s)\n", r.UniqueKey, r.UniqueKeyType)
+	default:
+		fmt.Fprintf(w, "-- Chunking key: %s\n", r.UniqueKey)
+	}
+	if r.OK() {
+		fmt.Fprintf(w, "-- Probe passed\n")
+	} else {
+		fmt.Fprintf(w, "-- Probe failed\n")
+	}
+}
+
+// grantIdent matches one part of a grant's object: a backtick-quoted
+// identifier, in which a doubled backtick stands for one, or a bare one.
+const grantIdent = "`(?:[^`]|``)*`|[^\\s.`]+"
+
+var (
+	grantRe      = regexp.MustCompile("^GRANT (.+?) ON (" + grantIdent + `)(?:\.(` + grantIdent + `))? TO `)
+	columnPrivRe = regexp.MustCompile(`\w+ \([^)]*\)(, )?`)
+)
+
+// unquoteGrantIdent strips the backticks around a grant identifier.
+func unquoteGrantIdent(ident string) string {
+	if len(ident) < 2 || ident[0] != '`' || ident[len(ident)-1] != '`' {
+		return ident
+	}
+	return strings.ReplaceAll(ident[1:len(ident)-1], "``", "`")
+}
+
+// matchGrantDatabase matches a database name against a grant's database part,
+// where unescaped % and _ are LIKE wildcards.
+func matchGrantDatabase(pattern, database string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; {
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case ch == '%':
+			expr.WriteString(".*")
+		case ch == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("$")
+	matched, _ := regexp.MatchString(expr.String(), database)
+	return matched
+}
+
+// grantedTablePrivileges returns the privileges a SHOW GRANTS line confers on
+// database.table. Column-level grants are not counted, so the probe errs on
+// the side of reporting a privilege missing.
+func grantedTablePrivileges(grant, database, table string) []string {
+	m := grantRe.FindStringSubmatch(grant)
+	if m == nil {
+		return nil
+	}
+	dbPart, tablePart := unquoteGrantIdent(m[2]), "*"
+	if m[3] != "" {
+		tablePart = unquoteGrantIdent(m[3])
+	}
+
+	if dbPart != "*" && !matchGrantDatabase(dbPart, database) {
+		return nil
+	}
+	if tablePart != "*" && tablePart != table {
+		return nil
+	}
+
+	privs := columnPrivRe.ReplaceAllString(m[1], "")
+	var granted []string
+	for _, priv := range strings.Split(privs, ",") {
+		priv = strings.TrimSpace(priv)
+		if priv == "ALL" || priv == "ALL PRIVILEGES" {
+			return probePrivileges
+		}
+		if priv != "" {
+			granted = append(granted, priv)
+		}
+	}
+	return granted
+}
@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProbeReport(t *testing.T) {
+	db := &MockDB{
+		version: "8.0.36",
+		grants: []string{
+			"GRANT USAGE ON *.* TO `app`@`%`",
+			"GRANT SELECT, UPDATE, LOCK TABLES ON `shop`.* TO `app`@`%`",
+		},
+	}
+	result, err := Probe(db, Config{Database: "shop", Table: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() {
+		t.Errorf("Expected probe to pass, got %+v", result)
+	}
+
+	var out bytes.Buffer
+	result.Print(&out)
+	for _, line := range []string{
+		"-- Server version: 8.0.36",
+		"-- Table shop.orders: exists",
+		"-- Privilege SELECT: granted",
+		"-- Privilege UPDATE: granted",
+		"-- Privilege LOCK TABLES: granted",
+		"-- Chunking key: id (integer)",
+		"-- Probe passed",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestProbeMissingPrivilege(t *testing.T) {
+	db := &MockDB{
+		version: "8.0.36",
+		grants:  []string{"GRANT SELECT ON `shop`.`orders` TO `ro`@`%`"},
+	}
+	result, err := Probe(db, Config{Database: "shop", Table: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() {
+		t.Error("Expected probe to fail without UPDATE")
+	}
+	var out bytes.Buffer
+	result.Print(&out)
+	if !strings.Contains(out.String(), "-- Privilege UPDATE: missing") || !strings.Contains(out.String(), "-- Probe failed") {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+}
+
+func TestProbeMissingTable(t *testing.T) {
+	db := &MockDB{version: "8.0.36", grants: []string{"GRANT ALL PRIVILEGES ON *.* TO `root`@`localhost`"}, tableMissing: true}
+	result, err := Probe(db, Config{Database: "shop", Table: "nope"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() {
+		t.Error("Expected probe to fail for a missing table")
+	}
+}
+
+func TestGrantedTablePrivileges(t *testing.T) {
+	tests := []struct {
+		grant    string
+		expected string
+	}{
+		{"GRANT ALL PRIVILEGES ON *.* TO `root`@`localhost` WITH GRANT OPTION", "SELECT,UPDATE,LOCK TABLES"},
+		{"GRANT SELECT, UPDATE ON `shop`.* TO `app`@`%`", "SELECT,UPDATE"},
+		{"GRANT SELECT ON `shop`.`orders` TO `app`@`%`", "SELECT"},
+		{"GRANT SELECT ON `shop`.`customers` TO `app`@`%`", ""},
+		{"GRANT SELECT ON `other`.* TO `app`@`%`", ""},
+		{"GRANT SELECT ON `sh_p`.* TO `app`@`%`", "SELECT"},
+		{"GRANT SELECT ON `sh\\_p`.* TO `app`@`%`", ""},
+		{"GRANT SELECT (id, name), UPDATE ON `shop`.`orders` TO `app`@`%`", "UPDATE"},
+		{"GRANT `reader`@`%` TO `app`@`%`", ""},
+	}
+	for _, tt := range tests {
+		got := strings.Join(grantedTablePrivileges(tt.grant, "shop", "orders"), ",")
+		if got != tt.expected {
+			t.Errorf("grantedTablePrivileges(%s) = %q, expected %q", tt.grant, got, tt.expected)
+		}
+	}
+}
+
+func TestGrantedTablePrivilegesQuotedNames(t *testing.T) {
+	tests := []struct {
+		grant    string
+		database string
+		table    string
+		expected string
+	}{
+		{"GRANT SELECT ON `my shop`.* TO `app`@`%`", "my shop", "orders", "SELECT"},
+		{"GRANT SELECT ON `my shop`.`old orders` TO `app`@`%`", "my shop", "old orders", "SELECT"},
+		{"GRANT SELECT ON `shop`.`a.b` TO `app`@`%`", "shop", "a.b", "SELECT"},
+		{"GRANT SELECT ON `shop`.`a.b` TO `app`@`%`", "shop", "b", ""},
+		{"GRANT SELECT ON `shop.a`.`b` TO `app`@`%`", "shop", "a.b", ""},
+		{"GRANT UPDATE ON `sh``op`.* TO `app`@`%`", "sh`op", "orders", "UPDATE"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(grantedTablePrivileges(tt.grant, tt.database, tt.table), ",")
+		if got != tt.expected {
+			t.Errorf("grantedTablePrivileges(%s) on %s.%s = %q, expected %q", tt.grant, tt.database, tt.table, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"time"
+)
+
+// replicaPollInterval is how often lagging replicas are polled while a run
+// is paused for --max-replica-lag.
+const replicaPollInterval = time.Second
+
+// ReplicaLagger is implemented by replica connections that can report how far
+// their replication is behind. running is false when replication is stopped.
+type ReplicaLagger interface {
+	ReplicaLag() (lag time.Duration, running bool, err error)
+}
+
+// Replica is a named replica whose lag throttles a run.
+type Replica struct {
+	Name string
+	DB   ReplicaLagger
+}
+
+// replicaStatus is the result of polling one replica.
+type replicaStatus struct {
+	name    string
+	lag     time.Duration
+	running bool
+	err     error
+}
+
+// unknown reports whether the replica's lag could not be determined.
+func (s replicaStatus) unknown() bool {
+	return s.err != nil || !s.running
+}
+
+// behind reports whether s is further behind than other.
+func (s replicaStatus) behind(other replicaStatus) bool {
+	if s.unknown() != other.unknown() {
+		return s.unknown()
+	}
+	return s.lag > other.lag
+}
+
+func (s replicaStatus) String() string {
+	switch {
+	case s.err != nil:
+		return fmt.Sprintf("cannot read the lag of replica %s: %v", s.name, s.err)
+	case !s.running:
+		return fmt.Sprintf("replica %s is not replicating", s.name)
+	}
+	return fmt.Sprintf("replica %s is %v behind", s.name, s.lag)
+}
+
+// worstReplica polls every replica and returns the one furthest behind. A
+// replica whose lag is unknown, because replication is stopped or it cannot
+// be polled, counts as further behind than any other.
+func worstReplica(replicas []Replica) replicaStatus {
+	var worst replicaStatus
+	for i, replica := range replicas {
+		lag, running, err := replica.DB.ReplicaLag()
+		status := replicaStatus{name: replica.Name, lag: lag, running: running, err: err}
+		if i == 0 || status.behind(worst) {
+			worst = status
+		}
+	}
+	return worst
+}
+
+// SetReplicas sets the replicas whose lag is checked against MaxReplicaLag
+// before the first chunk and between chunks.
+func (c *Chunker) SetReplicas(replicas []Replica) {
+	c.replicas = replicas
+}
+
+// waitForReplicas pauses the run until no replica is more than MaxReplicaLag
+// behind. The replica that caused the pause is reported when it starts, and
+// again whenever a different one holds it.
+func (c *Chunker) waitForReplicas() {
+	if len(c.replicas) == 0 || c.Config.MaxReplicaLag <= 0 {
+		return
+	}
+	var paused time.Duration
+	reported := ""
+	for {
+		worst := worstReplica(c.replicas)
+		if !worst.unknown() && worst.lag <= c.Config.MaxReplicaLag {
+			if paused > 0 {
+				c.Verbose(fmt.Sprintf("Replicas within --max-replica-lag %v after pausing %v; resuming", c.Config.MaxReplicaLag, paused))
+			}
+			return
+		}
+		if worst.name != reported {
+			if worst.unknown() {
+				// Its lag is unknown, not over the limit: the run waits for
+				// replication to be running again rather than ignore it
+				c.Warn(fmt.Sprintf("%s, so its lag cannot be held under --max-replica-lag %v; pausing until it replicates again", worst, c.Config.MaxReplicaLag))
+			} else {
+				c.Warn(fmt.Sprintf("%s, over --max-replica-lag %v; pausing", worst, c.Config.MaxReplicaLag))
+			}
+			reported = worst.name
+		}
+		if c.stopRequested() {
+			// The run stops before the next chunk, which would add to the lag
+			return
+		}
+		c.sleep(replicaPollInterval)
+		paused += replicaPollInterval
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeReplica reports lags[i] on its i-th poll, and the last lag after that.
+// A negative lag means replication is stopped.
+type fakeReplica struct {
+	lags  []time.Duration
+	err   error
+	polls int
+}
+
+func (r *fakeReplica) ReplicaLag() (time.Duration, bool, error) {
+	lag := r.lags[len(r.lags)-1]
+	if r.polls < len(r.lags) {
+		lag = r.lags[r.polls]
+	}
+	r.polls++
+	if r.err != nil {
+		return 0, false, r.err
+	}
+	if lag < 0 {
+		return 0, false, nil
+	}
+	return lag, true, nil
+}
+
+func lagging(lags ...time.Duration) *fakeReplica {
+	return &fakeReplica{lags: lags}
+}
+
+func TestWorstReplica(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas []Replica
+		expected string
+	}{
+		{"single", []Replica{{"a", lagging(3 * time.Second)}}, "a"},
+		{"max across replicas", []Replica{{"a", lagging(time.Second)}, {"b", lagging(9 * time.Second)}, {"c", lagging(2 * time.Second)}}, "b"},
+		{"stopped beats any lag", []Replica{{"a", lagging(time.Hour)}, {"b", lagging(-1)}}, "b"},
+		{"unreachable beats any lag", []Replica{{"a", &fakeReplica{lags: []time.Duration{0}, err: errors.New("refused")}}, {"b", lagging(time.Hour)}}, "a"},
+	}
+	for _, tt := range tests {
+		if got := worstReplica(tt.replicas); got.name != tt.expected {
+			t.Errorf("%s: expected replica %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestChunkUpdatePausesForLaggingReplica(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.MaxReplicaLag = 2 * time.Second
+	// b falls behind during the first chunk and catches up after two polls
+	a, b := lagging(0), lagging(0, 5*time.Second, 4*time.Second, time.Second)
+	chunker.SetReplicas([]Replica{{"a", a}, {"b", b}})
+
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sleeps) != 2 {
+		t.Errorf("Expected 2 pauses for replica b, got %v", sleeps)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %v processed %d times", key, db.touched[key])
+		}
+	}
+	if a.polls != b.polls {
+		t.Errorf("Expected every replica polled each time, got %d and %d", a.polls, b.polls)
+	}
+}
+
+func TestChunkUpdateWaitsForLaggingReplicaBeforeFirstChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.MaxReplicaLag = 2 * time.Second
+	replica := lagging(9*time.Second, 6*time.Second, 3*time.Second, 0)
+	chunker.SetReplicas([]Replica{{"a", replica}})
+
+	execsAtSleep := []int{}
+	chunker.sleep = func(d time.Duration) { execsAtSleep = append(execsAtSleep, len(db.execs)) }
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(execsAtSleep) != 3 || execsAtSleep[2] != 0 {
+		t.Errorf("Expected 3 pauses before the first chunk, got pauses after %v chunk statements", execsAtSleep)
+	}
+	checkTouchedOnce(t, db)
+}
+
+func TestChunkUpdatePausesForStoppedReplica(t *testing.T) {
+	db := newSimDB(seqKeys(1, 10))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.MaxReplicaLag = 2 * time.Second
+	// a is behind but within the limit; b's replication is stopped, then
+	// restarts caught up
+	a, b := lagging(time.Second), lagging(-1, -1, 0)
+	chunker.SetReplicas([]Replica{{"a", a}, {"b", b}})
+	pauses := 0
+	chunker.sleep = func(time.Duration) { pauses++ }
+
+	var err error
+	stderr := captureStderr(t, func() {
+		_, err = chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pauses != 2 {
+		t.Errorf("Expected 2 pauses for the stopped replica, got %d", pauses)
+	}
+	if !strings.Contains(stderr, "replica b is not replicating, so its lag cannot be held under --max-replica-lag 2s; pausing until it replicates again") || strings.Count(stderr, "Warning") != 1 {
+		t.Errorf("Expected one warning naming the stopped replica, got %q", stderr)
+	}
+}
+
+func TestChunkUpdateWithoutMaxReplicaLagIgnoresReplicas(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	replica := lagging(time.Hour)
+	chunker.SetReplicas([]Replica{{"a", replica}})
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if replica.polls != 0 {
+		t.Errorf("Expected no polls, got %d", replica.polls)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ChunkReport describes one chunk of a run. Affected and Elapsed are only set
+// once the chunk has executed.
+type ChunkReport struct {
+	Index         int
+	Start         interface{}
+	End           interface{}
+	Progress      int
+	Affected      int64
+	TotalAffected int64
+	Elapsed       time.Duration
+	TotalElapsed  time.Duration
+}
+
+// StopReason says why a run ended. Every reason other than ReasonCompleted
+// and ReasonError marks a clean but partial run.
+type StopReason string
+
+const (
+	ReasonCompleted   StopReason = "completed"
+	ReasonNotFound    StopReason = "not-found"
+	ReasonMaxChunks   StopReason = "max-chunks"
+	ReasonMaxRuntime  StopReason = "max-runtime"
+	ReasonDeclined    StopReason = "declined"
+	ReasonInterrupted StopReason = "interrupted"
+	ReasonError       StopReason = "error"
+)
+
+// RunSummary is the outcome of a ChunkUpdate run.
+type RunSummary struct {
+	Chunks       int
+	RowsAffected int64
+	Elapsed      time.Duration
+	Reason       StopReason
+	// Retries counts chunks re-run after a lost connection or a transient
+	// error, and Skipped chunks that were never applied, such as one
+	// declined at the prompt
+	Retries int
+	Skipped int
+	// Stragglers counts the rows VerifyNoGaps found left in the range
+	Stragglers int64
+	// ChunkTime is the time the executed chunks took together, and
+	// FastestChunk and SlowestChunk the shortest and longest of them
+	ChunkTime    time.Duration
+	FastestChunk time.Duration
+	SlowestChunk time.Duration
+}
+
+// chunkTook accounts for the time of the chunk Chunks last counted.
+func (s *RunSummary) chunkTook(elapsed time.Duration) {
+	if s.Chunks == 1 || elapsed < s.FastestChunk {
+		s.FastestChunk = elapsed
+	}
+	if elapsed > s.SlowestChunk {
+		s.SlowestChunk = elapsed
+	}
+	s.ChunkTime += elapsed
+}
+
+// AverageChunk returns the mean time of the executed chunks.
+func (s RunSummary) AverageChunk() time.Duration {
+	if s.Chunks == 0 {
+		return 0
+	}
+	return s.ChunkTime / time.Duration(s.Chunks)
+}
+
+// Partial reports whether the run stopped cleanly before the end of the range.
+func (s RunSummary) Partial() bool {
+	return s.Reason != ReasonCompleted && s.Reason != ReasonError
+}
+
+// Rate returns the affected rows per second over the whole run.
+func (s RunSummary) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.RowsAffected) / s.Elapsed.Seconds()
+}
+
+// Reporter renders the progress of a run.
+type Reporter interface {
+	ChunkStarted(r ChunkReport)
+	ChunkDone(r ChunkReport)
+	ChunkFailed(r ChunkReport, err error)
+	Summary(s RunSummary)
+}
+
+// MultiReporter sends every event to each of its reporters in turn.
+type MultiReporter []Reporter
+
+func (m MultiReporter) ChunkStarted(r ChunkReport) {
+	for _, reporter := range m {
+		reporter.ChunkStarted(r)
+	}
+}
+
+func (m MultiReporter) ChunkDone(r ChunkReport) {
+	for _, reporter := range m {
+		reporter.ChunkDone(r)
+	}
+}
+
+func (m MultiReporter) ChunkFailed(r ChunkReport, err error) {
+	for _, reporter := range m {
+		reporter.ChunkFailed(r, err)
+	}
+}
+
+func (m MultiReporter) Summary(s RunSummary) {
+	for _, reporter := range m {
+		reporter.Summary(s)
+	}
+}
+
+// TextReporter writes the classic oak-chunk-update progress lines. Per-chunk
+// lines are written only when verbose; the summary when verbose or summary.
+type TextReporter struct {
+	w       io.Writer
+	verbose bool
+	summary bool
+}
+
+func NewTextReporter(w io.Writer, verbose, summary bool) *TextReporter {
+	return &TextReporter{w: w, verbose: verbose, summary: summary}
+}
+
+func (t *TextReporter) ChunkStarted(r ChunkReport) {
+	if t.verbose {
+		fmt.Fprintf(t.w, "-- Performing chunks range %v, %v, progress: %d%%\n", r.Start, r.End, r.Progress)
+	}
+}
+
+func (t *TextReporter) ChunkDone(r ChunkReport) {
+	if t.verbose {
+		fmt.Fprintf(t.w, "-- + Rows: %d affected, %d accumulating; seconds: %.1f elapsed; %.1f executed\n", r.Affected, r.TotalAffected, r.Elapsed.Seconds(), r.TotalElapsed.Seconds())
+	}
+}
+
+// ChunkFailed writes nothing; the error is returned to the caller.
+func (t *TextReporter) ChunkFailed(r ChunkReport, err error) {}
+
+func (t *TextReporter) Summary(s RunSummary) {
+	if t.verbose || t.summary {
+		anomalies := ""
+		if s.Retries > 0 || s.Skipped > 0 {
+			anomalies = fmt.Sprintf("; retries: %d; skipped: %d", s.Retries, s.Skipped)
+		}
+		chunkTimes := ""
+		if s.Chunks > 0 {
+			chunkTimes = fmt.Sprintf("; chunk seconds: %.2f avg, %.2f min, %.2f max", s.AverageChunk().Seconds(), s.FastestChunk.Seconds(), s.SlowestChunk.Seconds())
+		}
+		fmt.Fprintf(t.w, "-- Summary: %d rows affected in %d chunks; seconds: %.1f elapsed; %.1f rows/s%s; reason: %s%s\n", s.RowsAffected, s.Chunks, s.Elapsed.Seconds(), s.Rate(), chunkTimes, s.Reason, anomalies)
+	}
+}
+
+// SetReporter replaces the default TextReporter built from Config.
+func (c *Chunker) SetReporter(r Reporter) {
+	c.reporter = r
+}
+
+// report returns the reporter for this run.
+func (c *Chunker) report() Reporter {
+	if c.reporter == nil {
+		c.reporter = NewTextReporter(os.Stdout, c.Config.Verbose, c.Config.SummaryOnly)
+	}
+	return c.reporter
+}
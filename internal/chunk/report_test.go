@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryOnlyReportsOnlySummary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	var out bytes.Buffer
+	chunker.SetReporter(NewTextReporter(&out, false, true))
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Chunks != 3 {
+		t.Errorf("Expected 3 chunks, got %d", summary.Chunks)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "-- Summary: ") || !strings.Contains(lines[0], "in 3 chunks") {
+		t.Errorf("Expected a single summary line, got %q", out.String())
+	}
+}
+
+func TestVerboseReportsChunksAndSummary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	var out bytes.Buffer
+	chunker.SetReporter(NewTextReporter(&out, true, false))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	text := out.String()
+	if strings.Count(text, "-- Performing chunks range ") != 3 || strings.Count(text, "-- + Rows: ") != 3 {
+		t.Errorf("Expected 3 range and 3 row lines, got %q", text)
+	}
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if !strings.HasPrefix(lines[len(lines)-1], "-- Summary: ") {
+		t.Errorf("Expected the summary to be the last line, got %q", text)
+	}
+}
+
+func TestSummaryTotalsMatchChunks(t *testing.T) {
+	db := newSimDB(seqKeys(1, 95))
+	// Every third key matches, so chunks affect 3 or 4 rows
+	db.matches = func(key interface{}) bool { return key.(int64)%3 == 0 }
+	chunker := newSimChunker(db, 10)
+	reporter := &recordingReporter{}
+	chunker.SetReporter(reporter)
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var affected int64
+	var chunkTime, fastest, slowest time.Duration
+	for i, r := range reporter.done {
+		affected += r.Affected
+		chunkTime += r.Elapsed
+		if i == 0 || r.Elapsed < fastest {
+			fastest = r.Elapsed
+		}
+		slowest = max(slowest, r.Elapsed)
+	}
+	if summary.Chunks != len(reporter.done) || summary.Chunks != 10 {
+		t.Errorf("Expected 10 chunks, got %d in the summary and %d reported", summary.Chunks, len(reporter.done))
+	}
+	if summary.RowsAffected != affected || affected != 31 {
+		t.Errorf("Expected 31 rows affected, got %d in the summary and %d over the chunks", summary.RowsAffected, affected)
+	}
+	if summary.ChunkTime != chunkTime || summary.FastestChunk != fastest || summary.SlowestChunk != slowest {
+		t.Errorf("Expected chunk times %v/%v/%v, got %v/%v/%v", chunkTime, fastest, slowest, summary.ChunkTime, summary.FastestChunk, summary.SlowestChunk)
+	}
+	if avg := summary.AverageChunk(); avg != chunkTime/10 || avg < fastest || avg > slowest {
+		t.Errorf("Expected the average chunk time between %v and %v, got %v", fastest, slowest, avg)
+	}
+	if summary.Reason != ReasonCompleted || summary.Partial() {
+		t.Errorf("Expected a complete run, got %s", summary.Reason)
+	}
+}
+
+func TestSummaryReportsChunkTimes(t *testing.T) {
+	var out bytes.Buffer
+	NewTextReporter(&out, false, true).Summary(RunSummary{Chunks: 4, ChunkTime: 6 * time.Second, FastestChunk: 500 * time.Millisecond, SlowestChunk: 3 * time.Second, Reason: ReasonCompleted})
+	if !strings.Contains(out.String(), "; chunk seconds: 1.50 avg, 0.50 min, 3.00 max; reason: completed") {
+		t.Errorf("Expected the chunk times in the summary, got %q", out.String())
+	}
+}
+
+func TestSummaryReportsAnomalies(t *testing.T) {
+	var out bytes.Buffer
+	r := NewTextReporter(&out, false, true)
+	r.Summary(RunSummary{Chunks: 3, Reason: ReasonCompleted})
+	r.Summary(RunSummary{Chunks: 3, Reason: ReasonCompleted, Retries: 2})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "retries") || !strings.HasSuffix(lines[1], "reason: completed; retries: 2; skipped: 0") {
+		t.Errorf("Expected retries only on the anomalous run's summary, got %q", out.String())
+	}
+}
+
+func TestQuietReportsNothing(t *testing.T) {
+	var out bytes.Buffer
+	r := NewTextReporter(&out, false, false)
+	r.ChunkStarted(ChunkReport{Index: 1})
+	r.ChunkDone(ChunkReport{Index: 1})
+	r.Summary(RunSummary{Chunks: 1, RowsAffected: 10, Elapsed: time.Second})
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got %q", out.String())
+	}
+}
+
+func TestRunSummaryRate(t *testing.T) {
+	s := RunSummary{RowsAffected: 500, Elapsed: 2 * time.Second}
+	if s.Rate() != 250 {
+		t.Errorf("Expected 250 rows/s, got %v", s.Rate())
+	}
+	if (RunSummary{RowsAffected: 5}).Rate() != 0 {
+		t.Errorf("Expected zero rate for zero elapsed")
+	}
+}
+
+func TestRunSummaryReason(t *testing.T) {
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+	tests := []struct {
+		name      string
+		configure func(db *simDB, config *Config)
+		reason    StopReason
+		chunks    int
+		partial   bool
+	}{
+		{"completed", func(db *simDB, config *Config) {}, ReasonCompleted, 5, false},
+		{"not found", func(db *simDB, config *Config) {
+			db.matches = func(key interface{}) bool { return key.(int64) <= 15 }
+			config.TerminateOnNotFound = true
+		}, ReasonNotFound, 3, true},
+		{"max chunks", func(db *simDB, config *Config) {
+			config.MaxChunks = 2
+		}, ReasonMaxChunks, 2, true},
+		{"max runtime", func(db *simDB, config *Config) {
+			config.MaxRuntime = time.Nanosecond
+		}, ReasonMaxRuntime, 1, true},
+		{"error", func(db *simDB, config *Config) {
+			db.failAt = 4
+		}, ReasonError, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSimDB(seqKeys(1, 50))
+			chunker := newSimChunker(db, 10)
+			chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+			tt.configure(db, &chunker.Config)
+
+			summary, err := chunker.ChunkUpdate(context.Background(), query)
+			if (err != nil) != (tt.reason == ReasonError) {
+				t.Fatalf("Unexpected error %v for reason %s", err, tt.reason)
+			}
+			if summary.Reason != tt.reason {
+				t.Errorf("Expected reason %s, got %s", tt.reason, summary.Reason)
+			}
+			if summary.Chunks != tt.chunks {
+				t.Errorf("Expected %d chunks, got %d", tt.chunks, summary.Chunks)
+			}
+			if summary.Partial() != tt.partial {
+				t.Errorf("Expected partial=%v for reason %s", tt.partial, summary.Reason)
+			}
+		})
+	}
+}
+
+func TestPartialRunKeepsCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.checkpoint")
+	query := "UPDATE t SET x=1 WHERE GO_CHUNK(t)"
+
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	chunker.Config.CheckpointFile = path
+	chunker.Config.MaxChunks = 2
+	if _, err := chunker.ChunkUpdate(context.Background(), query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cp, err := LoadCheckpoint(path); err != nil || cp == nil || cp.Boundary[0] != "20" {
+		t.Fatalf("Expected checkpoint at 20 after a partial run, got %v, %v", cp, err)
+	}
+
+	chunker = newSimChunker(db, 10)
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	chunker.Config.CheckpointFile = path
+	summary, err := chunker.ChunkUpdate(context.Background(), query)
+	if err != nil || summary.Reason != ReasonCompleted || summary.Chunks != 3 {
+		t.Fatalf("Expected the resumed run to complete the remaining 3 chunks, got %+v, %v", summary, err)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %d processed %d times", key, db.touched[key])
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sqlTokenRe matches a token of a statement outside quoted strings: a name,
+// qualified or not, with its parts plain or `backquoted`, or a single
+// character of punctuation.
+var sqlTokenRe = regexp.MustCompile("(?:`[^`]*`|[\\w$]+)(?:\\.(?:`[^`]*`|[\\w$]+))*|[^\\s\\w$`]")
+
+// namePartRe matches one part of a qualified name.
+var namePartRe = regexp.MustCompile("`[^`]*`|[\\w$]+")
+
+// tableListKeywords start a list of table references.
+var tableListKeywords = map[string]bool{"UPDATE": true, "FROM": true, "JOIN": true, "STRAIGHT_JOIN": true, "INTO": true}
+
+// clauseKeywords end a list of table references.
+var clauseKeywords = map[string]bool{
+	"SET": true, "WHERE": true, "ON": true, "USING": true, "GROUP": true, "ORDER": true, "LIMIT": true,
+	"HAVING": true, "WINDOW": true, "SELECT": true, "VALUES": true, "VALUE": true, "UNION": true,
+}
+
+// chunkTokenRe matches the GO_CHUNK token, its [database.]table argument and
+// an optional alias, given as GO_CHUNK(table AS alias) or GO_CHUNK(table, alias).
+var chunkTokenRe = regexp.MustCompile("GO_CHUNK\\(\\s*([^),\\s]+)(?:(?:\\s*,\\s*|\\s+(?i:AS)\\s+)([\\w$]+|`[^`]+`))?\\s*\\)")
+
+// ParseSchemaRewrite parses a --rewrite-schema value, from=to.
+func ParseSchemaRewrite(spec string) (string, string, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || !validSchemaName(from) || !validSchemaName(to) {
+		return "", "", fmt.Errorf("invalid schema rewrite %q, expected from=to with plain schema names", spec)
+	}
+	return from, to, nil
+}
+
+func validSchemaName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ".`'\"=() \t\n")
+}
+
+// RewriteSchemas replaces the schema qualifier of every table reference in
+// query, GO_CHUNK(schema.table) included, according to rewrites, which map
+// old schema names to new ones. All rewrites apply at once, so app=app_old
+// and app_new=app swap names instead of chaining. Only names where a table
+// can stand are rewritten: two-part names in the table lists after UPDATE,
+// FROM, JOIN and INTO and in GO_CHUNK(...), and the schema of three-part
+// schema.table.column names. A table.column elsewhere is left alone even when
+// the table or alias is named like a rewritten schema, and so are quoted
+// strings and comments. It returns the rewritten query and the schemas of rewrites that
+// matched nothing.
+func RewriteSchemas(query string, rewrites map[string]string) (string, []string, error) {
+	used := map[string]bool{}
+	var b strings.Builder
+	inTables, inChunk := false, false
+	previous := ""
+	for _, part := range splitQuoted(query) {
+		if part.comment {
+			b.WriteString(part.text)
+			continue
+		}
+		if part.quoted {
+			b.WriteString(part.text)
+			inTables, inChunk = false, false
+			previous = ""
+			continue
+		}
+		last := 0
+		for _, loc := range sqlTokenRe.FindAllStringIndex(part.text, -1) {
+			b.WriteString(part.text[last:loc[0]])
+			last = loc[1]
+			token := part.text[loc[0]:loc[1]]
+			word := strings.ToUpper(token)
+			switch {
+			case tableListKeywords[word]:
+				inTables = true
+			case clauseKeywords[word]:
+				inTables = false
+			case token == "(":
+				inTables = false
+				inChunk = strings.EqualFold(previous, "GO_CHUNK")
+			case token == ")":
+				inTables, inChunk = false, false
+			case len(token) == 1 && token != "," && !namePartRe.MatchString(token):
+				inTables = false
+			}
+			previous = token
+
+			parts := namePartRe.FindAllString(token, -1)
+			if len(parts) != 3 && (len(parts) != 2 || !(inTables || inChunk)) {
+				b.WriteString(token)
+				continue
+			}
+			schema := strings.Trim(parts[0], "`")
+			to, ok := rewrites[schema]
+			if !ok {
+				b.WriteString(token)
+				continue
+			}
+			used[schema] = true
+			if strings.HasPrefix(parts[0], "`") {
+				to = "`" + to + "`"
+			}
+			b.WriteString(to + token[len(parts[0]):])
+		}
+		b.WriteString(part.text[last:])
+	}
+	rewritten := b.String()
+
+	before, after := chunkTokenRe.FindStringSubmatch(query), chunkTokenRe.FindStringSubmatch(rewritten)
+	if before != nil && (after == nil || strings.Count(after[1], ".") != strings.Count(before[1], ".")) {
+		return "", nil, fmt.Errorf("rewriting schemas turned %s into an invalid GO_CHUNK token", before[0])
+	}
+
+	var unused []string
+	for from := range rewrites {
+		if !used[from] {
+			unused = append(unused, from)
+		}
+	}
+	sort.Strings(unused)
+	return rewritten, unused, nil
+}
+
+// quotedPart is a piece of a statement: a quoted string, a comment, or the
+// text between them.
+type quotedPart struct {
+	text    string
+	quoted  bool
+	comment bool
+}
+
+// splitQuoted splits query into quoted strings, '...' or "...", comments,
+// /* ... */, -- ... or # ..., and the text around them. A backslash or a
+// doubled quote escapes the quote character. A `backquoted` identifier stays
+// part of the text, but a quote or comment marker inside it starts nothing.
+func splitQuoted(query string) []quotedPart {
+	var parts []quotedPart
+	start := 0
+	cut := func(i, j int, part quotedPart) {
+		if i > start {
+			parts = append(parts, quotedPart{text: query[start:i]})
+		}
+		part.text = query[i:j]
+		parts = append(parts, part)
+		start = j
+	}
+	for i := 0; i < len(query); i++ {
+		switch {
+		case query[i] == '`':
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '`' {
+					if j+1 < len(query) && query[j+1] == '`' {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			i = j
+		case query[i] == '\'' || query[i] == '"':
+			quote := query[i]
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '\\' {
+					j++
+				} else if query[j] == quote {
+					if j+1 < len(query) && query[j+1] == quote {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			j = min(j+1, len(query))
+			cut(i, j, quotedPart{quoted: true})
+			i = j - 1
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query)
+			} else {
+				j += i + 4
+			}
+			cut(i, j, quotedPart{comment: true})
+			i = j - 1
+		case query[i] == '#' || isDashComment(query[i:]):
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query)
+			} else {
+				j += i
+			}
+			cut(i, j, quotedPart{comment: true})
+			i = j - 1
+		}
+	}
+	if start < len(query) {
+		parts = append(parts, quotedPart{text: query[start:]})
+	}
+	return parts
+}
+
+// isDashComment reports whether text starts with a -- comment, which MySQL
+// only takes as one when whitespace or the end of the statement follows.
+func isDashComment(text string) bool {
+	return strings.HasPrefix(text, "--") && (len(text) == 2 || text[2] <= ' ')
+}
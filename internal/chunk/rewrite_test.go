@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRewriteSchemas(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		rewrites map[string]string
+		expected string
+		unused   string
+	}{
+		{
+			"qualified query and token",
+			"UPDATE app_dev.orders o JOIN app_dev.customers c ON c.id = o.customer_id SET o.flag = 1 WHERE GO_CHUNK(app_dev.orders)",
+			map[string]string{"app_dev": "app_prod"},
+			"UPDATE app_prod.orders o JOIN app_prod.customers c ON c.id = o.customer_id SET o.flag = 1 WHERE GO_CHUNK(app_prod.orders)",
+			"[]",
+		},
+		{
+			"backquoted schema",
+			"DELETE FROM `app_dev`.`events` WHERE GO_CHUNK(`app_dev`.events)",
+			map[string]string{"app_dev": "app_prod"},
+			"DELETE FROM `app_prod`.`events` WHERE GO_CHUNK(`app_prod`.events)",
+			"[]",
+		},
+		{
+			"columns and strings untouched",
+			"UPDATE app.t SET note = 'moved from app.t', app.t.x = t.app + 1.5 WHERE GO_CHUNK(app.t)",
+			map[string]string{"app": "app2", "x": "nope"},
+			"UPDATE app2.t SET note = 'moved from app.t', app2.t.x = t.app + 1.5 WHERE GO_CHUNK(app2.t)",
+			"[x]",
+		},
+		{
+			"swap without chaining",
+			"INSERT INTO a.log SELECT * FROM b.log WHERE GO_CHUNK(b.log)",
+			map[string]string{"a": "b", "b": "a"},
+			"INSERT INTO b.log SELECT * FROM a.log WHERE GO_CHUNK(a.log)",
+			"[]",
+		},
+		{
+			"table named like the schema",
+			"UPDATE app.app SET app.x = 1 WHERE app.y > 0 AND GO_CHUNK(app.app)",
+			map[string]string{"app": "app_dev"},
+			"UPDATE app_dev.app SET app.x = 1 WHERE app.y > 0 AND GO_CHUNK(app_dev.app)",
+			"[]",
+		},
+		{
+			"table lists and subqueries",
+			"UPDATE LOW_PRIORITY app.t app, app.u AS u LEFT JOIN `app`.v ON app.id = v.id SET app.x = (SELECT MAX(app.z) FROM app.w app) WHERE GO_CHUNK(app.t, app)",
+			map[string]string{"app": "prod"},
+			"UPDATE LOW_PRIORITY prod.t app, prod.u AS u LEFT JOIN `prod`.v ON app.id = v.id SET app.x = (SELECT MAX(app.z) FROM prod.w app) WHERE GO_CHUNK(prod.t, app)",
+			"[]",
+		},
+		{
+			"quote inside a backquoted identifier",
+			"UPDATE app.t SET `it's` = 1 FROM app.u WHERE GO_CHUNK(app.t)",
+			map[string]string{"app": "prod"},
+			"UPDATE prod.t SET `it's` = 1 FROM prod.u WHERE GO_CHUNK(prod.t)",
+			"[]",
+		},
+		{
+			"quotes inside comments",
+			"UPDATE /* don't */ app.t -- don't\nJOIN app.u ON t.id = u.id # it's\nSET t.x = 1 WHERE GO_CHUNK(app.t)",
+			map[string]string{"app": "prod"},
+			"UPDATE /* don't */ prod.t -- don't\nJOIN prod.u ON t.id = u.id # it's\nSET t.x = 1 WHERE GO_CHUNK(prod.t)",
+			"[]",
+		},
+		{
+			"unqualified token",
+			"UPDATE orders SET x = 1 WHERE GO_CHUNK(orders)",
+			map[string]string{"app_dev": "app_prod"},
+			"UPDATE orders SET x = 1 WHERE GO_CHUNK(orders)",
+			"[app_dev]",
+		},
+	}
+	for _, tt := range tests {
+		got, unused, err := RewriteSchemas(tt.query, tt.rewrites)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: got\n%s\nexpected\n%s", tt.name, got, tt.expected)
+		}
+		if fmt.Sprint(unused) != tt.unused {
+			t.Errorf("%s: expected unused %s, got %v", tt.name, tt.unused, unused)
+		}
+	}
+}
+
+func TestParseSchemaRewrite(t *testing.T) {
+	from, to, err := ParseSchemaRewrite("app_dev=app_prod")
+	if err != nil || from != "app_dev" || to != "app_prod" {
+		t.Errorf("Unexpected result %q, %q, %v", from, to, err)
+	}
+	for _, spec := range []string{"app_dev", "=app", "app=", "app=prod.db", "app=`x`", "a=b=c"} {
+		if _, _, err := ParseSchemaRewrite(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
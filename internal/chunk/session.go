@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TransientErrorChecker is implemented by connections that can tell errors
+// worth retrying the statement after, such as a deadlock, from the others.
+type TransientErrorChecker interface {
+	IsTransientError(err error) bool
+}
+
+// retryBackoff is the pause before the first retry of a chunk that failed on
+// a transient error; it doubles with every further attempt.
+const retryBackoff = 100 * time.Millisecond
+
+// Reconnector is implemented by connections that can replace a lost session.
+// A reconnected session has none of the state the run set up.
+type Reconnector interface {
+	IsConnectionError(err error) bool
+	Reconnect() error
+}
+
+// isolationLevels maps --tx-isolation values to SQL isolation levels.
+var isolationLevels = map[string]string{
+	"read-uncommitted": "READ UNCOMMITTED",
+	"read-committed":   "READ COMMITTED",
+	"repeatable-read":  "REPEATABLE READ",
+	"serializable":     "SERIALIZABLE",
+}
+
+// IsolationLevel returns the SQL isolation level for a --tx-isolation value
+// such as read-committed. Spaces and underscores may stand in for the dash.
+func IsolationLevel(value string) (string, error) {
+	name := strings.ToLower(strings.NewReplacer(" ", "-", "_", "-").Replace(strings.TrimSpace(value)))
+	level, ok := isolationLevels[name]
+	if !ok {
+		return "", fmt.Errorf("invalid transaction isolation %q, expected read-uncommitted, read-committed, repeatable-read or serializable", value)
+	}
+	return level, nil
+}
+
+// sqlModeRe matches a comma-separated list of sql_mode names, or none.
+var sqlModeRe = regexp.MustCompile(`^([A-Z0-9_]+(,[A-Z0-9_]+)*)?$`)
+
+// SQLMode normalizes a --sql-mode value such as "strict_trans_tables,
+// no_zero_date" to the form SET sql_mode takes. An empty value clears every
+// mode. It only checks the value is plausible; the server rejects modes it
+// does not know.
+func SQLMode(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	modes := strings.Split(strings.ToUpper(value), ",")
+	for i, mode := range modes {
+		modes[i] = strings.TrimSpace(mode)
+	}
+	mode := strings.Join(modes, ",")
+	if !sqlModeRe.MatchString(mode) {
+		return "", fmt.Errorf("invalid sql_mode %q, expected comma-separated mode names such as STRICT_TRANS_TABLES,NO_ZERO_DATE", value)
+	}
+	return mode, nil
+}
+
+// rangeVariables lists the session variables that hold a run's position.
+func (c *Chunker) rangeVariables() []string {
+	var names []string
+	for _, prefix := range []string{"unique_key_min_value_", "unique_key_max_value_", "unique_key_range_start_", "unique_key_range_end_"} {
+		for i := 0; i < c.Config.CountColumnsInUniqueKey; i++ {
+			names = append(names, fmt.Sprintf("%s%d", prefix, i))
+		}
+	}
+	return names
+}
+
+// sessionSnapshot reads every range variable in one round trip, so they can
+// be restored if the session is lost.
+func (c *Chunker) sessionSnapshot() (map[string]interface{}, error) {
+	names := c.rangeVariables()
+	cols := make([]string, len(names))
+	for i, name := range names {
+		cols[i] = fmt.Sprintf("@%s AS %s", name, name)
+	}
+	return c.state().QueryRow(c.ctx, "SELECT "+strings.Join(cols, ", "))
+}
+
+// setupSession applies the per-connection state a run relies on to the
+// writer session. It runs once at the start of a run, with a nil snapshot, and
+// again after every reconnect, when it also re-takes the table lock and
+// restores the range variables from snapshot.
+func (c *Chunker) setupSession(snapshot map[string]interface{}) error {
+	if c.Config.NoLogBin {
+		if _, err := c.db.Exec(c.ctx, "SET SESSION SQL_LOG_BIN=0"); err != nil {
+			return err
+		}
+	}
+	if c.Config.TxIsolation != "" {
+		level, err := IsolationLevel(c.Config.TxIsolation)
+		if err != nil {
+			return err
+		}
+		if _, err := c.db.Exec(c.ctx, "SET SESSION TRANSACTION ISOLATION LEVEL "+level); err != nil {
+			return err
+		}
+	}
+	if c.Config.SQLMode != nil {
+		mode, err := SQLMode(*c.Config.SQLMode)
+		if err != nil {
+			return err
+		}
+		// The session's own mode is kept on the server for restoreSession
+		if _, err := c.db.Exec(c.ctx, fmt.Sprintf("SET @go_chunk_update_sql_mode=@@SESSION.sql_mode, SESSION sql_mode='%s'", mode)); err != nil {
+			return err
+		}
+	}
+	if c.batching() {
+		// Unlike START TRANSACTION, this keeps the LOCK TABLES lock
+		if _, err := c.db.Exec(c.ctx, "SET SESSION autocommit=0"); err != nil {
+			return err
+		}
+	}
+	if snapshot == nil {
+		return nil
+	}
+	if c.locked {
+		if err := c.db.LockTableRead(c.Config.Database, c.Config.Table); err != nil {
+			return fmt.Errorf("Lock error: %v", err)
+		}
+	}
+	names := c.rangeVariables()
+	vals := make([]string, len(names))
+	vars := make([]string, len(names))
+	for i, name := range names {
+		vals[i] = c.keyLiteral(snapshot[name])
+		vars[i] = "@" + name
+	}
+	_, err := c.db.Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(vals, ","), strings.Join(vars, ",")))
+	return err
+}
+
+// restoreSession undoes the session settings of setupSession that would
+// otherwise outlive the run on this connection.
+func (c *Chunker) restoreSession() error {
+	if c.Config.SQLMode != nil {
+		if _, err := c.db.Exec(c.ctx, "SET SESSION sql_mode=@go_chunk_update_sql_mode"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execChunk runs a chunk's statement on the writer. A chunk that failed on a
+// transient error, a deadlock or a lock wait timeout, was rolled back and is
+// retried up to MaxRetries times, with an exponential backoff from
+// retryBackoff. A lost connection stops the run, as the chunk may have
+// committed; see execReconnecting. Under --batch-commit a lost connection or
+// a deadlock also loses the batch's uncommitted chunks, so nothing is
+// retried.
+func (c *Chunker) execChunk(statement string, chunkIndex int, snapshot map[string]interface{}) (int64, error) {
+	affected, err := c.execReconnecting(statement, chunkIndex, snapshot)
+	if c.Config.SkipRetryChunk || c.batching() {
+		return affected, err
+	}
+	t, ok := c.db.(TransientErrorChecker)
+	for attempt := 1; err != nil && ok && t.IsTransientError(err) && attempt <= c.Config.MaxRetries && c.ctx.Err() == nil; attempt++ {
+		backoff := retryBackoff << (attempt - 1)
+		c.anomaly(AnomalyRetry, chunkIndex, fmt.Sprintf("chunk %d failed (%v); retrying in %v, attempt %d of %d", chunkIndex, err, backoff, attempt, c.Config.MaxRetries))
+		c.sleep(backoff)
+		affected, err = c.execReconnecting(statement, chunkIndex, snapshot)
+	}
+	return affected, err
+}
+
+// execReconnecting runs a chunk's statement. A chunk whose connection was
+// lost may have committed before it dropped, so it is only retried with
+// --retry-lost-chunk: it reconnects, sets the session up again and runs the
+// chunk once more, applying it twice if it had committed. Under a checkpoint
+// it is never retried, as the checkpoint promises committed chunks are not
+// applied again.
+func (c *Chunker) execReconnecting(statement string, chunkIndex int, snapshot map[string]interface{}) (int64, error) {
+	affected, err := c.execStatement(statement, chunkIndex)
+	if err == nil || c.Config.SkipRetryChunk || c.batching() || c.ctx.Err() != nil {
+		return affected, err
+	}
+	r, ok := c.db.(Reconnector)
+	if !ok || !r.IsConnectionError(err) {
+		return affected, err
+	}
+	if c.Config.CheckpointFile != "" {
+		return affected, fmt.Errorf("connection lost during chunk %d (%v); it may have committed, so it is not retried under --checkpoint-file. Check it and run again to resume after the last checkpointed chunk", chunkIndex, err)
+	}
+	if !c.Config.RetryLostChunk {
+		return affected, fmt.Errorf("connection lost during chunk %d (%v); it may have committed, so it is not retried. Check it and resume with --start-with, or pass --retry-lost-chunk if the statement can safely run twice", chunkIndex, err)
+	}
+	c.anomaly(AnomalyRetry, chunkIndex, fmt.Sprintf("connection lost during chunk %d (%v); reconnecting and retrying the chunk", chunkIndex, err))
+	if err := r.Reconnect(); err != nil {
+		return 0, fmt.Errorf("reconnect failed: %v", err)
+	}
+	if err := c.setupSession(snapshot); err != nil {
+		return 0, err
+	}
+	return c.execStatement(statement, chunkIndex)
+}
@@ -0,0 +1,400 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errConnLost = errors.New("invalid connection")
+
+// reconnectDB drops the connection on its dropAt-th chunk statement. A
+// reconnect loses every session variable, as on a real server.
+type reconnectDB struct {
+	*simDB
+	dropAt     int
+	updates    int
+	reconnects int
+}
+
+func (r *reconnectDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") {
+		r.updates++
+		if r.updates == r.dropAt {
+			return 0, errConnLost
+		}
+	}
+	return r.simDB.Exec(ctx, query, args...)
+}
+
+func (r *reconnectDB) IsConnectionError(err error) bool {
+	return err == errConnLost
+}
+
+func (r *reconnectDB) Reconnect() error {
+	r.reconnects++
+	r.vars = map[string]interface{}{}
+	r.statements = append(r.statements, "-- reconnect")
+	return nil
+}
+
+func newReconnectChunker(dropAt int) (*reconnectDB, *Chunker) {
+	sim := newSimDB(seqKeys(1, 50))
+	db := &reconnectDB{simDB: sim, dropAt: dropAt}
+	chunker := NewChunker(db, newSimChunker(sim, 10).Config)
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	return db, chunker
+}
+
+func TestReconnectRerunsSessionSetup(t *testing.T) {
+	db, chunker := newReconnectChunker(3)
+	chunker.Config.NoLogBin = true
+	chunker.Config.RetryLostChunk = true
+
+	err := chunker.WithTableLock(func() error {
+		_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if db.reconnects != 1 {
+		t.Fatalf("Expected one reconnect, got %d", db.reconnects)
+	}
+
+	var after []string
+	for i, stmt := range db.statements {
+		if stmt == "-- reconnect" {
+			after = db.statements[i+1:]
+			break
+		}
+	}
+	if len(after) < 3 || after[0] != "SET SESSION SQL_LOG_BIN=0" || !strings.Contains(after[1], "INTO @unique_key_min_value_0") || !strings.Contains(after[2], "UPDATE") {
+		t.Errorf("Expected SQL_LOG_BIN, range variables and then the retried chunk after reconnect, got %v", after)
+	}
+	if db.locks != 2 {
+		t.Errorf("Expected the table lock to be taken again after reconnect, got %d locks", db.locks)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %d processed %d times", key, db.touched[key])
+		}
+	}
+}
+
+func TestReconnectRestoresExtremeFloatKeys(t *testing.T) {
+	sim := newSimDBValues(extremeFloatKeys())
+	db := &reconnectDB{simDB: sim, dropAt: 2}
+	chunker := NewChunker(db, newSimChunker(sim, 2).Config)
+	chunker.Config.UniqueKeyType = "float"
+	chunker.Config.RetryLostChunk = true
+	chunker.SetReporter(&recordingReporter{})
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restored := ""
+	for i, stmt := range db.statements {
+		if stmt == "-- reconnect" {
+			restored = db.statements[i+1]
+			break
+		}
+	}
+	// The second chunk runs from 2e-40 to 1e300
+	if !strings.Contains(restored, "2e-40") || !strings.Contains(restored, "1e+300") {
+		t.Errorf("Expected the range variables restored as DOUBLE literals, got %q", restored)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %v processed %d times", key, db.touched[key])
+		}
+	}
+}
+
+func TestSummaryCountsRetries(t *testing.T) {
+	for _, dropAt := range []int{0, 3} {
+		_, chunker := newReconnectChunker(dropAt)
+		chunker.Config.RetryLostChunk = true
+		summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := 0
+		if dropAt > 0 {
+			want = 1
+		}
+		if summary.Retries != want || summary.Skipped != 0 || summary.Reason != ReasonCompleted {
+			t.Errorf("dropAt %d: got %d retries, %d skipped, reason %s; want %d retries of a completed run", dropAt, summary.Retries, summary.Skipped, summary.Reason, want)
+		}
+	}
+}
+
+func TestLostChunkIsNotRetriedByDefault(t *testing.T) {
+	db, chunker := newReconnectChunker(2)
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "connection lost during chunk 2") || !strings.Contains(err.Error(), "may have committed") {
+		t.Fatalf("Expected the lost chunk not to be retried, got %v", err)
+	}
+	if db.reconnects != 0 {
+		t.Errorf("Expected no reconnect without RetryLostChunk, got %d", db.reconnects)
+	}
+}
+
+func TestSkipRetryChunkDoesNotReconnect(t *testing.T) {
+	db, chunker := newReconnectChunker(2)
+	chunker.Config.SkipRetryChunk = true
+	chunker.Config.RetryLostChunk = true
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != errConnLost {
+		t.Fatalf("Expected the connection error, got %v", err)
+	}
+	if db.reconnects != 0 {
+		t.Errorf("Expected no reconnect with SkipRetryChunk, got %d", db.reconnects)
+	}
+}
+
+func TestCheckpointDoesNotReconnect(t *testing.T) {
+	db, chunker := newReconnectChunker(2)
+	chunker.Config.CheckpointFile = filepath.Join(t.TempDir(), "job.checkpoint")
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "not retried under --checkpoint-file") {
+		t.Fatalf("Expected the lost chunk not to be retried, got %v", err)
+	}
+	if db.reconnects != 0 {
+		t.Errorf("Expected no reconnect under a checkpoint, got %d", db.reconnects)
+	}
+	cp, err := LoadCheckpoint(chunker.Config.CheckpointFile)
+	if err != nil || cp == nil || cp.Boundary[0] != "10" {
+		t.Errorf("Expected the first chunk checkpointed, got %+v, %v", cp, err)
+	}
+}
+
+var (
+	errDeadlock = errors.New("Deadlock found when trying to get lock")
+	errSyntax   = errors.New("You have an error in your SQL syntax")
+)
+
+// transientDB fails its first failures chunk statements with err, which is
+// transient when it is errDeadlock.
+type transientDB struct {
+	*simDB
+	err      error
+	failures int
+}
+
+func (d *transientDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") && d.failures > 0 {
+		d.failures--
+		return 0, d.err
+	}
+	return d.simDB.Exec(ctx, query, args...)
+}
+
+func (d *transientDB) IsTransientError(err error) bool {
+	return err == errDeadlock
+}
+
+func newTransientChunker(err error, failures, maxRetries int) (*transientDB, *Chunker, *[]time.Duration) {
+	sim := newSimDB(seqKeys(1, 50))
+	db := &transientDB{simDB: sim, err: err, failures: failures}
+	chunker := NewChunker(db, newSimChunker(sim, 10).Config)
+	chunker.Config.MaxRetries = maxRetries
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return db, chunker, &sleeps
+}
+
+func TestRetryTransientErrorWithBackoff(t *testing.T) {
+	db, chunker, sleeps := newTransientChunker(errDeadlock, 3, 3)
+
+	summary, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Retries != 3 || summary.Reason != ReasonCompleted {
+		t.Errorf("Expected 3 retries of a completed run, got %d retries, reason %s", summary.Retries, summary.Reason)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	if fmt.Sprint(*sleeps) != fmt.Sprint(want) {
+		t.Errorf("Expected backoff %v, got %v", want, *sleeps)
+	}
+	for _, key := range db.keys {
+		if db.touched[key] != 1 {
+			t.Errorf("Key %d processed %d times", key, db.touched[key])
+		}
+	}
+}
+
+func TestNewChunkerDefaultsMaxRetries(t *testing.T) {
+	if got := NewChunker(newSimDB(nil), Config{}).Config.MaxRetries; got != DefaultMaxRetries {
+		t.Errorf("Expected MaxRetries to default to %d, got %d", DefaultMaxRetries, got)
+	}
+	if got := NewChunker(newSimDB(nil), Config{MaxRetries: -1}).Config.MaxRetries; got != -1 {
+		t.Errorf("Expected a negative MaxRetries to be kept, got %d", got)
+	}
+}
+
+func TestRetryTransientErrorGivesUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		failures   int
+		maxRetries int
+		skipRetry  bool
+		sleeps     int
+	}{
+		{"retries exhausted", errDeadlock, 4, 3, false, 3},
+		{"retries disabled", errDeadlock, 1, -1, false, 0},
+		{"skip retry chunk", errDeadlock, 1, 3, true, 0},
+		{"not transient", errSyntax, 1, 3, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, chunker, sleeps := newTransientChunker(tt.err, tt.failures, tt.maxRetries)
+			chunker.Config.SkipRetryChunk = tt.skipRetry
+
+			if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != tt.err {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			}
+			if len(*sleeps) != tt.sleeps {
+				t.Errorf("Expected %d retries, got %d", tt.sleeps, len(*sleeps))
+			}
+			if len(db.touched) != 0 {
+				t.Errorf("Expected no rows processed, got %d", len(db.touched))
+			}
+		})
+	}
+}
+
+func TestTxIsolationSetsSessionLevel(t *testing.T) {
+	db := newSimDB(seqKeys(1, 20))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.TxIsolation = "read-committed"
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "DELETE FROM t WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(db.statements) == 0 || db.statements[0] != "SET SESSION TRANSACTION ISOLATION LEVEL READ COMMITTED" {
+		t.Errorf("Expected the isolation level to be set first, got %v", db.statements)
+	}
+}
+
+func TestIsolationLevel(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"read-committed", "READ COMMITTED"},
+		{"READ_COMMITTED", "READ COMMITTED"},
+		{"repeatable read", "REPEATABLE READ"},
+		{"serializable", "SERIALIZABLE"},
+	}
+	for _, tt := range tests {
+		if got, err := IsolationLevel(tt.value); err != nil || got != tt.expected {
+			t.Errorf("IsolationLevel(%q) = %q, %v; expected %q", tt.value, got, err, tt.expected)
+		}
+	}
+	if _, err := IsolationLevel("snapshot"); err == nil {
+		t.Error("Expected an error for an unknown isolation level")
+	}
+}
+
+func TestSQLModeSetAndRestored(t *testing.T) {
+	db := newSimDB(seqKeys(1, 20))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.TxIsolation = "read-committed"
+	mode := "allow_invalid_dates, no_engine_substitution"
+	chunker.Config.SQLMode = &mode
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"SET SESSION TRANSACTION ISOLATION LEVEL READ COMMITTED",
+		"SET @go_chunk_update_sql_mode=@@SESSION.sql_mode, SESSION sql_mode='ALLOW_INVALID_DATES,NO_ENGINE_SUBSTITUTION'",
+	}
+	if len(db.statements) < len(expected) {
+		t.Fatalf("Expected session setup first, got %v", db.statements)
+	}
+	for i, want := range expected {
+		if db.statements[i] != want {
+			t.Errorf("Statement %d: expected %q, got %q", i+1, want, db.statements[i])
+		}
+	}
+	if last := db.statements[len(db.statements)-1]; last != "SET SESSION sql_mode=@go_chunk_update_sql_mode" {
+		t.Errorf("Expected the sql_mode to be restored last, got %q", last)
+	}
+
+	// A failed run restores it too
+	db = newSimDB(seqKeys(1, 20))
+	db.failAt = 1
+	chunker = newSimChunker(db, 10)
+	mode = "ANSI_QUOTES"
+	chunker.Config.SQLMode = &mode
+	chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+	if last := db.statements[len(db.statements)-1]; last != "SET SESSION sql_mode=@go_chunk_update_sql_mode" {
+		t.Errorf("Expected the sql_mode to be restored after a failure, got %q", last)
+	}
+
+	// An empty mode clears every mode, and no mode leaves the session alone
+	for _, mode := range []*string{new(string), nil} {
+		db = newSimDB(seqKeys(1, 20))
+		chunker = newSimChunker(db, 10)
+		chunker.Config.SQLMode = mode
+		chunker.SetReporter(NewTextReporter(&bytes.Buffer{}, false, false))
+		if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		cleared := db.statements[0] == "SET @go_chunk_update_sql_mode=@@SESSION.sql_mode, SESSION sql_mode=''"
+		if cleared != (mode != nil) {
+			t.Errorf("SQLMode %v: unexpected first statement %q", mode, db.statements[0])
+		}
+	}
+}
+
+func TestSQLMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"STRICT_TRANS_TABLES", "STRICT_TRANS_TABLES"},
+		{"allow_invalid_dates, no_engine_substitution", "ALLOW_INVALID_DATES,NO_ENGINE_SUBSTITUTION"},
+		{"mysql40,no_key_options", "MYSQL40,NO_KEY_OPTIONS"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got, err := SQLMode(tt.value); err != nil || got != tt.expected {
+			t.Errorf("SQLMode(%q) = %q, %v; expected %q", tt.value, got, err, tt.expected)
+		}
+	}
+	for _, value := range []string{"STRICT,,ANSI", ",", "ANSI'; DROP TABLE t; --", "NO ZERO DATE"} {
+		if _, err := SQLMode(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// simDB simulates the session variables and single-column key table that
+// ChunkUpdate drives, so the chunking loop can be exercised end to end.
+// Keys are int64, float64 or string values, kept sorted.
+type simDB struct {
+	MockDB
+	keys    []interface{}
+	vars    map[string]interface{}
+	matches func(key interface{}) bool
+	execs   []string
+	queries []string
+	// statements records every Exec as issued, comments included
+	statements []string
+	touched    map[interface{}]int
+	// failAt makes the n-th mutating statement fail, simulating a crash
+	failAt int
+	// rowValue converts a key as the driver would return it to the client
+	rowValue func(key interface{}) interface{}
+	// partitionOf assigns keys to partitions, which queries naming a
+	// PARTITION clause are restricted to
+	partitionOf func(key interface{}) string
+	// latency delays every boundary query and mutation, as a network round
+	// trip and the statement's execution would
+	latency time.Duration
+}
+
+func newSimDB(keys []int64) *simDB {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = key
+	}
+	return newSimDBValues(values)
+}
+
+func newSimDBValues(keys []interface{}) *simDB {
+	sort.Slice(keys, func(i, j int) bool { return simCompare(keys[i], keys[j]) < 0 })
+	return &simDB{
+		keys:    keys,
+		vars:    map[string]interface{}{},
+		touched: map[interface{}]int{},
+	}
+}
+
+var (
+	simAssignRe    = regexp.MustCompile(`^SELECT (.+) INTO (@[\w,@]+)$`)
+	simBoundaryRe  = regexp.MustCompile(`WHERE \w+ (>=?) @(\w+) AND \w+ <= @(\w+) ORDER BY \w+ LIMIT (\d+)(?: LOCK IN SHARE MODE)?\)`)
+	simEndIntoRe   = regexp.MustCompile(`^SELECT \w+ INTO @(\w+) FROM \(`)
+	simPredicateRe = regexp.MustCompile("[\\w.`]+ (>=?) @(\\w+) AND [\\w.`]+ (<=?) @(\\w+)")
+	simVariableRe  = regexp.MustCompile(`^SELECT @\w+ AS \w+(, @\w+ AS \w+)*$`)
+	simCommentRe   = regexp.MustCompile(`^/\* .*? \*/ `)
+	simPartitionRe = regexp.MustCompile("PARTITION \\(`(\\w+)`\\)")
+	simCountRe     = regexp.MustCompile(`^SELECT COUNT\(\*\) AS n FROM `)
+	simScanRe      = regexp.MustCompile(`^SELECT (\w+) FROM .+? WHERE \w+ (>=?) @(\w+) AND \w+ <= @(\w+) ORDER BY \w+$`)
+)
+
+func simCompare(a, b interface{}) int {
+	if as, ok := a.(string); ok {
+		return strings.Compare(as, b.(string))
+	}
+	ai, aok := a.(int64)
+	bi, bok := b.(int64)
+	if aok && bok {
+		return cmp.Compare(ai, bi)
+	}
+	af, bf := toFloat(a), toFloat(b)
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+// value evaluates a session variable reference or SQL literal.
+func (s *simDB) value(token string) interface{} {
+	if strings.HasPrefix(token, "@") {
+		return s.vars[token[1:]]
+	}
+	if strings.HasPrefix(token, "0x") {
+		b, _ := hex.DecodeString(token[2:])
+		return string(b)
+	}
+	if strings.HasPrefix(token, "'") {
+		return strings.ReplaceAll(token[1:len(token)-1], "''", "'")
+	}
+	if i, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return i
+	}
+	f, _ := strconv.ParseFloat(token, 64)
+	return f
+}
+
+func simInRange(key interface{}, lowOp string, low interface{}, highOp string, high interface{}) bool {
+	if c := simCompare(key, low); c < 0 || (lowOp == ">" && c == 0) {
+		return false
+	}
+	if c := simCompare(key, high); c > 0 || (highOp == "<" && c == 0) {
+		return false
+	}
+	return true
+}
+
+// inPartition reports whether key is visible to query's PARTITION clause.
+func (s *simDB) inPartition(query string, key interface{}) bool {
+	m := simPartitionRe.FindStringSubmatch(query)
+	return m == nil || s.partitionOf == nil || s.partitionOf(key) == m[1]
+}
+
+// boundary evaluates a boundary detection query, returning the last key of
+// the next chunk.
+func (s *simDB) boundary(query string) (interface{}, bool) {
+	m := simBoundaryRe.FindStringSubmatch(query)
+	if m == nil {
+		return nil, false
+	}
+	limit, _ := strconv.Atoi(m[4])
+	var last interface{}
+	n := 0
+	for _, key := range s.keys {
+		if n == limit {
+			break
+		}
+		if simInRange(key, m[1], s.vars[m[2]], "<=", s.vars[m[3]]) && s.inPartition(query, key) {
+			last = key
+			n++
+		}
+	}
+	return last, last != nil
+}
+
+func (s *simDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	s.statements = append(s.statements, query)
+	query = simCommentRe.ReplaceAllString(strings.TrimSpace(query), "")
+	if m := simEndIntoRe.FindStringSubmatch(query); m != nil {
+		if key, ok := s.boundary(query); ok {
+			s.vars[m[1]] = key
+		}
+		return 0, nil
+	}
+	if m := simAssignRe.FindStringSubmatch(query); m != nil {
+		vals, vars := strings.Split(m[1], ","), strings.Split(m[2], ",")
+		for i, v := range vars {
+			s.vars[v[1:]] = s.value(vals[i])
+		}
+		return 0, nil
+	}
+	if strings.HasPrefix(query, "UPDATE") || strings.HasPrefix(query, "DELETE") {
+		time.Sleep(s.latency)
+		s.execs = append(s.execs, query)
+		if s.failAt > 0 && len(s.execs) == s.failAt {
+			return 0, errors.New("simulated failure")
+		}
+		m := simPredicateRe.FindStringSubmatch(query)
+		affected := int64(0)
+		// A DELETE removes the keys it touches
+		kept := s.keys[:0:0]
+		for _, key := range s.keys {
+			if !simInRange(key, m[1], s.vars[m[2]], m[3], s.vars[m[4]]) || !s.inPartition(query, key) || (s.matches != nil && !s.matches(key)) {
+				kept = append(kept, key)
+				continue
+			}
+			s.touched[key]++
+			affected++
+		}
+		if strings.HasPrefix(query, "DELETE") {
+			s.keys = kept
+		}
+		return affected, nil
+	}
+	return 0, nil
+}
+
+func (s *simDB) QueryRow(ctx context.Context, query string, args ...interface{}) (map[string]interface{}, error) {
+	s.queries = append(s.queries, query)
+	query = simCommentRe.ReplaceAllString(query, "")
+	if simVariableRe.MatchString(query) {
+		row := map[string]interface{}{}
+		for _, col := range strings.Split(strings.TrimPrefix(query, "SELECT "), ", ") {
+			name := strings.TrimPrefix(strings.Fields(col)[0], "@")
+			row[name] = s.vars[name]
+		}
+		return row, nil
+	}
+	if strings.HasPrefix(query, "EXPLAIN FORMAT=JSON ") {
+		return map[string]interface{}{"EXPLAIN": fmt.Sprintf(`{"statement": %q}`, strings.TrimPrefix(query, "EXPLAIN FORMAT=JSON "))}, nil
+	}
+	if simCountRe.MatchString(query) {
+		m := simPredicateRe.FindStringSubmatch(query)
+		n := int64(0)
+		for _, key := range s.keys {
+			if simInRange(key, m[1], s.vars[m[2]], m[3], s.vars[m[4]]) && s.inPartition(query, key) {
+				n++
+			}
+		}
+		return map[string]interface{}{"n": n}, nil
+	}
+	if strings.Contains(query, " AS range_exists ") {
+		return map[string]interface{}{"range_exists": int64(min(len(s.keys), 1))}, nil
+	}
+	if strings.Contains(query, "LIMIT") {
+		time.Sleep(s.latency)
+		key, ok := s.boundary(query)
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		if s.rowValue != nil {
+			key = s.rowValue(key)
+		}
+		return map[string]interface{}{"id": key}, nil
+	}
+	return s.MockDB.QueryRow(ctx, query, args...)
+}
+
+// QueryEach streams the keys of an ordered key scan.
+func (s *simDB) QueryEach(ctx context.Context, query string, fn func(row map[string]interface{}) error, args ...interface{}) error {
+	s.queries = append(s.queries, query)
+	m := simScanRe.FindStringSubmatch(query)
+	if m == nil {
+		return errors.New("unsupported scan: " + query)
+	}
+	time.Sleep(s.latency)
+	for _, key := range s.keys {
+		if simInRange(key, m[2], s.vars[m[3]], "<=", s.vars[m[4]]) && s.inPartition(query, key) {
+			if err := fn(map[string]interface{}{m[1]: key}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seed sets the min/max session variables as GetUniqueKeyRange would.
+func (s *simDB) seed() {
+	s.vars["unique_key_min_value_0"] = s.keys[0]
+	s.vars["unique_key_max_value_0"] = s.keys[len(s.keys)-1]
+}
+
+func newSimChunker(db *simDB, chunkSize int) *Chunker {
+	db.seed()
+	return NewChunker(db, Config{
+		Database:                 "test",
+		Table:                    "t",
+		UniqueKeyColumnNames:     "id",
+		UniqueKeyColumnNamesList: []string{"id"},
+		CountColumnsInUniqueKey:  1,
+		UniqueKeyType:            "integer",
+		ChunkSize:                chunkSize,
+	})
+}
+
+func seqKeys(from, to int64) []int64 {
+	keys := make([]int64, 0, to-from+1)
+	for k := from; k <= to; k++ {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// recordingReporter records every event of a run. afterDone, when set, is
+// called with the number of chunks done so far once each one is recorded, so
+// a test can act on the run part way through.
+type recordingReporter struct {
+	started   []ChunkReport
+	done      []ChunkReport
+	failed    []ChunkReport
+	errs      []error
+	summaries []RunSummary
+	afterDone func(n int)
+}
+
+// afterChunks returns a recordingReporter that calls fn once n chunks are done.
+func afterChunks(n int, fn func()) *recordingReporter {
+	return &recordingReporter{afterDone: func(done int) {
+		if done == n {
+			fn()
+		}
+	}}
+}
+
+func (r *recordingReporter) ChunkStarted(c ChunkReport) { r.started = append(r.started, c) }
+
+func (r *recordingReporter) ChunkDone(c ChunkReport) {
+	r.done = append(r.done, c)
+	if r.afterDone != nil {
+		r.afterDone(len(r.done))
+	}
+}
+
+func (r *recordingReporter) ChunkFailed(c ChunkReport, err error) {
+	r.failed = append(r.failed, c)
+	r.errs = append(r.errs, err)
+}
+
+func (r *recordingReporter) Summary(s RunSummary) { r.summaries = append(r.summaries, s) }
+
+// progress returns the progress reported as each chunk started.
+func (r *recordingReporter) progress() []int {
+	var progress []int
+	for _, c := range r.started {
+		progress = append(progress, c.Progress)
+	}
+	return progress
+}
+
+// ranges returns the key range of each executed chunk.
+func (r *recordingReporter) ranges() []string {
+	var ranges []string
+	for _, c := range r.done {
+		ranges = append(ranges, fmt.Sprintf("%v-%v", c.Start, c.End))
+	}
+	return ranges
+}
@@ -0,0 +1,40 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import "fmt"
+
+// fitsOneChunk reports whether the rest of the key range, from the range
+// start (inclusive when inclusive is set) to the maximum, holds no more than
+// ChunkSize rows, in which case the run can go in a single statement without
+// boundary queries. The count stops past ChunkSize rows, so on a large table
+// it costs no more than reading one chunk's boundary.
+func (c *Chunker) fitsOneChunk(inclusive bool) (bool, error) {
+	lowOp := ">"
+	if inclusive {
+		lowOp = ">="
+	}
+	row, err := c.state().QueryRow(c.ctx, fmt.Sprintf("SELECT COUNT(*) AS n FROM (SELECT 1 FROM %s WHERE %s LIMIT %d) t", c.tableRef(), c.remainingPredicate(c.Config.UniqueKeyColumnNames, c.getUniqueKeyRangeStartVariables(), lowOp), c.Config.ChunkSize+1))
+	if err != nil {
+		return false, err
+	}
+	n, ok := denseKeyValue(row["n"])
+	if !ok {
+		return false, fmt.Errorf("unexpected row count %v", row["n"])
+	}
+	if n > int64(c.Config.ChunkSize) {
+		return false, nil
+	}
+	c.Verbose(fmt.Sprintf("%d rows left in range, running the statement once", n))
+	return true, nil
+}
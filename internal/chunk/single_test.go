@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSingleStatement(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		keys   []int64
+		chunks int
+	}{
+		{"smaller than a chunk", seqKeys(1, 7), 1},
+		{"exactly one chunk", seqKeys(1, 10), 1},
+		{"one row over", seqKeys(1, 11), 2},
+		{"large table", seqKeys(1, 95), 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newSimDB(tc.keys)
+			c := newSimChunker(db, 10)
+			c.Config.SingleStatement = true
+			c.SetReporter(&recordingReporter{})
+			summary, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(db.execs) != tc.chunks || summary.Chunks != tc.chunks || summary.Reason != ReasonCompleted {
+				t.Errorf("ran %d statements in %d chunks (%s), want %d completed", len(db.execs), summary.Chunks, summary.Reason, tc.chunks)
+			}
+			checkTouchedOnce(t, db)
+			if tc.chunks > 1 {
+				return
+			}
+			for _, q := range db.queries {
+				if strings.Contains(q, "ORDER BY") {
+					t.Errorf("single statement run issued a boundary query: %s", q)
+				}
+			}
+		})
+	}
+}
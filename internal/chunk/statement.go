@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	leadingNoiseRe  = regexp.MustCompile(`^(?s:\s+|/\*.*?\*/|--[^\n]*\n?|#[^\n]*\n?|\()*`)
+	keywordRe       = regexp.MustCompile(`^[A-Za-z]+`)
+	duplicateKeyRe  = regexp.MustCompile(`(?i)\bON\s+DUPLICATE\s+KEY\s+UPDATE\b`)
+	mainStatementRe = regexp.MustCompile(`(?i)^(SELECT|INSERT|REPLACE|UPDATE|DELETE|TABLE|VALUES)$`)
+	cteTokenRe      = regexp.MustCompile(`[()]|[\w$]+`)
+)
+
+// blankQuoted replaces every quoted string of query with ?, so that its
+// contents cannot be mistaken for SQL.
+func blankQuoted(query string) string {
+	var text strings.Builder
+	for _, part := range splitQuoted(query) {
+		if part.quoted {
+			text.WriteString("?")
+		} else {
+			text.WriteString(part.text)
+		}
+	}
+	return text.String()
+}
+
+// StatementKind returns the leading keyword of query, uppercased, such as
+// UPDATE, DELETE or INSERT, skipping comments and opening parentheses. For a
+// statement starting with common table expressions it is the keyword of the
+// statement that follows them.
+func StatementKind(query string) string {
+	text := blankQuoted(query)
+	text = text[len(leadingNoiseRe.FindString(text)):]
+	kind := strings.ToUpper(keywordRe.FindString(text))
+	if kind != "WITH" {
+		return kind
+	}
+	depth := 0
+	for _, word := range cteTokenRe.FindAllString(text[len(kind):], -1) {
+		switch {
+		case word == "(":
+			depth++
+		case word == ")":
+			depth--
+		case depth == 0 && mainStatementRe.MatchString(word):
+			return strings.ToUpper(word)
+		}
+	}
+	return kind
+}
+
+// IsDestructive reports whether query changes or removes existing rows: an
+// UPDATE, DELETE, REPLACE or INSERT ... ON DUPLICATE KEY UPDATE. A statement
+// of an unrecognised kind is taken to be destructive too. A SELECT, or an
+// INSERT ... SELECT filling another table, is not.
+func IsDestructive(query string) bool {
+	switch StatementKind(query) {
+	case "SELECT", "TABLE", "VALUES":
+		return false
+	case "INSERT":
+		return duplicateKeyRe.MatchString(blankQuoted(query))
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import "testing"
+
+func TestIsDestructive(t *testing.T) {
+	tests := []struct {
+		query       string
+		kind        string
+		destructive bool
+	}{
+		{"UPDATE t SET x=1 WHERE GO_CHUNK(t)", "UPDATE", true},
+		{"  delete FROM t WHERE GO_CHUNK(t)", "DELETE", true},
+		{"/* job 12 */ -- nightly\nDELETE FROM t WHERE GO_CHUNK(t)", "DELETE", true},
+		{"/* don't */ UPDATE `it's` SET x=1 WHERE GO_CHUNK(`it's`)", "UPDATE", true},
+		{"REPLACE INTO t2 SELECT * FROM t WHERE GO_CHUNK(t)", "REPLACE", true},
+		{"INSERT INTO archive SELECT * FROM t WHERE GO_CHUNK(t)", "INSERT", false},
+		{"INSERT INTO totals SELECT k, 1 FROM t WHERE GO_CHUNK(t) ON DUPLICATE KEY UPDATE n = n + 1", "INSERT", true},
+		{"INSERT INTO log SELECT 'ON DUPLICATE KEY UPDATE' FROM t WHERE GO_CHUNK(t)", "INSERT", false},
+		{"(SELECT COUNT(*) FROM t WHERE GO_CHUNK(t))", "SELECT", false},
+		{"WITH old AS (SELECT id FROM t WHERE GO_CHUNK(t)) DELETE t FROM t JOIN old USING (id)", "DELETE", true},
+		{"WITH RECURSIVE c (n) AS (SELECT 1 UNION SELECT n+1 FROM c) SELECT * FROM t WHERE GO_CHUNK(t)", "SELECT", false},
+		{"CALL purge(1)", "CALL", true},
+	}
+	for _, tt := range tests {
+		if kind := StatementKind(tt.query); kind != tt.kind {
+			t.Errorf("StatementKind(%q) = %s, want %s", tt.query, kind, tt.kind)
+		}
+		if got := IsDestructive(tt.query); got != tt.destructive {
+			t.Errorf("IsDestructive(%q) = %v, want %v", tt.query, got, tt.destructive)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// StatsDReporter sends per-chunk metrics to a StatsD or DogStatsD server over
+// UDP: the counters <prefix>.rows_affected and <prefix>.chunks, the timer
+// <prefix>.chunk_duration in milliseconds, and <prefix>.chunk_failures for
+// failed chunks. A chunk's metrics go in one datagram, one metric per line.
+type StatsDReporter struct {
+	conn   net.Conn
+	prefix string
+	failed bool
+	// Warn reports a failure to send metrics; by default it prints a
+	// warning on stderr
+	Warn func(msg string)
+}
+
+// NewStatsDReporter returns a reporter sending to the StatsD server at addr,
+// host:port, with metric names under prefix.
+func NewStatsDReporter(addr, prefix string) (*StatsDReporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDReporter{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+func (s *StatsDReporter) ChunkStarted(r ChunkReport) {}
+
+func (s *StatsDReporter) ChunkDone(r ChunkReport) {
+	s.send(
+		fmt.Sprintf("%s.rows_affected:%d|c", s.prefix, r.Affected),
+		fmt.Sprintf("%s.chunks:1|c", s.prefix),
+		fmt.Sprintf("%s.chunk_duration:%d|ms", s.prefix, r.Elapsed.Milliseconds()),
+	)
+}
+
+func (s *StatsDReporter) ChunkFailed(r ChunkReport, err error) {
+	s.send(fmt.Sprintf("%s.chunk_failures:1|c", s.prefix))
+}
+
+func (s *StatsDReporter) Summary(sum RunSummary) {}
+
+// Close releases the reporter's socket.
+func (s *StatsDReporter) Close() error {
+	return s.conn.Close()
+}
+
+// send writes metric lines in one datagram. Metrics must not stop the run, so
+// a failure is only reported, once. Over UDP, an unreachable server mostly
+// goes unnoticed anyway.
+func (s *StatsDReporter) send(lines ...string) {
+	_, err := s.conn.Write([]byte(strings.Join(lines, "\n")))
+	if err != nil && !s.failed {
+		s.failed = true
+		msg := fmt.Sprintf("cannot send metrics to StatsD: %v", err)
+		if s.Warn != nil {
+			s.Warn(msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "-- Warning: %s\n", msg)
+		}
+	}
+}
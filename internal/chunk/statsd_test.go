@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsD returns a UDP listener and a function collecting the metric
+// lines received on it.
+func listenStatsD(t *testing.T) (*net.UDPConn, func(n int) []string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	receive := func(n int) []string {
+		var lines []string
+		buf := make([]byte, 1500)
+		for i := 0; i < n; i++ {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			size, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("after %d datagrams: %v", i, err)
+			}
+			lines = append(lines, strings.Split(string(buf[:size]), "\n")...)
+		}
+		return lines
+	}
+	return conn, receive
+}
+
+func TestStatsDReporterSendsChunkMetrics(t *testing.T) {
+	conn, receive := listenStatsD(t)
+	reporter, err := NewStatsDReporter(conn.LocalAddr().String(), "jobs.archive.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reporter.Close()
+
+	db := newSimDB(seqKeys(1, 25))
+	chunker := newSimChunker(db, 10)
+	chunker.SetReporter(reporter)
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := receive(3)
+	for i, line := range lines {
+		lines[i] = regexp.MustCompile(`:\d+\|ms$`).ReplaceAllString(line, ":N|ms")
+	}
+	expected := []string{
+		"jobs.archive.rows_affected:10|c", "jobs.archive.chunks:1|c", "jobs.archive.chunk_duration:N|ms",
+		"jobs.archive.rows_affected:10|c", "jobs.archive.chunks:1|c", "jobs.archive.chunk_duration:N|ms",
+		"jobs.archive.rows_affected:5|c", "jobs.archive.chunks:1|c", "jobs.archive.chunk_duration:N|ms",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected metrics %q, got %q", expected, lines)
+	}
+}
+
+func TestStatsDReporterCountsFailures(t *testing.T) {
+	conn, receive := listenStatsD(t)
+	reporter, err := NewStatsDReporter(conn.LocalAddr().String(), "chunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reporter.Close()
+
+	reporter.ChunkFailed(ChunkReport{Index: 4}, errors.New("lock wait timeout"))
+	if lines := receive(1); !reflect.DeepEqual(lines, []string{"chunk.chunk_failures:1|c"}) {
+		t.Errorf("Unexpected failure metric %q", lines)
+	}
+}
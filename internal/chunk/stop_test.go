@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopSignalFinishesCurrentChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	c.Config.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	stop := make(chan struct{})
+	c.SetReporter(afterChunks(3, func() { close(stop) }))
+	c.SetStopSignal(stop)
+	summary, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reason != ReasonInterrupted || summary.Chunks != 3 || len(db.execs) != 3 {
+		t.Errorf("reason %s after %d chunks (%d statements), want interrupted after 3", summary.Reason, summary.Chunks, len(db.execs))
+	}
+	if _, err := os.Stat(c.Config.CheckpointFile); err != nil {
+		t.Errorf("checkpoint was not kept for the next run: %v", err)
+	}
+}
+
+func TestStopSignalBeforeFirstChunk(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	stop := make(chan struct{})
+	close(stop)
+	c.SetStopSignal(stop)
+	summary, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reason != ReasonInterrupted || len(db.execs) != 0 {
+		t.Errorf("reason %s after %d statements, want interrupted before any", summary.Reason, len(db.execs))
+	}
+}
+
+func TestStopSignalCutsPauseShort(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	c.Config.SleepMillis = 60000
+	stop := make(chan struct{})
+	c.SetStopSignal(stop)
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+
+	start := time.Now()
+	summary, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %v, want the minute-long pause cut short", elapsed)
+	}
+	if summary.Reason != ReasonInterrupted || summary.Chunks != 1 {
+		t.Errorf("reason %s after %d chunks, want interrupted after 1", summary.Reason, summary.Chunks)
+	}
+}
+
+func TestContextCancelStopsBetweenChunks(t *testing.T) {
+	db := newSimDB(seqKeys(1, 100))
+	c := newSimChunker(db, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.SetReporter(afterChunks(3, cancel))
+	summary, err := c.ChunkUpdate(ctx, "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Reason != ReasonInterrupted || summary.Chunks != 3 || len(db.execs) != 3 {
+		t.Errorf("reason %s after %d chunks (%d statements), want interrupted after 3", summary.Reason, summary.Chunks, len(db.execs))
+	}
+}
+
+// cancellingDB cancels the run's context while its cancelAt-th chunk
+// statement runs, which then gives up as the driver does.
+type cancellingDB struct {
+	*simDB
+	cancelAt int
+	cancel   context.CancelFunc
+	updates  int
+}
+
+func (d *cancellingDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") {
+		d.updates++
+		if d.updates == d.cancelAt {
+			d.cancel()
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+	}
+	return d.simDB.Exec(ctx, query, args...)
+}
+
+func TestContextCancelAbandonsStatement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := &cancellingDB{simDB: newSimDB(seqKeys(1, 100)), cancelAt: 2, cancel: cancel}
+	c := newSimChunker(db.simDB, 10)
+	c.db = db
+	summary, err := c.ChunkUpdate(ctx, "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("Expected the run to fail with the cancellation, got %v", err)
+	}
+	if summary.Reason != ReasonError || db.updates != 2 {
+		t.Errorf("reason %s after %d statements, want an error at the second", summary.Reason, db.updates)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"os"
+)
+
+// SyslogWriter is the part of a *syslog.Writer that SyslogReporter uses:
+// Write logs at the writer's configured priority, Err at LOG_ERR.
+type SyslogWriter interface {
+	Write(p []byte) (int, error)
+	Err(m string) error
+}
+
+// SyslogReporter writes one key=value line per executed chunk and one for the
+// summary to the system log, where rsyslog or journald can collect them.
+// Failed chunks and failed runs are logged at LOG_ERR.
+type SyslogReporter struct {
+	w      SyslogWriter
+	prefix string
+	failed bool
+	// Warn reports a failure to write to the system log; by default it
+	// prints a warning on stderr
+	Warn func(msg string)
+}
+
+func NewSyslogReporter(w SyslogWriter, jobID, database, table string) *SyslogReporter {
+	return &SyslogReporter{w: w, prefix: fmt.Sprintf("job=%s table=%s.%s", jobID, database, table)}
+}
+
+func (s *SyslogReporter) ChunkStarted(r ChunkReport) {}
+
+func (s *SyslogReporter) ChunkDone(r ChunkReport) {
+	s.write(false, fmt.Sprintf("%s event=chunk chunk=%d start=%v end=%v affected=%d total_affected=%d elapsed_ms=%d progress=%d", s.prefix, r.Index, r.Start, r.End, r.Affected, r.TotalAffected, r.Elapsed.Milliseconds(), r.Progress))
+}
+
+func (s *SyslogReporter) ChunkFailed(r ChunkReport, err error) {
+	s.write(true, fmt.Sprintf("%s event=chunk-failed chunk=%d start=%v end=%v error=%q", s.prefix, r.Index, r.Start, r.End, err.Error()))
+}
+
+func (s *SyslogReporter) Summary(sum RunSummary) {
+	s.write(sum.Reason == ReasonError, fmt.Sprintf("%s event=summary chunks=%d affected=%d elapsed_ms=%d rate=%.1f reason=%s", s.prefix, sum.Chunks, sum.RowsAffected, sum.Elapsed.Milliseconds(), sum.Rate(), sum.Reason))
+}
+
+// write logs a line. Logging must not stop the run, so a failure is only
+// reported, once.
+func (s *SyslogReporter) write(isErr bool, line string) {
+	var err error
+	if isErr {
+		err = s.w.Err(line)
+	} else {
+		_, err = s.w.Write([]byte(line))
+	}
+	if err != nil && !s.failed {
+		s.failed = true
+		msg := fmt.Sprintf("cannot write to syslog: %v", err)
+		if s.Warn != nil {
+			s.Warn(msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "-- Warning: %s\n", msg)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeSyslog captures messages with the priority they were logged at.
+type fakeSyslog struct {
+	lines []string
+	fail  error
+}
+
+func (f *fakeSyslog) Write(p []byte) (int, error) {
+	f.lines = append(f.lines, "info: "+string(p))
+	return len(p), f.fail
+}
+
+func (f *fakeSyslog) Err(m string) error {
+	f.lines = append(f.lines, "err: "+m)
+	return f.fail
+}
+
+func TestSyslogReporterLogsChunksAndSummary(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	chunker := newSimChunker(db, 10)
+	w := &fakeSyslog{}
+	chunker.SetReporter(NewSyslogReporter(w, "job1", "test", "t"))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(w.lines) != 4 {
+		t.Fatalf("Expected 3 chunk lines and a summary, got %q", w.lines)
+	}
+	line := regexp.MustCompile(`elapsed_ms=\d+`).ReplaceAllString(w.lines[1], "elapsed_ms=N")
+	if line != "info: job=job1 table=test.t event=chunk chunk=2 start=10 end=20 affected=10 total_affected=20 elapsed_ms=N progress=31" {
+		t.Errorf("Unexpected chunk line %q", w.lines[1])
+	}
+	if !strings.HasPrefix(w.lines[3], "info: job=job1 table=test.t event=summary chunks=3 affected=30 ") || !strings.HasSuffix(w.lines[3], " reason=completed") {
+		t.Errorf("Unexpected summary line %q", w.lines[3])
+	}
+}
+
+func TestSyslogReporterLogsFailuresAsErrors(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	db.failAt = 2
+	chunker := newSimChunker(db, 10)
+	w := &fakeSyslog{}
+	chunker.SetReporter(NewSyslogReporter(w, "job1", "test", "t"))
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil {
+		t.Fatal("Expected simulated failure")
+	}
+	if len(w.lines) != 3 {
+		t.Fatalf("Expected a chunk, a failure and a summary line, got %q", w.lines)
+	}
+	if w.lines[1] != `err: job=job1 table=test.t event=chunk-failed chunk=2 start=10 end=20 error="simulated failure"` {
+		t.Errorf("Unexpected failure line %q", w.lines[1])
+	}
+	if !strings.HasPrefix(w.lines[2], "err: ") || !strings.HasSuffix(w.lines[2], " reason=error") {
+		t.Errorf("Expected the failed run's summary at LOG_ERR, got %q", w.lines[2])
+	}
+}
+
+func TestSyslogReporterWarnsOnce(t *testing.T) {
+	w := &fakeSyslog{fail: errors.New("connection refused")}
+	reporter := NewSyslogReporter(w, "job1", "test", "t")
+	var warnings []string
+	reporter.Warn = func(msg string) { warnings = append(warnings, msg) }
+
+	reporter.ChunkDone(ChunkReport{Index: 1})
+	reporter.ChunkDone(ChunkReport{Index: 2})
+	reporter.Summary(RunSummary{Reason: ReasonCompleted})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "connection refused") {
+		t.Errorf("Expected a single warning, got %q", warnings)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import "fmt"
+
+// RowEstimateDB is a DBInterface that can estimate a table's row count.
+type RowEstimateDB interface {
+	DBInterface
+	TableRowsEstimate(database, table string) (int64, error)
+}
+
+// CheckMinTableRows returns an error when the estimated row count of
+// database.table is below min, which suggests the job points at the wrong
+// table, such as an empty staging copy. The estimate comes from table
+// statistics, so it can lag behind a recent bulk load.
+func CheckMinTableRows(db RowEstimateDB, database, table string, min int64) error {
+	rows, err := db.TableRowsEstimate(database, table)
+	if err != nil {
+		return err
+	}
+	if rows < min {
+		return fmt.Errorf("table %s.%s has about %d rows, fewer than --min-table-rows %d; check the job targets the right table (ANALYZE TABLE refreshes a stale estimate)", database, table, rows, min)
+	}
+	return nil
+}
+
+// TableStatsDB is a DBInterface that reports a table's size statistics.
+type TableStatsDB interface {
+	DBInterface
+	TableStats(database, table string) (rows, avgRowLength, dataLength int64, err error)
+}
+
+// maxAutoChunkSize caps a derived chunk size, so that a table of tiny rows
+// does not get chunks that hold locks on millions of them.
+const maxAutoChunkSize = 100000
+
+// AutoChunkSize derives the number of rows per chunk that makes each chunk
+// about targetBytes of table data, from the statistics of database.table. The
+// average row length is taken from AVG_ROW_LENGTH, or DATA_LENGTH over
+// TABLE_ROWS when that is missing; without either it returns an error.
+func AutoChunkSize(db TableStatsDB, database, table string, targetBytes int64) (int, error) {
+	rows, avgRowLength, dataLength, err := db.TableStats(database, table)
+	if err != nil {
+		return 0, err
+	}
+	return chunkSizeForBytes(rows, avgRowLength, dataLength, targetBytes)
+}
+
+func chunkSizeForBytes(rows, avgRowLength, dataLength, targetBytes int64) (int, error) {
+	if targetBytes <= 0 {
+		return 0, fmt.Errorf("target chunk bytes must be positive, got %d", targetBytes)
+	}
+	if avgRowLength <= 0 && rows > 0 {
+		avgRowLength = dataLength / rows
+	}
+	if avgRowLength <= 0 {
+		return 0, fmt.Errorf("no row length statistics (ANALYZE TABLE computes them)")
+	}
+	size := targetBytes / avgRowLength
+	switch {
+	case size < 1:
+		size = 1
+	case size > maxAutoChunkSize:
+		size = maxAutoChunkSize
+	}
+	return int(size), nil
+}
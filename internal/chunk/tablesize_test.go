@@ -0,0 +1,81 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"strings"
+	"testing"
+)
+
+type rowsMockDB struct {
+	MockDB
+	rows int64
+}
+
+func (m *rowsMockDB) TableRowsEstimate(database, table string) (int64, error) {
+	return m.rows, nil
+}
+
+func TestCheckMinTableRows(t *testing.T) {
+	tests := []struct {
+		rows    int64
+		min     int64
+		aborted bool
+	}{
+		{0, 1000, true},
+		{999, 1000, true},
+		{1000, 1000, false},
+		{5000000, 1000, false},
+	}
+	for _, tt := range tests {
+		err := CheckMinTableRows(&rowsMockDB{rows: tt.rows}, "test", "t", tt.min)
+		if (err != nil) != tt.aborted {
+			t.Errorf("%d rows, minimum %d: got %v", tt.rows, tt.min, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "--min-table-rows") {
+			t.Errorf("Expected the error to name the flag, got %v", err)
+		}
+	}
+}
+
+type statsMockDB struct {
+	MockDB
+	rows, avgRowLength, dataLength int64
+}
+
+func (m *statsMockDB) TableStats(database, table string) (int64, int64, int64, error) {
+	return m.rows, m.avgRowLength, m.dataLength, nil
+}
+
+func TestAutoChunkSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		stats  statsMockDB
+		target int64
+		want   int
+		err    bool
+	}{
+		{"from avg_row_length", statsMockDB{rows: 1000000, avgRowLength: 200, dataLength: 200000000}, 1 << 20, 5242, false},
+		{"from data_length over rows", statsMockDB{rows: 1000, dataLength: 1024000}, 1 << 20, 1024, false},
+		{"row larger than target", statsMockDB{rows: 10, avgRowLength: 4 << 20}, 1 << 20, 1, false},
+		{"capped", statsMockDB{rows: 100000000, avgRowLength: 8}, 64 << 20, maxAutoChunkSize, false},
+		{"no statistics", statsMockDB{}, 1 << 20, 0, true},
+		{"invalid target", statsMockDB{avgRowLength: 100}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := AutoChunkSize(&tt.stats, "test", "t", tt.target)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d (error %v)", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleFile lets an operator change the sleep between chunks of a running
+// job by writing a number of milliseconds into a file. The file is re-read only
+// when its modification time or size changes.
+type throttleFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// poll returns the sleep the file asks for and whether it changed since the
+// last poll. A missing file or unchanged mtime leaves the current sleep alone.
+func (t *throttleFile) poll() (int, bool, error) {
+	info, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return 0, false, nil
+	}
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return 0, false, err
+	}
+	millis, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || millis < 0 {
+		return 0, false, fmt.Errorf("throttle file %s must contain a non-negative number of milliseconds, got %q", t.path, strings.TrimSpace(string(data)))
+	}
+	return millis, true, nil
+}
+
+// applyThrottle updates SleepMillis from the throttle file, if one is set.
+// A malformed file is reported and ignored, so a typo cannot stop the job.
+func (c *Chunker) applyThrottle() {
+	if c.throttle == nil {
+		return
+	}
+	millis, changed, err := c.throttle.poll()
+	if err != nil {
+		c.Warn(err.Error())
+		return
+	}
+	if changed && millis != c.Config.SleepMillis {
+		c.Verbose(fmt.Sprintf("Throttle file %s: sleep %d ms", c.throttle.path, millis))
+		c.Config.SleepMillis = millis
+	}
+}
+
+// pauseAfterChunk returns the sleep after a chunk that ran for elapsed:
+// SleepRatio times elapsed, as in oak-chunk-update, but never less than
+// SleepMillis. With both set, SleepMillis is thus a floor that keeps fast
+// chunks spaced out, while the ratio backs off when chunks slow down.
+func (c *Chunker) pauseAfterChunk(elapsed time.Duration) time.Duration {
+	pause := time.Duration(c.Config.SleepMillis) * time.Millisecond
+	if c.Config.SleepRatio > 0 {
+		if ratio := time.Duration(float64(elapsed) * c.Config.SleepRatio); ratio > pause {
+			pause = ratio
+		}
+	}
+	return pause
+}
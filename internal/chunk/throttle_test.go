@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeThrottle writes content and moves the mtime forward, so the change is
+// visible even on filesystems with coarse timestamps.
+func writeThrottle(t *testing.T, path, content string, at time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThrottleFileChangesSleepMidRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "throttle")
+	now := time.Now()
+	writeThrottle(t, path, "5\n", now)
+
+	db := newSimDB(seqKeys(1, 50))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.SleepMillis = 100
+	chunker.Config.ThrottleFile = path
+
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		switch len(sleeps) {
+		case 2:
+			writeThrottle(t, path, "20", now.Add(time.Second))
+		case 3:
+			writeThrottle(t, path, "slow down", now.Add(2*time.Second))
+		}
+	}
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The file overrides --sleep from the first chunk; a malformed value is
+	// ignored and the last good value stays in effect.
+	expected := []time.Duration{5, 5, 20, 20, 20}
+	if len(sleeps) != len(expected) {
+		t.Fatalf("Expected %d sleeps, got %v", len(expected), sleeps)
+	}
+	for i, want := range expected {
+		if sleeps[i] != want*time.Millisecond {
+			t.Errorf("Sleep %d: expected %v, got %v", i+1, want*time.Millisecond, sleeps[i])
+		}
+	}
+}
+
+func TestThrottleFileMissingKeepsSleep(t *testing.T) {
+	db := newSimDB(seqKeys(1, 20))
+	chunker := newSimChunker(db, 10)
+	chunker.Config.SleepMillis = 7
+	chunker.Config.ThrottleFile = filepath.Join(t.TempDir(), "absent")
+
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sleeps) != 2 || sleeps[0] != 7*time.Millisecond || sleeps[1] != 7*time.Millisecond {
+		t.Errorf("Expected two 7ms sleeps, got %v", sleeps)
+	}
+}
+
+func TestPauseAfterChunk(t *testing.T) {
+	tests := []struct {
+		millis   int
+		ratio    float64
+		elapsed  time.Duration
+		expected time.Duration
+	}{
+		{0, 0, time.Second, 0},
+		{100, 0, time.Second, 100 * time.Millisecond},
+		{0, 0.5, time.Second, 500 * time.Millisecond},
+		{0, 2, 300 * time.Millisecond, 600 * time.Millisecond},
+		// SleepMillis is a floor under the ratio
+		{100, 0.5, 100 * time.Millisecond, 100 * time.Millisecond},
+		{100, 0.5, time.Second, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		c := NewChunker(nil, Config{SleepMillis: tt.millis, SleepRatio: tt.ratio})
+		if got := c.pauseAfterChunk(tt.elapsed); got != tt.expected {
+			t.Errorf("sleep %d ms, ratio %v, chunk %v: paused %v, want %v", tt.millis, tt.ratio, tt.elapsed, got, tt.expected)
+		}
+	}
+}
+
+func TestSleepRatioFollowsChunkTime(t *testing.T) {
+	db := newSimDB(seqKeys(1, 30))
+	db.latency = 20 * time.Millisecond
+	chunker := newSimChunker(db, 10)
+	chunker.Config.SleepRatio = 2
+	var sleeps []time.Duration
+	chunker.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	chunker.SetReporter(&recordingReporter{})
+	if _, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err != nil {
+		t.Fatal(err)
+	}
+	// The run sleeps after every chunk, for twice its time
+	if len(sleeps) != 3 {
+		t.Fatalf("Expected 3 sleeps, got %v", sleeps)
+	}
+	for _, d := range sleeps {
+		if d < 40*time.Millisecond || d > time.Second {
+			t.Errorf("Expected about twice the 20ms chunk time, slept %v", d)
+		}
+	}
+}
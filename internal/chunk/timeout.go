@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// QueryKiller is implemented by connections that know their server-side
+// connection id and can kill a query running on it from another connection.
+type QueryKiller interface {
+	ConnectionID() int64
+	KillQuery(id int64) error
+}
+
+// ReadTimeoutChecker is implemented by connections that tell a statement
+// abandoned on the connection's read timeout from a lost connection.
+type ReadTimeoutChecker interface {
+	IsReadTimeout(err error) bool
+}
+
+// execStatement runs a chunk's statement on the writer, bounded by
+// StatementTimeout. Giving up on the client does not stop the statement on
+// the server, so with KillOnTimeout it is killed there. Either way the run
+// stops: the statement may have been committing when it was killed, so a
+// retry could apply the chunk twice.
+//
+// A statement abandoned on the connection's read timeout is not retried
+// either, for the same reason. With KillOnTimeout it is killed before the
+// run stops.
+func (c *Chunker) execStatement(statement string, chunkIndex int) (int64, error) {
+	// The id must be taken before running: a reconnect replaces it
+	killer, canKill := c.db.(QueryKiller)
+	var id int64
+	if canKill {
+		id = killer.ConnectionID()
+	}
+
+	ctx := c.ctx
+	if c.Config.StatementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(c.ctx, c.Config.StatementTimeout)
+		defer cancel()
+	}
+	affected, err := c.db.Exec(ctx, statement)
+	if r, ok := c.db.(ReadTimeoutChecker); ok && err != nil && r.IsReadTimeout(err) {
+		if c.Config.KillOnTimeout && canKill {
+			if kerr := killer.KillQuery(id); kerr != nil {
+				return 0, fmt.Errorf("chunk %d: %v; KILL QUERY %d failed: %v", chunkIndex, err, id, kerr)
+			}
+			return 0, fmt.Errorf("chunk %d: %v; killed its query on connection %d, but it may have committed, so it is not retried", chunkIndex, err, id)
+		}
+		return 0, fmt.Errorf("chunk %d: %v; it may still be running or have committed on the server, so it is not retried", chunkIndex, err)
+	}
+	if c.Config.StatementTimeout <= 0 || !errors.Is(err, context.DeadlineExceeded) || c.ctx.Err() != nil {
+		return affected, err
+	}
+	if !c.Config.KillOnTimeout || !canKill {
+		return 0, fmt.Errorf("chunk %d exceeded --statement-timeout %v and may still be running on the server; use --kill-on-timeout to stop it", chunkIndex, c.Config.StatementTimeout)
+	}
+	if kerr := killer.KillQuery(id); kerr != nil {
+		return 0, fmt.Errorf("chunk %d exceeded --statement-timeout %v and KILL QUERY %d failed: %v", chunkIndex, c.Config.StatementTimeout, id, kerr)
+	}
+	return 0, fmt.Errorf("chunk %d exceeded --statement-timeout %v; killed its query on connection %d, but it may have committed, so it is not retried", chunkIndex, c.Config.StatementTimeout, id)
+}
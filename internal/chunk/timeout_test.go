@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeoutDB times out its timeoutAt-th chunk statement. Each connection has
+// its own id, and killed records the ids passed to KillQuery.
+type timeoutDB struct {
+	*reconnectDB
+	timeoutAt    int
+	timeouts     []time.Duration
+	connectionID int64
+	killed       []int64
+}
+
+func (d *timeoutDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") {
+		var timeout time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		d.timeouts = append(d.timeouts, timeout)
+		if len(d.timeouts) == d.timeoutAt {
+			return 0, context.DeadlineExceeded
+		}
+	}
+	return d.reconnectDB.Exec(ctx, query, args...)
+}
+
+func (d *timeoutDB) IsConnectionError(err error) bool {
+	return err == context.DeadlineExceeded
+}
+
+func (d *timeoutDB) Reconnect() error {
+	d.connectionID++
+	return d.reconnectDB.Reconnect()
+}
+
+func (d *timeoutDB) ConnectionID() int64 {
+	return d.connectionID
+}
+
+func (d *timeoutDB) KillQuery(id int64) error {
+	d.killed = append(d.killed, id)
+	return nil
+}
+
+func newTimeoutChunker(timeoutAt int) (*timeoutDB, *Chunker) {
+	rdb, chunker := newReconnectChunker(0)
+	db := &timeoutDB{reconnectDB: rdb, timeoutAt: timeoutAt, connectionID: 42}
+	chunker.db = db
+	chunker.Config.StatementTimeout = time.Second
+	return db, chunker
+}
+
+func TestKillOnTimeoutKillsAndStops(t *testing.T) {
+	db, chunker := newTimeoutChunker(2)
+	chunker.Config.KillOnTimeout = true
+	chunker.Config.RetryLostChunk = true
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "chunk 2 exceeded --statement-timeout") || !strings.Contains(err.Error(), "killed its query on connection 42") || !strings.Contains(err.Error(), "not retried") {
+		t.Fatalf("Expected the killed chunk to stop the run, got %v", err)
+	}
+	if len(db.killed) != 1 || db.killed[0] != 42 {
+		t.Errorf("Expected KILL QUERY on connection 42, got %v", db.killed)
+	}
+	if db.reconnects != 0 || len(db.timeouts) != 2 {
+		t.Errorf("Expected no retry, got %d reconnects, %d statements", db.reconnects, len(db.timeouts))
+	}
+	for _, timeout := range db.timeouts {
+		if timeout <= 0 || timeout > time.Second {
+			t.Errorf("Expected every chunk bounded by 1s, got %v", timeout)
+		}
+	}
+}
+
+func TestTimeoutWithoutKillStops(t *testing.T) {
+	db, chunker := newTimeoutChunker(2)
+
+	_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+	if err == nil || !strings.Contains(err.Error(), "may still be running") {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if len(db.killed) != 0 || db.reconnects != 0 {
+		t.Errorf("Expected no kill and no retry, got kills %v, %d reconnects", db.killed, db.reconnects)
+	}
+}
+
+// readTimeoutDB drops its timeoutAt-th chunk statement on the read timeout,
+// which the driver reports like a lost connection.
+type readTimeoutDB struct {
+	*timeoutDB
+}
+
+var errSimReadTimeout = errors.New("no reply within the read timeout")
+
+func (d *readTimeoutDB) Exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if strings.Contains(query, "UPDATE") {
+		d.timeouts = append(d.timeouts, 0)
+		if len(d.timeouts) == d.timeoutAt {
+			return 0, errSimReadTimeout
+		}
+	}
+	return d.reconnectDB.Exec(ctx, query, args...)
+}
+
+func (d *readTimeoutDB) IsConnectionError(err error) bool {
+	return err == errSimReadTimeout
+}
+
+func (d *readTimeoutDB) IsReadTimeout(err error) bool {
+	return err == errSimReadTimeout
+}
+
+func TestReadTimeoutIsNotRetried(t *testing.T) {
+	for _, kill := range []bool{false, true} {
+		tdb, chunker := newTimeoutChunker(2)
+		db := &readTimeoutDB{tdb}
+		chunker.db = db
+		chunker.Config.StatementTimeout = 0
+		chunker.Config.KillOnTimeout = kill
+
+		_, err := chunker.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)")
+		if err == nil || !strings.Contains(err.Error(), "not retried") {
+			t.Fatalf("kill %v: expected the run to stop, got %v", kill, err)
+		}
+		if db.reconnects != 0 || len(db.timeouts) != 2 {
+			t.Errorf("kill %v: expected no retry, got %d reconnects, %d statements", kill, db.reconnects, len(db.timeouts))
+		}
+		if expected := map[bool]int{false: 0, true: 1}[kill]; len(db.killed) != expected {
+			t.Errorf("kill %v: expected %d KILL QUERY, got %v", kill, expected, db.killed)
+		}
+	}
+}
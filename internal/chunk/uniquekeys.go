@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// UniqueKeyCandidate describes one unique key the chunker could chunk by.
+type UniqueKeyCandidate struct {
+	IndexName     string
+	Columns       string
+	DataType      string
+	KeyType       string
+	PrefixColumns string
+	// NullableColumns lists the key's NULLable columns, which rule it out
+	NullableColumns string
+	// Cardinality is the index statistics estimate, or -1 when unknown
+	Cardinality int64
+	// Selected marks the key automatic detection would pick
+	Selected bool
+}
+
+// UniqueKeyCandidates lists every unique key of the configured table in the
+// order automatic detection prefers them.
+func (c *Chunker) UniqueKeyCandidates() ([]UniqueKeyCandidate, error) {
+	rows, err := c.uniqueKeyColumns()
+	if err != nil {
+		return nil, err
+	}
+	selected := selectableKey(rows)
+	candidates := make([]UniqueKeyCandidate, 0, len(rows))
+	for i, row := range rows {
+		candidate := UniqueKeyCandidate{Cardinality: -1, Selected: i == selected}
+		candidate.IndexName, _ = row["INDEX_NAME"].(string)
+		candidate.Columns, _ = row["COLUMN_NAMES"].(string)
+		candidate.DataType, _ = row["DATA_TYPE"].(string)
+		candidate.PrefixColumns, _ = row["PREFIX_COLUMNS"].(string)
+		candidate.NullableColumns, _ = row["NULLABLE_COLUMNS"].(string)
+		candidate.KeyType = classifyKeyType(candidate.DataType, row["CHARACTER_SET_NAME"])
+		if cardinality, ok := row["CARDINALITY"].(int64); ok {
+			candidate.Cardinality = cardinality
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// PrintUniqueKeyCandidates writes candidates as a table, marking the key
+// automatic detection would pick with '*'.
+func PrintUniqueKeyCandidates(w io.Writer, candidates []UniqueKeyCandidate) {
+	if len(candidates) == 0 {
+		fmt.Fprintln(w, "-- No unique keys found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tINDEX\tCOLUMNS\tDATA TYPE\tKEY TYPE\tCARDINALITY\tPREFIX\tNULLABLE")
+	for _, k := range candidates {
+		mark := ""
+		if k.Selected {
+			mark = "*"
+		}
+		keyType := k.KeyType
+		if keyType == "" {
+			keyType = "-"
+		}
+		cardinality := "-"
+		if k.Cardinality >= 0 {
+			cardinality = fmt.Sprintf("%d", k.Cardinality)
+		}
+		prefix := k.PrefixColumns
+		if prefix == "" {
+			prefix = "-"
+		}
+		nullable := k.NullableColumns
+		if nullable == "" {
+			nullable = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", mark, k.IndexName, k.Columns, strings.ToLower(k.DataType), keyType, cardinality, prefix, nullable)
+	}
+	tw.Flush()
+	selected := false
+	for _, k := range candidates {
+		if k.Selected {
+			fmt.Fprintf(w, "-- Would chunk by %s (%s); override with --force-chunking-column\n", k.IndexName, k.Columns)
+			selected = true
+		}
+	}
+	if !selected {
+		fmt.Fprintln(w, "-- Every unique key has NULLable columns, whose NULL rows chunking would skip; pick NOT NULL columns with --force-chunking-column")
+	}
+}
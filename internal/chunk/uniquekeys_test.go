@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUniqueKeyCandidates(t *testing.T) {
+	db := &MockDB{uniqueKeyColumns: []map[string]interface{}{
+		{
+			"INDEX_NAME":            "PRIMARY",
+			"COLUMN_NAMES":          "`id`",
+			"COUNT_COLUMN_IN_INDEX": int64(1),
+			"CARDINALITY":           int64(98213),
+			"DATA_TYPE":             "bigint",
+			"CHARACTER_SET_NAME":    nil,
+		},
+		{
+			"INDEX_NAME":            "uk_email",
+			"COLUMN_NAMES":          "`email`",
+			"COUNT_COLUMN_IN_INDEX": int64(1),
+			"NULLABLE_COLUMNS":      "email",
+			"CARDINALITY":           nil,
+			"DATA_TYPE":             "varchar",
+			"CHARACTER_SET_NAME":    "utf8mb4",
+		},
+		{
+			"INDEX_NAME":            "uk_url",
+			"COLUMN_NAMES":          "`site_id`,`url`",
+			"COUNT_COLUMN_IN_INDEX": int64(2),
+			"PREFIX_COLUMNS":        "url(100)",
+			"CARDINALITY":           int64(120),
+			"DATA_TYPE":             "int",
+			"CHARACTER_SET_NAME":    nil,
+		},
+	}}
+	chunker := NewChunker(db, Config{Database: "shop", Table: "customers"})
+
+	candidates, err := chunker.UniqueKeyCandidates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("Expected 3 candidates, got %d", len(candidates))
+	}
+	if !candidates[0].Selected || candidates[1].Selected || candidates[2].Selected {
+		t.Errorf("Expected only the first candidate to be selected: %+v", candidates)
+	}
+	if candidates[1].KeyType != "text" || candidates[1].Cardinality != -1 {
+		t.Errorf("Unexpected email candidate %+v", candidates[1])
+	}
+
+	var out bytes.Buffer
+	PrintUniqueKeyCandidates(&out, candidates)
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	expected := []string{
+		"   INDEX     COLUMNS          DATA TYPE  KEY TYPE  CARDINALITY  PREFIX    NULLABLE",
+		"*  PRIMARY   `id`             bigint     integer   98213        -         -",
+		"   uk_email  `email`          varchar    text      -            -         email",
+		"   uk_url    `site_id`,`url`  int        integer   120          url(100)  -",
+		"-- Would chunk by PRIMARY (`id`); override with --force-chunking-column",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected), out.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Line %d:\n got  %q\n want %q", i+1, lines[i], expected[i])
+		}
+	}
+}
+
+func TestPrintUniqueKeyCandidatesEmpty(t *testing.T) {
+	var out bytes.Buffer
+	PrintUniqueKeyCandidates(&out, nil)
+	if out.String() != "-- No unique keys found\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestUniqueKeyCandidatesAllNullable(t *testing.T) {
+	db := &MockDB{uniqueKeyColumns: []map[string]interface{}{
+		{
+			"INDEX_NAME":            "uk_code",
+			"COLUMN_NAMES":          "`code`",
+			"COUNT_COLUMN_IN_INDEX": int64(1),
+			"NULLABLE_COLUMNS":      "code",
+			"DATA_TYPE":             "varchar",
+			"CHARACTER_SET_NAME":    "utf8mb4",
+		},
+	}}
+	candidates, err := NewChunker(db, Config{Database: "shop", Table: "codes"}).UniqueKeyCandidates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if candidates[0].Selected {
+		t.Errorf("Expected a key with NULLable columns not to be selected")
+	}
+	var out bytes.Buffer
+	PrintUniqueKeyCandidates(&out, candidates)
+	if !strings.Contains(out.String(), "-- Every unique key has NULLable columns") {
+		t.Errorf("Expected the lack of a usable key to be reported, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// deleteHeadRe matches the part of a DELETE statement up to its FROM, target
+// tables of a multi-table DELETE included.
+var deleteHeadRe = regexp.MustCompile(`(?is)^\s*DELETE\s+(?:(?:LOW_PRIORITY|QUICK|IGNORE)\s+)*(?:.*?\s)?FROM\s`)
+
+// stragglerQuery turns a chunked DELETE into a count of the rows it would
+// still delete anywhere between the range minimum and maximum.
+func (c *Chunker) stragglerQuery(executeQuery string) (string, error) {
+	if !deleteHeadRe.MatchString(executeQuery) {
+		return "", fmt.Errorf("verifying no rows remain needs a DELETE statement")
+	}
+	query := deleteHeadRe.ReplaceAllLiteralString(executeQuery, "SELECT COUNT(*) AS n FROM ")
+	return c.replaceChunkPlaceholder(query, func(cols string) string {
+		return c.remainingPredicate(cols, c.getUniqueKeyMinValuesVariables(), ">=")
+	}), nil
+}
+
+// countStragglers counts the rows a completed DELETE run left in its key
+// range, such as rows inserted behind it during the run. It reads from the
+// writer, as a reader may not have caught up with the last chunks.
+func (c *Chunker) countStragglers(query string) (int64, error) {
+	if c.reader != nil {
+		n := c.Config.CountColumnsInUniqueKey
+		vals := make([]string, 0, 2*n)
+		for _, prefix := range []string{"unique_key_min_value_", "unique_key_max_value_"} {
+			for i := 0; i < n; i++ {
+				val, err := c.getSessionVariableValue(fmt.Sprintf("%s%d", prefix, i))
+				if err != nil {
+					return 0, err
+				}
+				vals = append(vals, c.keyLiteral(val))
+			}
+		}
+		vars := c.getUniqueKeyMinValuesVariables() + "," + c.getUniqueKeyMaxValuesVariables()
+		if _, err := c.db.Exec(c.ctx, fmt.Sprintf("SELECT %s INTO %s", strings.Join(vals, ","), vars)); err != nil {
+			return 0, err
+		}
+	}
+	row, err := c.db.QueryRow(c.ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := denseKeyValue(row["n"])
+	if !ok {
+		return 0, fmt.Errorf("unexpected row count %v", row["n"])
+	}
+	return n, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunk
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+// insertAfterChunks inserts key into db once n chunks are done, as a
+// concurrent session writing behind the run would.
+func insertAfterChunks(db *simDB, n int, key int64) *recordingReporter {
+	return afterChunks(n, func() {
+		db.keys = append(db.keys, key)
+		sort.Slice(db.keys, func(i, j int) bool { return simCompare(db.keys[i], db.keys[j]) < 0 })
+	})
+}
+
+func TestVerifyNoGaps(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		insert     int64
+		stragglers int64
+	}{
+		{"no stragglers", 0, 0},
+		{"row inserted behind the run", 15, 1},
+		{"row inserted ahead of the run", 45, 0},
+		{"row inserted past the maximum", 90, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newSimDB(seqKeys(1, 50))
+			c := newSimChunker(db, 10)
+			c.Config.VerifyNoGaps = true
+			reporter := &recordingReporter{}
+			if tc.insert != 0 {
+				reporter = insertAfterChunks(db, 3, tc.insert)
+			}
+			c.SetReporter(reporter)
+			summary, err := c.ChunkUpdate(context.Background(), "DELETE FROM t WHERE GO_CHUNK(t)")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Stragglers != tc.stragglers {
+				t.Errorf("found %d stragglers, want %d", summary.Stragglers, tc.stragglers)
+			}
+		})
+	}
+}
+
+func TestVerifyNoGapsNeedsDelete(t *testing.T) {
+	db := newSimDB(seqKeys(1, 50))
+	c := newSimChunker(db, 10)
+	c.Config.VerifyNoGaps = true
+	c.SetReporter(&recordingReporter{})
+	if _, err := c.ChunkUpdate(context.Background(), "UPDATE t SET x=1 WHERE GO_CHUNK(t)"); err == nil || len(db.execs) != 0 {
+		t.Errorf("expected an UPDATE to be rejected before any chunk, got %v after %d chunks", err, len(db.execs))
+	}
+}
+
+func TestStragglerQuery(t *testing.T) {
+	c := newSimChunker(newSimDB(seqKeys(1, 5)), 10)
+	for query, want := range map[string]string{
+		"DELETE FROM t WHERE GO_CHUNK(t) AND x = 1":                           "SELECT COUNT(*) AS n FROM t WHERE id >= @unique_key_min_value_0 AND id <= @unique_key_max_value_0 AND x = 1",
+		"delete low_priority quick from t where GO_CHUNK(t)":                  "SELECT COUNT(*) AS n FROM t where id >= @unique_key_min_value_0 AND id <= @unique_key_max_value_0",
+		"DELETE t FROM t JOIN u ON u.id = t.uid WHERE GO_CHUNK(t) AND u.gone": "SELECT COUNT(*) AS n FROM t JOIN u ON u.id = t.uid WHERE id >= @unique_key_min_value_0 AND id <= @unique_key_max_value_0 AND u.gone",
+	} {
+		got, err := c.stragglerQuery(query)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q (%v), want %q", query, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package chunklog records the chunks of every run in a local SQLite
+// database, so runs can be audited and compared long after they finished.
+package chunklog
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"go-chunk-update/internal/chunk"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS chunks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  job_id TEXT NOT NULL,
+  database_name TEXT NOT NULL,
+  table_name TEXT NOT NULL,
+  chunk INTEGER NOT NULL,
+  range_start TEXT,
+  range_end TEXT,
+  affected INTEGER NOT NULL,
+  elapsed_ms INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT,
+  logged_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chunks_job_id ON chunks (job_id);
+`
+
+// Chunk statuses.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Record is one logged chunk.
+type Record struct {
+	JobID      string
+	Database   string
+	Table      string
+	Chunk      int
+	RangeStart string
+	RangeEnd   string
+	Affected   int64
+	Elapsed    time.Duration
+	Status     string
+	Error      string
+	LoggedAt   time.Time
+}
+
+// Log is a chunk log database.
+type Log struct {
+	db *sql.DB
+}
+
+// Open opens the log database at path, creating the file and schema if absent.
+func Open(path string) (*Log, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create chunk log schema in %s: %v", path, err)
+	}
+	return &Log{db: db}, nil
+}
+
+func (l *Log) Close() error {
+	return l.db.Close()
+}
+
+// Insert appends a record. A zero LoggedAt is set to the current time.
+func (l *Log) Insert(r Record) error {
+	if r.LoggedAt.IsZero() {
+		r.LoggedAt = time.Now()
+	}
+	_, err := l.db.Exec(`
+		INSERT INTO chunks (job_id, database_name, table_name, chunk, range_start, range_end, affected, elapsed_ms, status, error, logged_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.JobID, r.Database, r.Table, r.Chunk, r.RangeStart, r.RangeEnd, r.Affected, r.Elapsed.Milliseconds(), r.Status, r.Error, r.LoggedAt.UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// Records returns the records of jobID in the order they were logged.
+func (l *Log) Records(jobID string) ([]Record, error) {
+	rows, err := l.db.Query(`
+		SELECT job_id, database_name, table_name, chunk, range_start, range_end, affected, elapsed_ms, status, error, logged_at
+		FROM chunks WHERE job_id = ? ORDER BY id
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var r Record
+		var rangeStart, rangeEnd, errText sql.NullString
+		var elapsedMillis int64
+		var loggedAt string
+		if err := rows.Scan(&r.JobID, &r.Database, &r.Table, &r.Chunk, &rangeStart, &rangeEnd, &r.Affected, &elapsedMillis, &r.Status, &errText, &loggedAt); err != nil {
+			return nil, err
+		}
+		r.RangeStart, r.RangeEnd, r.Error = rangeStart.String, rangeEnd.String, errText.String
+		r.Elapsed = time.Duration(elapsedMillis) * time.Millisecond
+		if r.LoggedAt, err = time.Parse(time.RFC3339Nano, loggedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Reporter is a chunk.Reporter that logs every executed or failed chunk.
+type Reporter struct {
+	log      *Log
+	jobID    string
+	database string
+	table    string
+	failed   bool
+	// Warn reports a failure to write the log; by default it prints a
+	// warning on stderr
+	Warn func(msg string)
+}
+
+func NewReporter(log *Log, jobID, database, table string) *Reporter {
+	return &Reporter{log: log, jobID: jobID, database: database, table: table}
+}
+
+func (r *Reporter) ChunkStarted(c chunk.ChunkReport) {}
+
+func (r *Reporter) ChunkDone(c chunk.ChunkReport) {
+	r.insert(c, StatusOK, "")
+}
+
+func (r *Reporter) ChunkFailed(c chunk.ChunkReport, err error) {
+	r.insert(c, StatusFailed, err.Error())
+}
+
+func (r *Reporter) Summary(s chunk.RunSummary) {}
+
+// insert logs a chunk. Logging must not stop the run, so a failure is only
+// reported, once.
+func (r *Reporter) insert(c chunk.ChunkReport, status, errText string) {
+	err := r.log.Insert(Record{
+		JobID:      r.jobID,
+		Database:   r.database,
+		Table:      r.table,
+		Chunk:      c.Index,
+		RangeStart: fmt.Sprintf("%v", c.Start),
+		RangeEnd:   fmt.Sprintf("%v", c.End),
+		Affected:   c.Affected,
+		Elapsed:    c.Elapsed,
+		Status:     status,
+		Error:      errText,
+	})
+	if err != nil && !r.failed {
+		r.failed = true
+		msg := fmt.Sprintf("cannot write chunk log: %v", err)
+		if r.Warn != nil {
+			r.Warn(msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "-- Warning: %s\n", msg)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package chunklog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-chunk-update/internal/chunk"
+)
+
+func TestInsertAndReadBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chunklog.sqlite")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{JobID: "a1", Database: "shop", Table: "orders", Chunk: 1, RangeStart: "1", RangeEnd: "1000", Affected: 1000, Elapsed: 250 * time.Millisecond, Status: StatusOK, LoggedAt: at},
+		{JobID: "b2", Database: "shop", Table: "orders", Chunk: 1, RangeStart: "1", RangeEnd: "1000", Affected: 3, Status: StatusOK, LoggedAt: at},
+		{JobID: "a1", Database: "shop", Table: "orders", Chunk: 2, RangeStart: "1000", RangeEnd: "2000", Status: StatusFailed, Error: "deadlock", LoggedAt: at},
+	}
+	for _, r := range records {
+		if err := log.Insert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// Reopening keeps earlier runs
+	log, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	got, err := log.Records("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 records for job a1, got %d", len(got))
+	}
+	if got[0] != records[0] {
+		t.Errorf("Expected %+v, got %+v", records[0], got[0])
+	}
+	if got[1].Status != StatusFailed || got[1].Error != "deadlock" || got[1].Chunk != 2 {
+		t.Errorf("Unexpected failed record %+v", got[1])
+	}
+}
+
+func TestReporterLogsChunks(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "chunklog.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	var reporter chunk.Reporter = NewReporter(log, "job7", "shop", "orders")
+	reporter.ChunkStarted(chunk.ChunkReport{Index: 1, Start: "1", End: "10"})
+	reporter.ChunkDone(chunk.ChunkReport{Index: 1, Start: "1", End: "10", Affected: 10, Elapsed: time.Second})
+	reporter.ChunkFailed(chunk.ChunkReport{Index: 2, Start: "10", End: "20"}, errors.New("lost connection"))
+	reporter.Summary(chunk.RunSummary{Chunks: 1})
+
+	got, err := log.Records("job7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(got))
+	}
+	if got[0].Status != StatusOK || got[0].Affected != 10 || got[0].Elapsed != time.Second || got[0].RangeEnd != "10" {
+		t.Errorf("Unexpected first record %+v", got[0])
+	}
+	if got[1].Status != StatusFailed || got[1].Error != "lost connection" || got[1].RangeStart != "10" {
+		t.Errorf("Unexpected second record %+v", got[1])
+	}
+}
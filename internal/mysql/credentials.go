@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2008-2009, Shlomi Noach
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+    * Neither the name of the organization nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CredentialProvider supplies the user and password to connect with, such as
+// from a secret manager. It is called each time a connection pool is opened,
+// with the resolved settings, so it can pick the secret by host or database.
+type CredentialProvider interface {
+	Credentials(config Config) (user, password string, err error)
+}
+
+// CredentialProviderFunc adapts a function to CredentialProvider.
+type CredentialProviderFunc func(config Config) (string, string, error)
+
+func (f CredentialProviderFunc) Credentials(config Config) (string, string, error) {
+	return f(config)
+}
+
+// StaticCredentials returns the user and password already in the settings,
+// from the flags, the target URI, the defaults file or the environment.
+var StaticCredentials = CredentialProviderFunc(func(config Config) (string, string, error) {
+	return config.User, config.Password, nil
+})
+
+// execProviderPrefix selects ExecCredentials: "exec:/path/to/helper args"
+// names the command to run instead of a registered provider.
+const execProviderPrefix = "exec:"
+
+// ExecCredentials runs an external command for the credentials, so a secret
+// manager's CLI can supply them without building it into the tool. The
+// command line is split on whitespace, without a shell. It receives the
+// resolved settings as CHUNK_MYSQL_HOST, CHUNK_MYSQL_PORT,
+// CHUNK_MYSQL_DATABASE and CHUNK_MYSQL_USER, and prints a JSON object with
+// "user" and "password"; an empty or missing user keeps the configured one.
+func ExecCredentials(command string) CredentialProvider {
+	return CredentialProviderFunc(func(config Config) (string, string, error) {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return "", "", fmt.Errorf("no command to run")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = append(os.Environ(),
+			"CHUNK_MYSQL_HOST="+config.Host,
+			"CHUNK_MYSQL_PORT="+strconv.Itoa(config.Port),
+			"CHUNK_MYSQL_DATABASE="+config.Database,
+			"CHUNK_MYSQL_USER="+config.User,
+		)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", "", fmt.Errorf("%s: %v: %s", args[0], err, msg)
+			}
+			return "", "", fmt.Errorf("%s: %v", args[0], err)
+		}
+		var creds struct {
+			User     string `json:"user"`
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal(out, &creds); err != nil {
+			// The output holds the secret, so it is not quoted back
+			return "", "", fmt.Errorf("%s printed no JSON object with user and password: %v", args[0], err)
+		}
+		user := creds.User
+		if user == "" {
+			user = config.User
+		}
+		return user, creds.Password, nil
+	})
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]CredentialProvider{"static": StaticCredentials}
+)
+
+// RegisterCredentialProvider makes provider selectable by name through
+// Config.CredentialProvider. Registering a name twice replaces the provider.
+func RegisterCredentialProvider(name string, provider CredentialProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = provider
+}
+
+// CredentialProviders returns the names of the registered providers, sorted.
+func CredentialProviders() []string {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyCredentials replaces the user and password of config with those of
+// its credential provider, recording the provider as their source.
+func applyCredentials(config Config) (Config, error) {
+	if config.CredentialProvider == "" {
+		return config, nil
+	}
+	providersMu.Lock()
+	provider, ok := providers[config.CredentialProvider]
+	providersMu.Unlock()
+	if command, isExec := strings.CutPrefix(config.CredentialProvider, execProviderPrefix); isExec {
+		provider, ok = ExecCredentials(command), true
+	}
+	if !ok {
+		return config, fmt.Errorf("unknown credential provider %q (registered: %s)", config.CredentialProvider, strings.Join(CredentialProviders(), ", "))
+	}
+	user, password, err := provider.Credentials(config)
+	if err != nil {
+		return config, fmt.Errorf("credential provider %s: %v", config.CredentialProvider, err)
+	}
+	source := "credential-provider " + config.CredentialProvider
+	if user != config.User {
+		config.User = user
+		config.SetSource("user", source)
+	}
+	if password != config.Password {
+		config.Password = password
+		config.SetSource("password", source)
+	}
+	return config, nil
+}
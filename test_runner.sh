@@ -131,6 +131,7 @@ run_integration_tests() {
         -c 1000 \
         --force-chunking-column=MCID:integer \
         --sleep=10 \
+        --yes \
         -e "UPDATE large_test_table SET data = CONCAT(data, ' UPDATED') WHERE GO_CHUNK(large_test_table)" \
         2>&1 | tee /tmp/test_output.log
 
@@ -169,6 +170,7 @@ run_integration_tests() {
         -c 100 \
         --force-chunking-column=id:integer \
         --sleep=5 \
+        --yes \
         -e "DELETE FROM unit_price_summaries WHERE campaign_membership_coupon_id IN (SELECT id FROM temp_cleanup) AND GO_CHUNK(unit_price_summaries)" \
         2>&1 | tee /tmp/test_output3.log
 
@@ -201,6 +203,7 @@ run_performance_tests() {
             -c "$chunk_size" \
             --force-chunking-column=MCID:integer \
             --sleep=1 \
+            --yes \
             -e "UPDATE large_test_table SET updated_at = NOW() WHERE GO_CHUNK(large_test_table)" \
             >/dev/null 2>&1
 